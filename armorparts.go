@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The armor lines of one part of a multi-part message, RFC 4880
+// section 6.2
+var (
+	partBegin = regexp.MustCompile(`^-----BEGIN PGP MESSAGE, PART (\d+)/(\d+)-----$`)
+	partEnd   = regexp.MustCompile(`^-----END PGP MESSAGE, PART (\d+)/(\d+)-----$`)
+)
+
+// Parts beyond this are not taken for a real message
+const maxArmorParts = 1000
+
+// armorPart is one part of a multi-part armored message.
+type armorPart struct {
+	name     string
+	n, total int
+	// Armor headers, only kept from the first part
+	headers []string
+	// The radix-64 lines, and the checksum line, if any
+	data []string
+	crc  string
+}
+
+// armorPartFiles returns the files in dir, to be read as the parts of
+// a multi-part message.
+func armorPartFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no files", dir)
+	}
+
+	return names, nil
+}
+
+// joinArmorParts reads the files that hold the parts of a multi-part
+// armored message, in any order, and reassembles them into a single
+// armored message.
+func joinArmorParts(names []string) ([]byte, error) {
+	var parts []*armorPart
+	for _, name := range names {
+		p, err := readArmorPart(name)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+
+	total := parts[0].total
+	byNumber := make([]*armorPart, total+1)
+	for _, p := range parts {
+		if p.total != total {
+			return nil, fmt.Errorf("%s is part %d/%d, but %s is part %d/%d",
+				p.name, p.n, p.total, parts[0].name, parts[0].n, total)
+		}
+		if prev := byNumber[p.n]; prev != nil {
+			return nil, fmt.Errorf("%s and %s are both part %d/%d", prev.name, p.name, p.n, total)
+		}
+		byNumber[p.n] = p
+	}
+	var missing []string
+	for n := 1; n <= total; n++ {
+		if byNumber[n] == nil {
+			missing = append(missing, strconv.Itoa(n))
+		}
+	}
+	if missing != nil {
+		return nil, fmt.Errorf("missing part %s of %d", strings.Join(missing, ", "), total)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("-----BEGIN PGP MESSAGE-----\n")
+	for _, h := range byNumber[1].headers {
+		b.WriteString(h + "\n")
+	}
+	b.WriteString("\n")
+	for _, p := range byNumber[1:] {
+		for _, line := range p.data {
+			b.WriteString(line + "\n")
+		}
+	}
+	// The checksum is over the whole message, after the last part.
+	if crc := byNumber[total].crc; crc != "" {
+		b.WriteString(crc + "\n")
+	}
+	b.WriteString("-----END PGP MESSAGE-----\n")
+
+	return b.Bytes(), nil
+}
+
+// readArmorPart reads the part of a multi-part message in the file
+// called name. Text around the armor, e.g. mail headers, is skipped.
+func readArmorPart(name string) (*armorPart, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &armorPart{name: name}
+	const (
+		before = iota
+		headers
+		body
+		after
+	)
+	state := before
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		switch state {
+		case before:
+			if m := partBegin.FindStringSubmatch(line); m != nil {
+				p.n, _ = strconv.Atoi(m[1])
+				p.total, _ = strconv.Atoi(m[2])
+				if p.total < 1 || p.total > maxArmorParts || p.n < 1 || p.n > p.total {
+					return nil, fmt.Errorf("%s: bad part number %s/%s", name, m[1], m[2])
+				}
+				state = headers
+			}
+		case headers:
+			if line == "" {
+				state = body
+			} else {
+				p.headers = append(p.headers, line)
+			}
+		case body:
+			switch {
+			case partEnd.MatchString(line):
+				if m := partEnd.FindStringSubmatch(line); m[1] != strconv.Itoa(p.n) ||
+					m[2] != strconv.Itoa(p.total) {
+					return nil, fmt.Errorf("%s: part %d/%d ends as part %s/%s",
+						name, p.n, p.total, m[1], m[2])
+				}
+				state = after
+			case strings.HasPrefix(line, "="):
+				p.crc = line
+			case line != "":
+				p.data = append(p.data, line)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	switch state {
+	case before:
+		return nil, fmt.Errorf("%s: not a part of a multi-part armored message", name)
+	case after:
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("%s: part %d/%d is truncated", name, p.n, p.total)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// auditRecord is a line of the -audit-log, one per operation.
+type auditRecord struct {
+	Time        time.Time `json:"time"`
+	Op          string    `json:"op"`
+	UID         int       `json:"uid"`
+	Input       string    `json:"input"`
+	InputSHA256 string    `json:"input_sha256"`
+	BytesIn     int64     `json:"bytes_in"`
+	Outputs     []string  `json:"outputs,omitempty"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+	Cipher      string    `json:"cipher,omitempty"`
+	S2K         string    `json:"s2k,omitempty"`
+	Integrity   bool      `json:"integrity"`
+	SignerKeyID string    `json:"signer_key_id,omitempty"`
+	Signer      string    `json:"signer,omitempty"`
+	// Whether the signer's key was fetched, not in the key ring
+	SignerFetched bool `json:"signer_fetched,omitempty"`
+	// From the signature's subpackets
+	SignatureTime    *time.Time        `json:"signature_time,omitempty"`
+	SignatureExpires *time.Time        `json:"signature_expires,omitempty"`
+	SignerUserID     string            `json:"signer_user_id,omitempty"`
+	Notations        map[string]string `json:"notations,omitempty"`
+}
+
+// auditLog records an operation to the -audit-log, once it is over.
+type auditLog struct {
+	w   io.Writer
+	rec auditRecord
+	// Of the input, as it is read; if the operation fails, the input
+	// may not have been read to the end.
+	h hash.Hash
+	n int64
+
+	once sync.Once
+}
+
+// openAudit opens the -audit-log for op, appending to it, or returns
+// nil if there is none. "syslog" sends the records to the system log
+// instead. If the program exits on an error, the record is written
+// with the error.
+func openAudit(op string) (*auditLog, error) {
+	if auditFile == "" {
+		return nil, nil
+	}
+
+	var w io.Writer
+	if auditFile == "syslog" {
+		sw, err := openSyslog()
+		if err != nil {
+			return nil, err
+		}
+		w = sw
+	} else {
+		fd, err := os.OpenFile(auditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = fd
+	}
+
+	a := &auditLog{
+		w: w,
+		rec: auditRecord{
+			Op:      op,
+			UID:     os.Getuid(),
+			Input:   inputName(),
+			Outputs: outputs,
+		},
+		h: sha256.New(),
+	}
+	atExit(func() {
+		a.finish(nil, exitMessage)
+	})
+
+	return a, nil
+}
+
+// reader returns r, hashing what is read through it for the record.
+func (a *auditLog) reader(r io.Reader) io.Reader {
+	if a == nil {
+		return r
+	}
+
+	return io.TeeReader(r, writerFunc(func(p []byte) (int, error) {
+		a.n += int64(len(p))
+		return a.h.Write(p)
+	}))
+}
+
+// finish writes the record, with what is known of the message from
+// res, if any, and the error message reason if the operation failed.
+// Only the first call has any effect.
+func (a *auditLog) finish(res *symcrypt.Result, reason string) {
+	if a == nil {
+		return
+	}
+
+	a.once.Do(func() {
+		rec := a.rec
+		rec.Time = time.Now().UTC()
+		rec.InputSHA256 = hex.EncodeToString(a.h.Sum(nil))
+		rec.BytesIn = a.n
+		rec.Result = "ok"
+		if reason != "" {
+			rec.Result = "error"
+			rec.Error = reason
+		}
+		if res != nil {
+			if res.Cipher != 0 {
+				rec.Cipher = res.Cipher.String()
+			}
+			if res.SKESKIndex >= 0 {
+				rec.S2K = res.S2K.String()
+			}
+			rec.Integrity = res.Integrity
+			if res.Signed {
+				rec.SignerKeyID = fmt.Sprintf("%016X", res.SignerKeyID)
+			}
+			if res.SignedBy != nil {
+				rec.Signer = fmt.Sprintf("%X", res.SignedBy.PublicKey.Fingerprint)
+				rec.SignerFetched = res.SignerLookedUp
+			}
+			if t := res.SignatureTime.UTC(); !res.SignatureTime.IsZero() {
+				rec.SignatureTime = &t
+				if res.SignatureLifetime != 0 {
+					exp := t.Add(res.SignatureLifetime)
+					rec.SignatureExpires = &exp
+				}
+			}
+			rec.SignerUserID = res.SignerUserID
+			for _, n := range res.Notations {
+				if rec.Notations == nil {
+					rec.Notations = make(map[string]string)
+				}
+				rec.Notations[n.Name], _ = notationValue(n)
+			}
+		}
+
+		b, _ := json.Marshal(rec)
+		a.w.Write(append(b, '\n'))
+	})
+}
+
+// writerFunc adapts a function to an io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// Extensions of encrypted files, which -auto-output takes off
+var cipherTextExts = []string{".gpg", ".pgp", ".asc", ".enc", ".sbox", ".scrypt", ".p7m"}
+
+// Whether autoOutput named the output
+var autoNamed bool
+
+// autoOutput sets the output, with -auto-output or -outdir and no
+// -output, to a name made from the -filename: without its extension
+// when decrypting, with one added when encrypting, in the -outdir if
+// one is given. It never names a file that exists.
+func autoOutput(encrypting, armored bool) {
+	if outDir != "" && len(outputs) != 0 {
+		exitf(exitUsage, "-outdir cannot be combined with -output")
+	}
+	if !autoName && outDir == "" || len(outputs) != 0 {
+		return
+	}
+	if _, ok := parseURL(filename); ok || filename == "" || filename == "-" {
+		exitf(exitUsage, "-auto-output and -outdir need a -filename that is a local file")
+	}
+
+	name, err := autoOutputName(filename, encrypting, armored)
+	if err != nil {
+		exitf(exitUsage, "%v", err)
+	}
+	if outDir != "" {
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			fatalf("Output: %v", err)
+		}
+		name = filepath.Join(outDir, filepath.Base(name))
+	}
+	if _, err := os.Lstat(name); err == nil {
+		fatalf("Output: %s already exists; give -output to choose another name", name)
+	}
+	outputs = stringList{name}
+	autoNamed = true
+}
+
+// autoOutputExt takes ext, e.g. .gz, off the name -auto-output gave the
+// output, if it ends in it, once -auto-decompress finds the plain text
+// compressed, telling whether it did.
+func autoOutputExt(ext string) bool {
+	if !autoNamed {
+		return false
+	}
+	name := outputs[0]
+	if len(name) <= len(ext) || !strings.EqualFold(name[len(name)-len(ext):], ext) {
+		return false
+	}
+	name = name[:len(name)-len(ext)]
+	if _, err := os.Lstat(name); err == nil {
+		fatalf("Output: %s already exists; give -output to choose another name", name)
+	}
+	outputs[0] = name
+
+	return true
+}
+
+// autoOutputName returns the name -auto-output gives the output for
+// input.
+func autoOutputName(input string, encrypting, armored bool) (string, error) {
+	if encrypting {
+		switch encryptFormat {
+		case formatOpenSSL:
+			return input + ".enc", nil
+		case formatSecretbox:
+			return input + ".sbox", nil
+		}
+		if armored {
+			return input + ".asc", nil
+		}
+		return input + ".gpg", nil
+	}
+
+	for _, ext := range cipherTextExts {
+		if len(input) > len(ext) && strings.EqualFold(input[len(input)-len(ext):], ext) &&
+			!strings.HasSuffix(input[:len(input)-len(ext)], string(os.PathSeparator)) {
+			return input[:len(input)-len(ext)], nil
+		}
+	}
+
+	last := len(cipherTextExts) - 1
+	return "", fmt.Errorf("Cannot name the output after %s, which does not end in %s or %s; give -output",
+		input, strings.Join(cipherTextExts[:last], ", "), cipherTextExts[last])
+}
+
+// outDirPath returns where the output name, made from the name of an
+// input of a batch, goes in the -outdir: at the same path, taken as
+// relative to it, as an absolute one is, whose directories are created.
+// It may not lead out of the -outdir.
+func outDirPath(name string) (string, error) {
+	rel := name
+	if filepath.IsAbs(rel) {
+		rel = strings.TrimLeft(rel[len(filepath.VolumeName(rel)):], `/\`)
+	}
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s would be outside the -outdir %s", name, outDir)
+	}
+
+	path := filepath.Join(outDir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// templateData is what an -output-template names an output with.
+type templateData struct {
+	// The input's base name, and that without its .gpg, .pgp or .asc
+	Name, Stem string
+	// The base name the message records for its plain text, if any
+	FileName string
+	// The modification time the message records, or else the input's,
+	// and its date as 2006-01-02
+	ModTime time.Time
+	Date    string
+}
+
+// parseOutputTemplate parses the -output-template, and tries it, so that
+// a field that does not exist is found before anything is decrypted.
+func parseOutputTemplate(text string) (*template.Template, error) {
+	t, err := template.New("output").Option("missingkey=error").Parse(text)
+	if err == nil {
+		err = t.Execute(io.Discard, templateData{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Bad -output-template: %v", err)
+	}
+
+	return t, nil
+}
+
+// templateName returns the name that t gives the output of the message
+// res was decrypted from, in the file input. It must be a file name,
+// which cannot lead to another directory, whatever name the message,
+// which anyone may have made, records.
+func templateName(t *template.Template, input string, res *symcrypt.Result) (string, error) {
+	data := templateData{Name: filepath.Base(input), ModTime: res.ModTime}
+	data.Stem = data.Name
+	if stem, err := autoOutputName(data.Name, false, false); err == nil {
+		data.Stem = stem
+	}
+	if base := filepath.Base(filepath.FromSlash(res.FileName)); res.FileName != "" &&
+		base != "." && base != ".." && base != string(filepath.Separator) {
+		data.FileName = base
+	}
+	if data.ModTime.IsZero() {
+		if fi, err := os.Stat(input); err == nil {
+			data.ModTime = fi.ModTime()
+		}
+	}
+	data.Date = data.ModTime.Format("2006-01-02")
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("-output-template: %v", err)
+	}
+	name := b.String()
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("-output-template gives %q, which is not a file name", name)
+	}
+
+	return name, nil
+}
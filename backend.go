@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	xerrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// The -backend that decrypts with symcrypt, and all that goes with it
+const defaultBackend = "symcrypt"
+
+// backends are the OpenPGP implementations that -backend selects from,
+// besides symcrypt, to compare their results and speed on the same
+// messages, e.g. when migrating from one to another. Each decrypts r to
+// w with pass and nothing else: no key rings, no reports on the message
+// but whether it was integrity protected. Those that need other modules
+// register themselves from files built with a tag.
+var backends = map[string]func(w io.Writer, r io.Reader, pass []byte) (integrity bool, err error){
+	"xcrypto": decryptXCrypto,
+}
+
+// backendNames returns the names -backend accepts.
+func backendNames() string {
+	names := []string{defaultBackend}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+
+	return strings.Join(names, ", ")
+}
+
+// checkBackend refuses a -backend that is not built in, and flags that
+// only symcrypt implements.
+func checkBackend() {
+	if backendName == defaultBackend {
+		return
+	}
+	if _, ok := backends[backendName]; !ok {
+		hint := ""
+		if backendName == "gocrypto" {
+			hint = " (build with -tags gocrypto)"
+		}
+		exitf(exitUsage, "Unknown -backend %q%s: this build has %s", backendName, hint, backendNames())
+	}
+
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-keyring", keyringFile != ""},
+		{"-unwrap-nested", unwrapDepth > 0},
+		{"-fips", fipsMode},
+		{"-allow-legacy-ciphers", legacyCipher},
+		{"-delete-after", deleteAfter},
+		{"-summary", showSummary},
+		{"-no-decompress", noDecompress},
+		{"-debug-packets", debugPackets},
+	} {
+		if f.set {
+			exitf(exitUsage, "%s needs -backend %s", f.name, defaultBackend)
+		}
+	}
+}
+
+// backendPassphrase returns a prompt that gives a -backend pass once.
+// The implementations ask again when it does not decrypt the message,
+// and are then told it is wrong.
+func backendPassphrase(pass []byte) func() ([]byte, error) {
+	asked := false
+	return func() ([]byte, error) {
+		if asked {
+			return nil, symcrypt.ErrWrongPassphrase
+		}
+		asked = true
+		return pass, nil
+	}
+}
+
+// dearmorBackend returns r, decoded if it is ASCII armored, with the
+// armor decoder decode.
+func dearmorBackend(r io.Reader, decode func(io.Reader) (io.Reader, error)) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len("-----BEGIN PGP "))
+	if !bytes.Equal(head, []byte("-----BEGIN PGP ")) {
+		return br, nil
+	}
+	body, err := decode(br)
+	if err != nil {
+		return nil, err
+	}
+
+	return bufio.NewReader(body), nil
+}
+
+// integrityProtected tells whether the binary message in br is integrity
+// protected, from the tag of the packet after its session key packets,
+// before a backend decrypts any of it. As symcrypt does, it refuses one
+// that is not without -allow-no-mdc, or one whose session key packets
+// fill br, so that what follows them cannot be told.
+func integrityProtected(br *bufio.Reader) (bool, error) {
+	data, _ := br.Peek(br.Size())
+	if len(data) == 0 || data[0]&0x80 == 0 {
+		// Not OpenPGP, which the backend fails on
+		return false, nil
+	}
+	for {
+		tag := int(data[0]>>2) & 0xf
+		if data[0]&0x40 != 0 {
+			tag = int(data[0] & 0x3f)
+		}
+		switch tag {
+		case 18, 20:
+			// With an MDC, or AEAD
+			return true, nil
+		case 1, 3, 10:
+			// Session key and marker packets
+		default:
+			if allowNoMDC {
+				return false, nil
+			}
+			return false, symcrypt.ErrNoIntegrityProtection
+		}
+
+		_, _, rest, err := symcrypt.SplitPacket(data)
+		if err != nil || len(rest) == 0 {
+			if allowNoMDC {
+				return false, nil
+			}
+			return false, fmt.Errorf("%w: no encrypted data packet in the first %d bytes", symcrypt.ErrNoIntegrityProtection, len(data))
+		}
+		data = rest
+	}
+}
+
+func decryptXCrypto(w io.Writer, r io.Reader, pass []byte) (bool, error) {
+	br, err := dearmorBackend(r, func(r io.Reader) (io.Reader, error) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return block.Body, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("xcrypto: %w", err)
+	}
+	integrity, err := integrityProtected(br)
+	if err != nil {
+		return false, fmt.Errorf("xcrypto: %w", err)
+	}
+
+	prompt := backendPassphrase(pass)
+	md, err := openpgp.ReadMessage(br, openpgp.EntityList{}, func([]openpgp.Key, bool) ([]byte, error) {
+		return prompt()
+	}, nil)
+	if err != nil {
+		return false, fmt.Errorf("xcrypto: %w", err)
+	}
+	if _, err := io.Copy(w, md.UnverifiedBody); err != nil {
+		return false, fmt.Errorf("xcrypto: %w", err)
+	}
+
+	return integrity, nil
+}
+
+// isStructuralError tells whether err is x/crypto's opaque "invalid
+// data" error, for a message it cannot parse.
+func isStructuralError(err error) bool {
+	var se xerrors.StructuralError
+	return errors.As(err, &se)
+}
@@ -0,0 +1,51 @@
+//go:build gocrypto
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+func init() {
+	backends["gocrypto"] = decryptGoCrypto
+}
+
+// decryptGoCrypto decrypts with the ProtonMail fork of x/crypto/openpgp,
+// which is maintained and reads RFC 9580 messages too.
+func decryptGoCrypto(w io.Writer, r io.Reader, pass []byte) (bool, error) {
+	br, err := dearmorBackend(r, func(r io.Reader) (io.Reader, error) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return block.Body, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("gocrypto: %w", err)
+	}
+	integrity, err := integrityProtected(br)
+	if err != nil {
+		return false, fmt.Errorf("gocrypto: %w", err)
+	}
+
+	prompt := backendPassphrase(pass)
+	// Which refuses a message without an MDC itself, unless told that
+	// -allow-no-mdc lets it through
+	config := &packet.Config{InsecureAllowUnauthenticatedMessages: !integrity}
+	md, err := openpgp.ReadMessage(br, openpgp.EntityList{}, func([]openpgp.Key, bool) ([]byte, error) {
+		return prompt()
+	}, config)
+	if err != nil {
+		return false, fmt.Errorf("gocrypto: %w", err)
+	}
+	if _, err := io.Copy(w, md.UnverifiedBody); err != nil {
+		return false, fmt.Errorf("gocrypto: %w", err)
+	}
+
+	return integrity, nil
+}
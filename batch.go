@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// What -if-exists does when a -files-from output exists: fail it, skip
+// its input, replace it, write it as NAME.1, NAME.2..., or replace it,
+// keeping the old one as NAME~. It is only replaced, or moved, once
+// its new contents have been decrypted and verified.
+const (
+	existsFail      = "fail"
+	existsSkip      = "skip"
+	existsOverwrite = "overwrite"
+	existsNumbered  = "numbered"
+	existsBackup    = "backup"
+)
+
+var existsPolicies = []string{existsFail, existsSkip, existsOverwrite, existsNumbered, existsBackup}
+
+var errSkipped = errors.New("skipped")
+
+// existingOutput returns the name to give the output out, by the
+// -if-exists if it exists, and what was done, as the manifest status,
+// which is empty if it does not.
+func existingOutput(out string) (string, string, error) {
+	if _, err := os.Lstat(out); err != nil {
+		return out, "", nil
+	}
+
+	switch ifExists {
+	case existsSkip:
+		return out, "skipped", errSkipped
+	case existsOverwrite:
+		return out, "overwritten", nil
+	case existsBackup:
+		return out, "backup", nil
+	case existsNumbered:
+		for i := 1; ; i++ {
+			name := fmt.Sprintf("%s.%d", out, i)
+			if _, err := os.Lstat(name); err != nil {
+				return name, "numbered", nil
+			}
+		}
+	}
+
+	return out, "", fmt.Errorf("%s already exists", out)
+}
+
+// readFileList reads the names in r, one per line, or separated by NUL
+// bytes with nul, as find -print0 writes them, so that any name that a
+// file can have gets through. Empty names are skipped.
+func readFileList(r io.Reader, nul bool) ([]string, error) {
+	sep := byte('\n')
+	if nul {
+		sep = 0
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) != 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	var names []string
+	for sc.Scan() {
+		name := sc.Text()
+		if !nul {
+			name = strings.TrimSuffix(name, "\r")
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, sc.Err()
+}
+
+// decryptFiles decrypts each of the -files-from, beside it with the
+// name -auto-output would give it, into the -output directory, or at
+// the same path under the -outdir. A file that fails is reported, and
+// the others are still decrypted.
+func decryptFiles(ctx context.Context) {
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-filename", filename != ""},
+		{"-part", len(armorParts) != 0},
+		{"-delete-after", deleteAfter},
+		{"-in-place", inPlace},
+		{"-sandbox", sandbox},
+		{"-run-as", runAs != ""},
+		{"-verify-before-output", verifyFirst},
+		{"-unwrap-nested", unwrapDepth > 0},
+		{"-auto-decompress", autoDecomp},
+		{"-audit-log", auditFile != ""},
+		{"-summary", showSummary},
+		{"-backend", backendName != defaultBackend},
+		{"-compare", compareFile != ""},
+		{"-messages", messagesMode != messagesFirst},
+	} {
+		if f.set {
+			exitf(exitUsage, "-files-from cannot be combined with %s", f.name)
+		}
+	}
+	dir := ""
+	switch len(outputs) {
+	case 0:
+	case 1:
+		if fi, err := os.Stat(outputs[0]); err != nil || !fi.IsDir() {
+			exitf(exitUsage, "-files-from needs -output to be a directory, here %s", outputs[0])
+		}
+		dir = outputs[0]
+	default:
+		exitf(exitUsage, "-files-from takes at most one -output, a directory")
+	}
+	if outDir != "" && dir != "" {
+		exitf(exitUsage, "-outdir cannot be combined with -output")
+	}
+	var tmpl *template.Template
+	if outTemplate != "" {
+		var err error
+		if tmpl, err = parseOutputTemplate(outTemplate); err != nil {
+			exitf(exitUsage, "%v", err)
+		}
+	}
+
+	if !slices.Contains(existsPolicies, ifExists) {
+		exitf(exitUsage, "Bad -if-exists %q: want one of %s", ifExists, strings.Join(existsPolicies, ", "))
+	}
+
+	var list io.Reader = os.Stdin
+	if filesFrom != "-" {
+		fd, err := os.Open(filesFrom)
+		if err != nil {
+			fatalf("Files from: %v", err)
+		}
+		defer fd.Close()
+		list = fd
+	}
+	names, err := readFileList(list, nulSeparated)
+	if err != nil {
+		fatalf("Files from: %v", err)
+	}
+
+	// One passphrase provider, which asks once, for all of them
+	d := newDecryptor(passphrase)
+	man := openManifest("decrypt")
+	failed, skipped := 0, 0
+	for _, name := range names {
+		out, err := autoOutputName(name, false, false)
+		switch {
+		case err != nil:
+		case dir != "":
+			out = filepath.Join(dir, filepath.Base(out))
+		case outDir != "":
+			out, err = outDirPath(out)
+		}
+		if err == nil {
+			rec := man.start(name, out)
+			out, err = decryptFile(ctx, d, name, out, tmpl, rec)
+			if errors.Is(err, errSkipped) {
+				rec.skipped()
+				log.Printf("Skipping %s, as %s exists", name, out)
+				skipped++
+				continue
+			}
+			rec.done(err)
+		}
+		if err != nil && jsonErrors {
+			reportError(errorCode(err), name, fmt.Sprintf("Decrypt %s: %v", name, err), []interface{}{err})
+		}
+		if err != nil {
+			if !jsonErrors || logFile != "" || logTarget != logStderr {
+				log.Printf("Decrypt %s: %v", name, err)
+			}
+			failed++
+			continue
+		}
+		verbosef("Decrypted %s to %s", name, out)
+	}
+
+	log.Printf("Decrypted %d files, %d skipped", len(names)-failed-skipped, skipped)
+	if failed != 0 {
+		fatalf("Decrypt: %d files failed", failed)
+	}
+	if err := man.write(); err != nil {
+		fatalf("Manifest: %v", err)
+	}
+}
+
+// decryptFile decrypts the file name to out, or, with an
+// -output-template, to the name it gives in the same directory, as the
+// -if-exists has it if that exists, and returns the name of the output.
+func decryptFile(ctx context.Context, d *symcrypt.Decryptor, name, out string, tmpl *template.Template,
+	rec *manifestRecord) (string, error) {
+	target, status := out, ""
+	if tmpl == nil {
+		var err error
+		if target, status, err = existingOutput(out); err != nil {
+			return target, err
+		}
+	}
+
+	in, err := openSource(name)
+	if err != nil {
+		return target, err
+	}
+	defer in.Close()
+
+	// A file that exists is only replaced once the new one is complete,
+	// and the template's name is only known then
+	var o *outputFile
+	if status != "" || tmpl != nil {
+		o, err = createStaged(target)
+	} else {
+		o, err = createOutput(target)
+	}
+	if err != nil {
+		return target, err
+	}
+	var w io.Writer = o
+	if rec != nil {
+		w = io.MultiWriter(o, rec)
+	}
+	res, err := d.DecryptContext(ctx, w, in)
+	if err == nil && !res.Integrity {
+		warnf("%s was not integrity protected", name)
+	}
+	if err == nil && tmpl != nil {
+		var final string
+		if final, err = templateName(tmpl, name, &res); err == nil {
+			target, status, err = existingOutput(filepath.Join(filepath.Dir(out), final))
+			o.final = target
+		}
+	}
+	if err == nil && !res.Integrity {
+		o.unverified()
+	}
+	if err == nil && status == "backup" {
+		err = backupOutput(target)
+	}
+	if err == nil {
+		rec.renamed(target, status)
+		err = o.commit()
+	}
+	if err != nil {
+		o.discard()
+	}
+
+	return target, err
+}
+
+// backupOutput keeps the file name, which is about to be replaced, as
+// name~, replacing any older one.
+func backupOutput(name string) error {
+	backup := name + "~"
+	if err := os.Remove(backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return os.Link(name, backup)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+func init() {
+	subcommands["bench"] = bench
+}
+
+// benchRun is what decrypting the message with one -backend came to.
+type benchRun struct {
+	name string
+	took time.Duration
+	n    int64
+	sum  [sha256.Size]byte
+	err  error
+}
+
+// bench decrypts the message in the file named in args, or -filename,
+// or on stdin, with every backend built in, each -count times, and
+// reports their throughputs and whether their plain texts match
+// symcrypt's, e.g. before moving from one to another.
+func bench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric [flags] bench [-count 3] [FILE]")
+		fs.PrintDefaults()
+	}
+	count := fs.Int("count", 3, "Times to decrypt with each backend, of which the fastest is reported")
+	configureSubcommand(fs, "bench", args)
+	name := filename
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		name = fs.Arg(0)
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	if *count < 1 {
+		exitf(exitUsage, "Bad -count %d: decrypting at least once", *count)
+	}
+
+	if name == "" {
+		name = "-"
+	}
+	fd, err := openSource(name)
+	if err != nil {
+		fatalf("Input: %v", err)
+	}
+	// Read up front, so that the input is not timed
+	msg, err := io.ReadAll(fd)
+	fd.Close()
+	if err != nil {
+		fatalf("Input: %v", err)
+	}
+	provider, _ := passphraseProvider(passphrase, "decrypt")
+	pass, err := provider.Passphrase()
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	policy := symcrypt.IntegrityRequired
+	if allowNoMDC {
+		policy = symcrypt.IntegrityOptional
+	}
+	d := symcrypt.NewDecryptor(symcrypt.WithPassphrase(pass), symcrypt.WithIntegrityPolicy(policy))
+	var res symcrypt.Result
+	ref := benchBackend(defaultBackend, *count, func(w io.Writer) (err error) {
+		res, err = d.Decrypt(w, bytes.NewReader(msg))
+		return err
+	})
+	if ref.err != nil {
+		decryptFailed(&res, ref.err)
+		copyFailed(nil, ref.err)
+	}
+	runs := []benchRun{ref}
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		decrypt := backends[name]
+		runs = append(runs, benchBackend(name, *count, func(w io.Writer) error {
+			_, err := decrypt(w, bytes.NewReader(msg), pass)
+			return err
+		}))
+	}
+
+	code := 0
+	fmt.Printf("%-10s %12s %12s  %s\n", "backend", "time", "throughput", "plain text")
+	for _, r := range runs {
+		if r.err != nil {
+			fmt.Printf("%-10s %12s %12s  %v\n", r.name, "-", "-", r.err)
+			code = exitFailure
+			continue
+		}
+		match := "the same"
+		switch {
+		case r.name == defaultBackend:
+			match = fmt.Sprintf("%d bytes, SHA-256 %x", r.n, r.sum[:8])
+		case r.sum != ref.sum:
+			match = fmt.Sprintf("DIFFERS: %d bytes, SHA-256 %x", r.n, r.sum[:8])
+			code = exitFailure
+		}
+		fmt.Printf("%-10s %12v %10s/s  %s\n", r.name, r.took.Round(time.Microsecond),
+			humanBytes(float64(len(msg))/r.took.Seconds()), match)
+	}
+	if code != 0 {
+		fmt.Fprintln(os.Stderr, "The backends do not all decrypt the message to the same plain text.")
+	}
+
+	return code
+}
+
+// benchBackend decrypts with decrypt count times, keeping the fastest
+// time and a digest of the plain text.
+func benchBackend(name string, count int, decrypt func(io.Writer) error) benchRun {
+	r := benchRun{name: name}
+	for range count {
+		h := sha256.New()
+		w := &countingWriter{w: h}
+		start := time.Now()
+		if r.err = decrypt(w); r.err != nil {
+			return r
+		}
+		if took := time.Since(start); r.took == 0 || took < r.took {
+			r.took = took
+		}
+		r.n = w.n
+		h.Sum(r.sum[:0])
+	}
+
+	return r
+}
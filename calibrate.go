@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+func init() {
+	subcommands["s2k-calibrate"] = s2kCalibrate
+}
+
+// s2kCalibrate times the iterated and salted S2K, as encrypt uses it,
+// and Argon2id, as encrypt -format secretbox does, on this machine, and
+// prints the flags that take about -target to derive a key.
+func s2kCalibrate(args []string) int {
+	fs := flag.NewFlagSet("s2k-calibrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric s2k-calibrate [-target 1s] [-argon2-memory 64] [-argon2-threads 4]")
+		fs.PrintDefaults()
+	}
+	target := fs.Duration("target", time.Second, "Derivation time to aim for")
+	memory := fs.Uint("argon2-memory", secretboxMemory>>10,
+		"Argon2id memory to start from, in MiB, doubled while the largest number of passes is too fast")
+	threads := fs.Uint("argon2-threads", secretboxThreads, "Argon2id lanes")
+	configureSubcommand(fs, "s2k-calibrate", args)
+	if fs.NArg() != 0 || *target <= 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	start := argon2Params{Time: 1, Memory: uint32(*memory << 10), Threads: uint8(*threads)}
+	if *memory > maxSecretboxMemory>>10 || *threads > 255 {
+		exitf(exitUsage, "Argon2id parameters out of range: at most %d MiB and 255 lanes", maxSecretboxMemory>>10)
+	}
+	if err := start.check(); err != nil {
+		exitf(exitUsage, "%v", err)
+	}
+
+	counts := symcrypt.S2KCounts()
+	fmt.Printf("%-10s %12s\n", "count", "time")
+	for i := 0; i < len(counts); i += 16 {
+		fmt.Printf("%-10d %12v\n", counts[i], symcrypt.TimeS2K(counts[i]).Round(time.Microsecond))
+	}
+
+	best, err := symcrypt.CalibrateS2K(*target)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	took := symcrypt.TimeS2K(best.Count)
+
+	fmt.Println()
+	if best.Count == counts[len(counts)-1] && took < *target {
+		fmt.Printf("Even the largest count takes only %v here.\n", took.Round(time.Millisecond))
+	} else {
+		fmt.Printf("About %v:\n", took.Round(time.Millisecond))
+	}
+	fmt.Printf("\tdecrypt-symmetric encrypt -s2k-count %d\n", best.Count)
+
+	argon2, err := symcrypt.CalibrateArgon2(*target, symcrypt.Argon2Params(start))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	took = symcrypt.TimeArgon2(argon2)
+	fmt.Println()
+	switch {
+	case argon2.Time == 1 && took > *target:
+		fmt.Printf("Argon2id: even one pass over %d MiB takes %v here; a smaller -argon2-memory is faster.\n",
+			argon2.Memory>>10, took.Round(time.Millisecond))
+	case argon2.Time == symcrypt.MaxArgon2Time && took < *target:
+		fmt.Printf("Argon2id: even %d passes over %d MiB take only %v here.\n",
+			argon2.Time, argon2.Memory>>10, took.Round(time.Millisecond))
+	default:
+		fmt.Printf("Argon2id, about %v:\n", took.Round(time.Millisecond))
+	}
+	fmt.Printf("\tdecrypt-symmetric encrypt -format secretbox -argon2-time %d -argon2-memory %d -argon2-threads %d\n",
+		argon2.Time, argon2.Memory>>10, argon2.Threads)
+	fmt.Println("OpenPGP messages cannot use Argon2: it needs the RFC 9580 message format, which encrypt does not produce.")
+
+	return 0
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// CMS enveloped data, RFC 5652, for a passphrase: a password recipient
+// info, RFC 3211, holds the content encryption key, wrapped with a key
+// derived from the passphrase with PBKDF2, and the content follows in
+// CBC mode, with no MAC. It is read, in DER or PEM, as a stream of
+// BER, which the indefinite lengths that openssl cms -stream writes
+// take.
+var (
+	oidEnvelopedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+	oidPBKDF2            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidPWRIKEK           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 3, 9}
+)
+
+// The DER of the OIDs that CMS content info starts with: those of the
+// PKCS #7 content types, and of authenticated enveloped data, which is
+// recognized to be refused
+var cmsOIDPrefixes = [][]byte{
+	{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07},
+	{0x06, 0x0b, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x09, 0x10, 0x01, 0x17},
+}
+
+var cmsPEMTypes = []string{"-----BEGIN CMS-----", "-----BEGIN PKCS7-----"}
+
+// The ciphers of the content and of the key encryption, by OID
+var cmsCiphers = map[string]struct {
+	name     string
+	keySize  int
+	newBlock func([]byte) (cipher.Block, error)
+}{
+	"2.16.840.1.101.3.4.1.2":  {"AES-128", 16, aes.NewCipher},
+	"2.16.840.1.101.3.4.1.22": {"AES-192", 24, aes.NewCipher},
+	"2.16.840.1.101.3.4.1.42": {"AES-256", 32, aes.NewCipher},
+	"1.2.840.113549.3.7":      {"3DES", 24, des.NewTripleDESCipher},
+}
+
+// The PBKDF2 PRFs, by OID, HMAC-SHA1 by default
+var cmsPRFs = map[string]func() hash.Hash{
+	"1.2.840.113549.2.7":  sha1.New,
+	"1.2.840.113549.2.8":  sha256.New224,
+	"1.2.840.113549.2.9":  sha256.New,
+	"1.2.840.113549.2.10": sha512.New384,
+	"1.2.840.113549.2.11": sha512.New,
+}
+
+// Most PBKDF2 iterations accepted from a message, so that one cannot
+// make decryption take any time
+const maxCMSIter = 10000000
+
+var (
+	// No password recipient's check bytes matched: most likely a wrong
+	// passphrase
+	errCMSKey = errors.New("cms: the passphrase unwraps no password recipient's key")
+	// A key was unwrapped, but the content does not decrypt with it
+	errCMSPadding = errors.New("cms: bad padding at the end of the content: it is corrupt")
+)
+
+type passwordRecipientInfo struct {
+	Version       int
+	KeyDerivation pkix.AlgorithmIdentifier `asn1:"optional,tag:0"`
+	KeyEncryption pkix.AlgorithmIdentifier
+	EncryptedKey  []byte
+}
+
+type pbkdf2Params struct {
+	Salt   []byte
+	Iter   int
+	KeyLen int                      `asn1:"optional"`
+	PRF    pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// isCMS tells whether br starts with CMS content info, in DER or PEM.
+func isCMS(head []byte) bool {
+	for _, t := range cmsPEMTypes {
+		if bytes.HasPrefix(head, []byte(t)) {
+			return true
+		}
+	}
+	if len(head) < 2 || head[0] != 0x30 {
+		return false
+	}
+	off := 2
+	if head[1] > 0x80 {
+		off += int(head[1] & 0x7f)
+	}
+	if off > len(head) {
+		return false
+	}
+	for _, p := range cmsOIDPrefixes {
+		if bytes.HasPrefix(head[off:], p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decryptCMS decrypts the CMS enveloped data r with pass to w, with the
+// first of its password recipients whose key pass unwraps.
+func decryptCMS(w io.Writer, r io.Reader, pass []byte) error {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(11); bytes.HasPrefix(head, []byte("-----BEGIN ")) {
+		if _, err := br.ReadString('\n'); err != nil {
+			return fmt.Errorf("cms: PEM: %w", io.ErrUnexpectedEOF)
+		}
+		br = bufio.NewReader(base64.NewDecoder(base64.StdEncoding, &pemBody{br: br}))
+	}
+	ber := &berReader{br: br}
+
+	if err := ber.open(0x30, "content info"); err != nil {
+		return err
+	}
+	var typ asn1.ObjectIdentifier
+	if err := ber.parse(0x06, "content type", &typ); err != nil {
+		return err
+	}
+	switch {
+	case typ.Equal(oidAuthEnvelopedData):
+		return errors.New("cms: authenticated enveloped data, RFC 5083, is not supported")
+	case !typ.Equal(oidEnvelopedData):
+		return fmt.Errorf("cms: content of type %v, not enveloped data", typ)
+	}
+	if err := ber.open(0xa0, "content"); err != nil {
+		return err
+	}
+	if err := ber.open(0x30, "enveloped data"); err != nil {
+		return err
+	}
+	var version int
+	if err := ber.parse(0x02, "version", &version); err != nil {
+		return err
+	}
+	if tag, _ := br.Peek(1); len(tag) == 1 && tag[0] == 0xa0 {
+		if _, err := ber.element(0xa0, "originator info"); err != nil {
+			return err
+		}
+	}
+	var recipients asn1.RawValue
+	if err := ber.parse(0x31, "recipient infos", &recipients); err != nil {
+		return err
+	}
+	if err := ber.open(0x30, "encrypted content info"); err != nil {
+		return err
+	}
+	if err := ber.parse(0x06, "content type", &typ); err != nil {
+		return err
+	}
+	var alg pkix.AlgorithmIdentifier
+	if err := ber.parse(0x30, "content encryption algorithm", &alg); err != nil {
+		return err
+	}
+	c, ok := cmsCiphers[alg.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("cms: content cipher %v is not supported", alg.Algorithm)
+	}
+
+	key, err := cmsContentKey(recipients.Bytes, pass, c.keySize)
+	if err != nil {
+		return err
+	}
+	block, iv, err := cmsBlock(alg, key)
+	if err != nil {
+		return err
+	}
+
+	_, tag, n, err := ber.header()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	content := &berContent{ber: ber}
+	switch {
+	case err == nil && tag == 0x80 && n >= 0:
+		content.left = n
+	case err == nil && tag == 0xa0:
+		content.outer = n
+	default:
+		return errors.New("cms: the encrypted content is detached, which is not supported")
+	}
+
+	return decryptCBC(w, content, cipher.NewCBCDecrypter(block, iv), "cms", errCMSPadding)
+}
+
+// cmsContentKey returns the content encryption key, of keySize bytes,
+// of the first password recipient, of those in recipients, whose key
+// pass unwraps.
+func cmsContentKey(recipients, pass []byte, keySize int) ([]byte, error) {
+	var pwris int
+	var unsupported error
+	for rest := recipients; len(rest) > 0; {
+		var ri asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &ri); err != nil {
+			return nil, fmt.Errorf("cms: recipient infos: %v", err)
+		}
+		if ri.Class != asn1.ClassContextSpecific || ri.Tag != 3 {
+			continue
+		}
+		pwris++
+
+		var pwri passwordRecipientInfo
+		if _, err := asn1.UnmarshalWithParams(ri.FullBytes, &pwri, "tag:3"); err != nil {
+			return nil, fmt.Errorf("cms: password recipient info: %v", err)
+		}
+		key, err := pwri.key(pass)
+		switch {
+		// A key of another length is the check bytes matching by
+		// chance
+		case err == errCMSKey:
+		case err != nil:
+			unsupported = err
+		case len(key) == keySize:
+			return key, nil
+		}
+	}
+
+	switch {
+	case pwris == 0:
+		return nil, errors.New("cms: there is no password recipient: the message is encrypted to keys, not a passphrase")
+	case unsupported != nil:
+		return nil, unsupported
+	}
+
+	return nil, errCMSKey
+}
+
+// key unwraps the key of the recipient with pass, RFC 3211 section 2.
+func (p *passwordRecipientInfo) key(pass []byte) ([]byte, error) {
+	if !p.KeyDerivation.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("cms: password recipient key derivation %v is not supported, only PBKDF2",
+			p.KeyDerivation.Algorithm)
+	}
+	var params pbkdf2Params
+	if _, err := asn1.Unmarshal(p.KeyDerivation.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("cms: PBKDF2 parameters: %v", err)
+	}
+	prf := sha1.New
+	if len(params.PRF.Algorithm) > 0 {
+		var ok bool
+		if prf, ok = cmsPRFs[params.PRF.Algorithm.String()]; !ok {
+			return nil, fmt.Errorf("cms: PBKDF2 PRF %v is not supported", params.PRF.Algorithm)
+		}
+	}
+	if params.Iter < 1 || params.Iter > maxCMSIter {
+		return nil, fmt.Errorf("cms: %d PBKDF2 iterations, not from 1 to the %d allowed", params.Iter, maxCMSIter)
+	}
+
+	if !p.KeyEncryption.Algorithm.Equal(oidPWRIKEK) {
+		return nil, fmt.Errorf("cms: password recipient key encryption %v is not supported",
+			p.KeyEncryption.Algorithm)
+	}
+	var kekAlg pkix.AlgorithmIdentifier
+	if _, err := asn1.Unmarshal(p.KeyEncryption.Parameters.FullBytes, &kekAlg); err != nil {
+		return nil, fmt.Errorf("cms: key encryption parameters: %v", err)
+	}
+	c, ok := cmsCiphers[kekAlg.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("cms: key encryption cipher %v is not supported", kekAlg.Algorithm)
+	}
+	if params.KeyLen != 0 && params.KeyLen != c.keySize {
+		return nil, fmt.Errorf("cms: PBKDF2 key length %d for %s", params.KeyLen, c.name)
+	}
+	block, iv, err := cmsBlock(kekAlg, pbkdf2.Key(pass, params.Salt, params.Iter, c.keySize, prf))
+	if err != nil {
+		return nil, err
+	}
+
+	return kekUnwrap(block, iv, p.EncryptedKey)
+}
+
+// kekUnwrap unwraps the key wrapped with block, in CBC mode from iv
+// and then again from the IV that the last block hides, RFC 3211
+// section 2.3.2. The check bytes, which are the complement of the
+// first three of the key, tell a wrong passphrase.
+func kekUnwrap(block cipher.Block, iv, wrapped []byte) ([]byte, error) {
+	bs := block.BlockSize()
+	n := len(wrapped)
+	if n < 2*bs || n%bs != 0 {
+		return nil, fmt.Errorf("cms: wrapped key of %d bytes, for blocks of %d", n, bs)
+	}
+
+	tmp := make([]byte, n)
+	cipher.NewCBCDecrypter(block, wrapped[n-2*bs:n-bs]).CryptBlocks(tmp[n-bs:], wrapped[n-bs:])
+	cipher.NewCBCDecrypter(block, tmp[n-bs:]).CryptBlocks(tmp[:n-bs], wrapped[:n-bs])
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(tmp, tmp)
+	if tmp[1]^tmp[4] != 0xff || tmp[2]^tmp[5] != 0xff || tmp[3]^tmp[6] != 0xff || int(tmp[0]) > n-4 {
+		return nil, errCMSKey
+	}
+
+	return tmp[4 : 4+int(tmp[0])], nil
+}
+
+// cmsBlock returns the block cipher of the algorithm alg, with key,
+// and the IV its parameters give.
+func cmsBlock(alg pkix.AlgorithmIdentifier, key []byte) (cipher.Block, []byte, error) {
+	c, ok := cmsCiphers[alg.Algorithm.String()]
+	if !ok {
+		return nil, nil, fmt.Errorf("cms: cipher %v is not supported", alg.Algorithm)
+	}
+	block, err := c.newBlock(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cms: %s: %v", c.name, err)
+	}
+	var iv []byte
+	if rest, err := asn1.Unmarshal(alg.Parameters.FullBytes, &iv); err != nil || len(rest) != 0 ||
+		len(iv) != block.BlockSize() {
+		return nil, nil, fmt.Errorf("cms: bad %s IV", c.name)
+	}
+
+	return block, iv, nil
+}
+
+// berReader reads BER elements one after the other, so that the
+// encrypted content can be streamed.
+type berReader struct {
+	br *bufio.Reader
+}
+
+// header reads the identifier and length octets of the next element,
+// and returns them, its identifier octet, and its length, -1 for the
+// indefinite form.
+func (b *berReader) header() ([]byte, byte, int64, error) {
+	tag, err := b.br.ReadByte()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, 0, 0, fmt.Errorf("cms: high tag numbers are not supported")
+	}
+	l, err := b.br.ReadByte()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("cms: %w", io.ErrUnexpectedEOF)
+	}
+	hdr := []byte{tag, l}
+	switch {
+	case l < 0x80:
+		return hdr, tag, int64(l), nil
+	case l == 0x80 && tag&0x20 != 0:
+		return hdr, tag, -1, nil
+	case l == 0x80, l > 0x87:
+		return nil, 0, 0, fmt.Errorf("cms: bad length %#x of an element %#x", l, tag)
+	}
+
+	var n int64
+	for i := 0; i < int(l&0x7f); i++ {
+		c, err := b.br.ReadByte()
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("cms: %w", io.ErrUnexpectedEOF)
+		}
+		hdr = append(hdr, c)
+		n = n<<8 | int64(c)
+	}
+
+	return hdr, tag, n, nil
+}
+
+// open reads the header of the next element, which must be the
+// constructed one with the identifier octet tag, for what, to go on
+// with its contents.
+func (b *berReader) open(tag byte, what string) error {
+	_, t, _, err := b.header()
+	if err == io.EOF {
+		err = fmt.Errorf("cms: %w", io.ErrUnexpectedEOF)
+	}
+	if err == nil && t != tag {
+		err = fmt.Errorf("cms: %s has the identifier %#x, not %#x", what, t, tag)
+	}
+
+	return err
+}
+
+// element reads the next element, which must have the identifier
+// octet tag, whole, for what: it must be of a definite length, of at
+// most 1 MiB.
+func (b *berReader) element(tag byte, what string) ([]byte, error) {
+	hdr, t, n, err := b.header()
+	switch {
+	case err == io.EOF:
+		return nil, fmt.Errorf("cms: %w", io.ErrUnexpectedEOF)
+	case err != nil:
+		return nil, err
+	case t != tag:
+		return nil, fmt.Errorf("cms: %s has the identifier %#x, not %#x", what, t, tag)
+	case n < 0 || n > 1<<20:
+		return nil, fmt.Errorf("cms: %s is of an indefinite or too long a length", what)
+	}
+
+	el := make([]byte, len(hdr)+int(n))
+	copy(el, hdr)
+	if _, err := io.ReadFull(b.br, el[len(hdr):]); err != nil {
+		return nil, fmt.Errorf("cms: %s: %w", what, io.ErrUnexpectedEOF)
+	}
+
+	return el, nil
+}
+
+// parse reads the next element, as element does, into v.
+func (b *berReader) parse(tag byte, what string, v interface{}) error {
+	el, err := b.element(tag, what)
+	if err != nil {
+		return err
+	}
+	if _, err := asn1.Unmarshal(el, v); err != nil {
+		return fmt.Errorf("cms: %s: %v", what, err)
+	}
+
+	return nil
+}
+
+// berContent reads the octets of the encrypted content, primitive, or
+// constructed of octet strings, of a definite or indefinite length.
+type berContent struct {
+	ber *berReader
+	// Left of the octet string being read
+	left int64
+	// Left of the constructed content, -1 if indefinite, until its
+	// end-of-contents
+	outer int64
+}
+
+func (c *berContent) Read(p []byte) (int, error) {
+	for c.left == 0 {
+		if c.outer == 0 {
+			return 0, io.EOF
+		}
+		hdr, tag, n, err := c.ber.header()
+		if err == io.EOF {
+			err = fmt.Errorf("cms: the encrypted content is cut short: %w", io.ErrUnexpectedEOF)
+		}
+		switch {
+		case err != nil:
+			return 0, err
+		case tag == 0 && n == 0 && c.outer < 0:
+			c.outer = 0
+			continue
+		case tag != 0x04 || n < 0:
+			return 0, fmt.Errorf("cms: the encrypted content holds an element %#x, not an octet string", tag)
+		}
+		if c.outer > 0 {
+			if c.outer -= int64(len(hdr)) + n; c.outer < 0 {
+				return 0, errors.New("cms: an octet string runs past the end of the encrypted content")
+			}
+		}
+		c.left = n
+	}
+
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.ber.br.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = fmt.Errorf("cms: the encrypted content is cut short: %w", io.ErrUnexpectedEOF)
+	}
+
+	return n, err
+}
+
+// pemBody reads the base64 of a PEM block, after its BEGIN line, up to
+// the dashes of its END line.
+type pemBody struct {
+	br  *bufio.Reader
+	end bool
+}
+
+func (p *pemBody) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) && !p.end {
+		c, err := p.br.ReadByte()
+		if err == io.EOF {
+			return n, fmt.Errorf("cms: PEM without an END line: %w", io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return n, err
+		}
+		if c == '-' {
+			p.end = true
+			break
+		}
+		b[n] = c
+		n++
+	}
+	if n == 0 && p.end {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
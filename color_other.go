@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// enableColor tells whether f is a terminal.
+func enableColor(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
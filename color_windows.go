@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// enableColor tells whether f is a console, and if so switches on the
+// processing of ANSI escape sequences for it.
+func enableColor(f *os.File) bool {
+	h := windows.Handle(f.Fd())
+
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+
+	return windows.SetConsoleMode(h,
+		mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
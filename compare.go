@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// compareWriter compares the plain text written to it, for -compare,
+// with a file, noting where they first differ. Nothing is written
+// anywhere.
+type compareWriter struct {
+	fd  *os.File
+	r   *bufio.Reader
+	buf []byte
+	n   int64
+	// The offset of the first byte that differs, or -1 while they
+	// match, and whether it is because the file ends there
+	diff  int64
+	short bool
+}
+
+func newCompareWriter(name string) (*compareWriter, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compareWriter{fd: fd, r: bufio.NewReaderSize(fd, 1<<20), diff: -1}, nil
+}
+
+func (cw *compareWriter) Write(p []byte) (int, error) {
+	if cw.diff < 0 {
+		if len(cw.buf) < len(p) {
+			cw.buf = make([]byte, len(p))
+		}
+		b := cw.buf[:len(p)]
+		m, err := io.ReadFull(cw.r, b)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("Compare: %v", err)
+		}
+		if !bytes.Equal(p[:m], b[:m]) {
+			i := 0
+			for p[i] == b[i] {
+				i++
+			}
+			cw.diff = cw.n + int64(i)
+		} else if m < len(p) {
+			cw.diff, cw.short = cw.n+int64(m), true
+		}
+	}
+	cw.n += int64(len(p))
+
+	return len(p), nil
+}
+
+// finish returns the offset of the first byte that differs, which is
+// the length of the plain text when the file is longer, or -1 if they
+// are the same.
+func (cw *compareWriter) finish() (int64, error) {
+	defer cw.fd.Close()
+	if cw.diff < 0 {
+		if _, err := cw.r.ReadByte(); err == nil {
+			cw.diff = cw.n
+		} else if err != io.EOF {
+			return 0, fmt.Errorf("Compare: %v", err)
+		}
+	}
+
+	return cw.diff, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+var completionShells = []string{"bash", "fish", "zsh"}
+
+// The values that flags of a fixed set take, by subcommand and flag
+var flagChoices = map[string]func() []string{
+	"encrypt -cipher": cipherNames,
+}
+
+func init() {
+	subcommands["completion"] = completion
+}
+
+// completion prints a completion script, generated from the flag and
+// subcommand definitions, for the shell named in args.
+func completion(args []string) int {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric completion {bash,zsh,fish}")
+	}
+	configureSubcommand(fs, "completion", args)
+	shell := ""
+	if fs.NArg() == 1 {
+		shell = fs.Arg(0)
+	}
+
+	switch shell {
+	case "bash":
+		bashCompletion(os.Stdout)
+	case "fish":
+		fishCompletion(os.Stdout)
+	case "zsh":
+		zshCompletion(os.Stdout)
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+
+	return 0
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// flagArg returns what the flag f takes: nothing for a boolean flag,
+// "file" or "directory" if its usage names its value so, in backquotes,
+// and otherwise "value".
+func flagArg(f *flag.Flag) string {
+	if isBoolFlag(f) {
+		return ""
+	}
+	switch name, _ := flag.UnquoteUsage(f); name {
+	case "file", "directory":
+		return name
+	}
+
+	return "value"
+}
+
+// choices returns the values that the flag f of the subcommand cmd, or
+// of none, takes, if it takes only some.
+func choices(cmd string, f *flag.Flag) []string {
+	if fn, ok := flagChoices[cmd+" -"+f.Name]; ok {
+		return fn()
+	}
+
+	return nil
+}
+
+func cipherNames() []string {
+	var names []string
+	for _, c := range symcrypt.Ciphers() {
+		names = append(names, c.String())
+	}
+
+	return names
+}
+
+func subcommandNames() []string {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Set while completion collects the flags of the subcommands, which
+// configureSubcommand then hands back in a panic instead of parsing
+var collectingFlags bool
+
+type collectedFlags struct {
+	*flag.FlagSet
+}
+
+// subcommandFlags returns the flags of the subcommand name, by running
+// it only as far as configureSubcommand, which all of them parse their
+// arguments with.
+func subcommandFlags(name string) (fs *flag.FlagSet) {
+	collectingFlags = true
+	defer func() {
+		collectingFlags = false
+		if r := recover(); r != nil {
+			c, ok := r.(collectedFlags)
+			if !ok {
+				panic(r)
+			}
+			fs = c.FlagSet
+		}
+	}()
+	subcommands[name](nil)
+
+	panic(fmt.Sprintf("subcommand %s does not parse its arguments with configureSubcommand", name))
+}
+
+// visitFlags calls fn for each flag before a subcommand, with an empty
+// cmd, and then for each flag of each subcommand.
+func visitFlags(fn func(cmd string, f *flag.Flag)) {
+	flag.VisitAll(func(f *flag.Flag) { fn("", f) })
+	for _, name := range subcommandNames() {
+		subcommandFlags(name).VisitAll(func(f *flag.Flag) { fn(name, f) })
+	}
+}
+
+func bashCompletion(w io.Writer) {
+	// The cases on "$cmd $prev" that complete a flag's value, and the
+	// flags of each subcommand
+	var cases []string
+	var files, dirs, values []string
+	flags := make(map[string][]string)
+	visitFlags(func(cmd string, f *flag.Flag) {
+		flags[cmd] = append(flags[cmd], "-"+f.Name)
+		pattern := fmt.Sprintf("%q", cmd+" -"+f.Name)
+		if c := choices(cmd, f); c != nil {
+			cases = append(cases, fmt.Sprintf("\t%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\t\t;;\n",
+				pattern, strings.Join(c, " ")))
+			return
+		}
+		switch flagArg(f) {
+		case "file":
+			files = append(files, pattern)
+		case "directory":
+			dirs = append(dirs, pattern)
+		case "value":
+			values = append(values, pattern)
+		}
+	})
+	for _, c := range []struct {
+		patterns []string
+		reply    string
+	}{
+		{files, `($(compgen -f -- "$cur"))`},
+		{dirs, `($(compgen -d -- "$cur"))`},
+		{values, `()`},
+	} {
+		if len(c.patterns) != 0 {
+			cases = append(cases, fmt.Sprintf("\t%s)\n\t\tCOMPREPLY=%s\n\t\treturn\n\t\t;;\n",
+				strings.Join(c.patterns, "|"), c.reply))
+		}
+	}
+
+	var flagCases strings.Builder
+	fmt.Fprintf(&flagCases, "\t\t\"\")\n\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\t\t;;\n",
+		strings.Join(flags[""], " "))
+	for _, name := range subcommandNames() {
+		if len(flags[name]) != 0 {
+			fmt.Fprintf(&flagCases, "\t\t%s)\n\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\t\t;;\n",
+				name, strings.Join(flags[name], " "))
+		}
+	}
+
+	fmt.Fprintf(w, `_decrypt_symmetric() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local cmd="" word
+
+	for word in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do
+		case "$word" in
+		%s)
+			cmd="$word"
+			break
+			;;
+		esac
+	done
+
+	case "$cmd $prev" in
+%s	esac
+
+	if [[ "$cur" == -* ]]; then
+		case "$cmd" in
+%s		esac
+	elif [[ -z "$cmd" ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	elif [[ "$cmd" == completion ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -f -- "$cur"))
+	fi
+}
+complete -F _decrypt_symmetric decrypt-symmetric
+`, strings.Join(subcommandNames(), "|"), strings.Join(cases, ""), flagCases.String(),
+		strings.Join(subcommandNames(), " "), strings.Join(completionShells, " "))
+}
+
+// zshQuote escapes s for use inside an _arguments description.
+func zshQuote(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`,
+		":", `\:`).Replace(s)
+}
+
+// zshSpec returns the _arguments spec of the flag f of the subcommand
+// cmd.
+func zshSpec(cmd string, f *flag.Flag) string {
+	_, usage := flag.UnquoteUsage(f)
+	spec := fmt.Sprintf("-%s[%s]", f.Name, zshQuote(usage))
+	if c := choices(cmd, f); c != nil {
+		return spec + ":" + f.Name + ":(" + strings.Join(c, " ") + ")"
+	}
+	switch flagArg(f) {
+	case "file":
+		spec += ":file:_files"
+	case "directory":
+		spec += ":directory:_files -/"
+	case "value":
+		spec += ":" + f.Name + ": "
+	}
+
+	return spec
+}
+
+func zshCompletion(w io.Writer) {
+	specs := make(map[string][]string)
+	visitFlags(func(cmd string, f *flag.Flag) {
+		specs[cmd] = append(specs[cmd], zshSpec(cmd, f))
+	})
+
+	fmt.Fprintln(w, "#compdef decrypt-symmetric")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "local state line")
+	fmt.Fprintln(w, "_arguments -C \\")
+	for _, spec := range specs[""] {
+		fmt.Fprintf(w, "\t'%s' \\\n", spec)
+	}
+	fmt.Fprintf(w, "\t'1:command:(%s)' \\\n", strings.Join(subcommandNames(), " "))
+	fmt.Fprintln(w, "\t'*::arg:->args'")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "case $state in")
+	fmt.Fprintln(w, "args)")
+	fmt.Fprintln(w, "\tcase $words[1] in")
+	for _, name := range subcommandNames() {
+		fmt.Fprintf(w, "\t%s)\n", name)
+		fmt.Fprintln(w, "\t\t_arguments \\")
+		for _, spec := range specs[name] {
+			fmt.Fprintf(w, "\t\t\t'%s' \\\n", spec)
+		}
+		if name == "completion" {
+			fmt.Fprintf(w, "\t\t\t'1:shell:(%s)'\n", strings.Join(completionShells, " "))
+		} else {
+			fmt.Fprintln(w, "\t\t\t'*:file:_files'")
+		}
+		fmt.Fprintln(w, "\t\t;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\t;;")
+	fmt.Fprintln(w, "esac")
+}
+
+// fishQuote quotes s as a single-quoted fish string.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func fishCompletion(w io.Writer) {
+	visitFlags(func(cmd string, f *flag.Flag) {
+		_, usage := flag.UnquoteUsage(f)
+		fmt.Fprint(w, "complete -c decrypt-symmetric")
+		if cmd != "" {
+			fmt.Fprintf(w, " -n %s", fishQuote("__fish_seen_subcommand_from "+cmd))
+		}
+		fmt.Fprintf(w, " -o %s -d %s", f.Name, fishQuote(usage))
+		if c := choices(cmd, f); c != nil {
+			fmt.Fprintf(w, " -x -a %s\n", fishQuote(strings.Join(c, " ")))
+			return
+		}
+		switch flagArg(f) {
+		case "file":
+			fmt.Fprint(w, " -r -F")
+		case "directory":
+			fmt.Fprint(w, " -x -a '(__fish_complete_directories)'")
+		case "value":
+			fmt.Fprint(w, " -x")
+		}
+		fmt.Fprintln(w)
+	})
+	for _, name := range subcommandNames() {
+		fmt.Fprintf(w, "complete -c decrypt-symmetric -f -n __fish_use_subcommand -a %s\n",
+			name)
+	}
+	fmt.Fprintf(w, "complete -c decrypt-symmetric -f -n '__fish_seen_subcommand_from completion' -a %s\n",
+		fishQuote(strings.Join(completionShells, " ")))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Flags that make no sense as persistent defaults
+var notConfigurable = map[string]bool{
+	"config":   true,
+	"filename": true,
+}
+
+// Flags that cannot be set from the environment
+var notEnvironment = map[string]bool{
+	"filename": true,
+}
+
+const envPrefix = "DECSYM_"
+
+// setFlags returns the names of the flags of flags given on the command
+// line.
+func setFlags(flags *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	return set
+}
+
+// Flags of which only one is used, the first that passphraseProvider,
+// or keyPassphraseProvider, comes to: one given on the command line
+// keeps the environment and the config file from giving another, which
+// would take its place, and one in the environment keeps the config
+// file from it.
+var flagGroups = [][]string{
+	{"passphrase", "passphrase-fd", "passphrase-file", "passphrase-env", "passphrase-credential",
+		"passphrase-secret", "passphrase-tpm2", "passphrase-plugin", "use-agent"},
+	{"key-passphrase-fd", "key-passphrase-file", "key-passphrase-env"},
+}
+
+// setGroups records in set the flags of each of flagGroups one of which
+// is in it.
+func setGroups(set map[string]bool) {
+	for _, group := range flagGroups {
+		for _, name := range group {
+			if set[name] {
+				for _, name := range group {
+					set[name] = true
+				}
+				break
+			}
+		}
+	}
+}
+
+// envName returns the environment variable overriding flag name of
+// the subcommand cmd, or of the top level if cmd is empty, e.g.
+// DECSYM_PASSPHRASE for -passphrase and DECSYM_ENCRYPT_CIPHER for
+// encrypt -cipher.
+func envName(cmd, name string) string {
+	if cmd != "" {
+		name = cmd + "_" + name
+	}
+
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets every flag of flags, that of the subcommand cmd or the
+// top level one, that is not already in set from its DECSYM_*
+// environment variable, and records it in set.
+func applyEnv(flags *flag.FlagSet, cmd string, set map[string]bool) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || cmd == "" && notEnvironment[f.Name] {
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(cmd, f.Name))
+		if !ok {
+			return
+		}
+		if e := flags.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("Environment: %s: %v", envName(cmd, f.Name), e)
+			return
+		}
+		set[f.Name] = true
+	})
+
+	return err
+}
+
+// defaultConfigPath returns ~/.config/decrypt-symmetric/config.toml,
+// or the platform's equivalent.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "decrypt-symmetric", "config.toml")
+}
+
+// loadConfig sets every flag that is not in set, i.e. was given
+// neither on the command line nor in the environment, from the
+// top-level keys of the TOML file at path. Keys are flag
+// names, e.g. `passphrase = "..."`. A table named after a subcommand,
+// e.g. [encrypt], is left to it, for configureSubcommand. A missing
+// file is only an error if the user asked for it explicitly.
+func loadConfig(path string, explicit bool, set map[string]bool) error {
+	var conf map[string]interface{}
+	if _, err := toml.DecodeFile(path, &conf); err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("Config: %v", err)
+	}
+
+	for name, value := range conf {
+		if _, ok := value.(map[string]interface{}); ok && subcommands[name] != nil {
+			continue
+		}
+		if flag.Lookup(name) == nil || notConfigurable[name] {
+			return fmt.Errorf("Config: %s: unknown setting %q", path, name)
+		}
+		if err := setConfig(flag.CommandLine, path, name, value, set); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setConfig sets the flag name of flags to value, from the config file at
+// path, unless it is in set.
+func setConfig(flags *flag.FlagSet, path, name string, value interface{}, set map[string]bool) error {
+	if set[name] {
+		return nil
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	for _, v := range values {
+		if err := flags.Set(name, fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("Config: %s: %s: %v", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+// configureSubcommand parses args into the flags of the subcommand
+// cmd, and sets those not given there, as main does the top level
+// ones, from DECSYM_CMD_* environment variables, then from the [cmd]
+// table of the config file, which may only name its flags. Every
+// subcommand parses its arguments with it, so that completion can
+// collect their flags here.
+func configureSubcommand(flags *flag.FlagSet, cmd string, args []string) {
+	if collectingFlags {
+		panic(collectedFlags{flags})
+	}
+	flags.Parse(args)
+
+	set := setFlags(flags)
+	if err := applyEnv(flags, cmd, set); err != nil {
+		fatalf("%v", err)
+	}
+	if configFile == "" {
+		return
+	}
+	var conf map[string]interface{}
+	if _, err := toml.DecodeFile(configFile, &conf); err != nil {
+		// loadConfig has already failed for anything but a missing
+		// file that was not asked for
+		return
+	}
+	table, ok := conf[cmd].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for name, value := range table {
+		if flags.Lookup(name) == nil {
+			fatalf("Config: %s: [%s]: unknown setting %q", configFile, cmd, name)
+		}
+		if err := setConfig(flags, configFile, name, value, set); err != nil {
+			fatalf("%v", err)
+		}
+	}
+}
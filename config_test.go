@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// The passphrase of a flag beats one of the environment, which beats
+// one of the config file, even when they give it with different flags
+// of the same group.
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	right := writeFile(t, dir, "right", selftestPassphrase+"\n")
+	wrong := writeFile(t, dir, "wrong", "wrong\n")
+	config := func(pass string) string {
+		return writeFile(t, t.TempDir(), "config.toml", fmt.Sprintf("passphrase-file = %q\n", pass))
+	}
+
+	for _, tt := range []struct {
+		name   string
+		config string
+		env    []string
+		flags  []string
+		want   int
+	}{
+		{name: "config", config: config(right), want: 0},
+		{name: "env over config", config: config(right), env: []string{"DECSYM_PASSPHRASE_FILE=" + wrong}, want: exitWrongPassphrase},
+		{name: "env over config, right", config: config(wrong), env: []string{"DECSYM_PASSPHRASE_FILE=" + right}, want: 0},
+		{name: "flag over env", env: []string{"DECSYM_PASSPHRASE_FILE=" + wrong}, flags: []string{"-passphrase-file", right}, want: 0},
+		{name: "flag over config", config: config(wrong), flags: []string{"-passphrase-file", right}, want: 0},
+		{name: "flag over env of another source", env: []string{"DECSYM_PASSPHRASE=wrong"}, flags: []string{"-passphrase-file", right}, want: 0},
+		{name: "env over config of another source", config: config(right), env: []string{"DECSYM_PASSPHRASE=wrong"}, want: exitWrongPassphrase},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"-batch", "-filename", "vectors/aes256-iterated.gpg", "-output", filepath.Join(t.TempDir(), "out")}
+			if tt.config != "" {
+				args = append(args, "-config", tt.config)
+			}
+			code, stderr := runMain(t, tt.env, append(args, tt.flags...)...)
+			if code != tt.want {
+				t.Errorf("exit status %d, want %d; stderr:\n%s", code, tt.want, stderr)
+			}
+		})
+	}
+}
+
+// A subcommand's flag is set from the command line, or else from
+// DECSYM_CMD_FLAG, or else from the config file's [cmd] table.
+func TestSubcommandConfigPrecedence(t *testing.T) {
+	defer func(saved string) { configFile = saved }(configFile)
+	configFile = writeFile(t, t.TempDir(), "config.toml", "[probe]\ncipher = \"Twofish\"\n")
+
+	for _, tt := range []struct {
+		name string
+		env  string
+		args []string
+		want string
+	}{
+		{name: "default", want: "Twofish"},
+		{name: "env", env: "CAST5", want: "CAST5"},
+		{name: "flag", env: "CAST5", args: []string{"-cipher", "3DES"}, want: "3DES"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("DECSYM_PROBE_CIPHER", tt.env)
+			}
+			fs := flag.NewFlagSet("probe", flag.ContinueOnError)
+			cipher := fs.String("cipher", "AES-256", "")
+			configureSubcommand(fs, "probe", tt.args)
+			if *cipher != tt.want {
+				t.Errorf("-cipher %q, want %q", *cipher, tt.want)
+			}
+		})
+	}
+}
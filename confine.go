@@ -0,0 +1,76 @@
+package main
+
+import "os"
+
+// confinement is what the process still needs from the file system
+// once the input is open: files that it may read, outputs that it may
+// create, write and remove, inputs that it may remove, the directory
+// for spool files, and the terminal to prompt at.
+type confinement struct {
+	read     []string
+	outputs  []string
+	remove   []string
+	tmpDir   string
+	terminal string
+}
+
+// confinePaths returns what -confine restricts the process to, with
+// the -manifest man, or false if it needs more than local paths that
+// are known up front: URLs to fetch or upload, keys to look up, or
+// passphrase sources that run other programs.
+func confinePaths(man *manifest) (*confinement, bool) {
+	if useAgent || agentKeys || passPlugin != "" || passTPM2 != "" || yubikeySlot != 0 ||
+		keyserver != "" || useWKD {
+		return nil, false
+	}
+	if _, ok := parseURL(filename); ok {
+		return nil, false
+	}
+
+	c := &confinement{}
+	if passFile != "" {
+		c.read = append(c.read, passFile)
+	}
+	if keyPassFile != "" {
+		c.read = append(c.read, keyPassFile)
+	}
+	if passCred != "" {
+		if name, err := credentialPath(passCred); err == nil {
+			c.read = append(c.read, name)
+		}
+	}
+	if passSecret != "" {
+		if name, err := secretPath(passSecret); err == nil {
+			c.read = append(c.read, name)
+		}
+	}
+	for _, name := range outputs {
+		if name == "-" {
+			continue
+		}
+		if _, ok := parseURL(name); ok {
+			return nil, false
+		}
+		c.outputs = append(c.outputs, name)
+		if tempSuffix != "" {
+			c.outputs = append(c.outputs, name+tempSuffix)
+		}
+	}
+	// Its temporary file, renamed over it
+	c.outputs = append(c.outputs, man.paths()...)
+	if interactivePassphrase() && !batchMode {
+		// The passphrase is asked for at /dev/tty when stdin is the
+		// input, after the process is confined.
+		if _, err := os.Stat("/dev/tty"); err == nil {
+			c.terminal = "/dev/tty"
+		}
+	}
+	if deleteAfter {
+		c.remove = append(c.remove, filename)
+	}
+	if verifyFirst {
+		c.tmpDir = os.TempDir()
+	}
+
+	return c, true
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Landlock file system rights, by the ABI version that introduced them
+const (
+	landlockV1 = 0x1fff // EXECUTE through MAKE_SYM
+	landlockV2 = landlockV1 | unix.LANDLOCK_ACCESS_FS_REFER
+	landlockV3 = landlockV2 | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	landlockV5 = landlockV3 | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+	// LANDLOCK_RESTRICT_SELF_TSYNC
+	landlockTSYNC = 8
+)
+
+// confine restricts the process with a Landlock ruleset to c. Kernels
+// without Landlock are left as they are.
+func confine(c *confinement) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		// ENOSYS, or EOPNOTSUPP when it is disabled at boot
+		return nil
+	}
+
+	var handled uint64
+	switch {
+	case abi >= 5:
+		handled = landlockV5
+	case abi >= 3:
+		handled = landlockV3
+	case abi >= 2:
+		handled = landlockV2
+	default:
+		handled = landlockV1
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	defer unix.Close(int(fd))
+
+	const (
+		read = unix.LANDLOCK_ACCESS_FS_READ_FILE
+		// os.Create opens outputs for reading too
+		write  = read | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+		create = write | unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE
+	)
+	for _, name := range c.read {
+		if err := landlockAllow(int(fd), name, read&handled); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.outputs {
+		// A file that does not exist yet has no inode to attach a rule
+		// to, and regular outputs are removed if decryption fails, so
+		// the right to create and remove files goes on the directory.
+		if fi, err := os.Stat(name); err == nil && !fi.Mode().IsRegular() {
+			err = landlockAllow(int(fd), name, write&handled)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := landlockAllow(int(fd), filepath.Dir(name), create&handled); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.remove {
+		if err := landlockAllow(int(fd), filepath.Dir(name),
+			unix.LANDLOCK_ACCESS_FS_REMOVE_FILE&handled); err != nil {
+			return err
+		}
+	}
+	if c.tmpDir != "" {
+		if err := landlockAllow(int(fd), c.tmpDir, create&handled); err != nil {
+			return err
+		}
+	}
+	if c.terminal != "" {
+		// Turning echo off is an ioctl
+		access := uint64(write | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV)
+		if err := landlockAllow(int(fd), c.terminal, access&handled); err != nil {
+			return err
+		}
+	}
+
+	return landlockRestrict(int(fd), abi)
+}
+
+func landlockAllow(ruleset int, name string, access uint64) error {
+	fd, err := unix.Open(name, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer unix.Close(fd)
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset),
+		unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return &os.PathError{Op: "landlock", Path: name, Err: errno}
+	}
+
+	return nil
+}
+
+// landlockRestrict enforces the ruleset. Landlock applies per thread,
+// so it has to be enforced on every thread of the runtime: by the
+// kernel, from ABI 8, or else by AllThreadsSyscall. Where cgo rules
+// that out, the main goroutine, which does all the parsing and writing,
+// is locked to its thread and only that thread is confined.
+func landlockRestrict(ruleset int, abi uintptr) error {
+	runtime.LockOSThread()
+
+	if abi >= 8 {
+		defer runtime.UnlockOSThread()
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return err
+		}
+		_, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF,
+			uintptr(ruleset), landlockTSYNC, 0)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+
+	_, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0)
+	if errno == 0 {
+		defer runtime.UnlockOSThread()
+		_, _, errno = syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF,
+			uintptr(ruleset), 0, 0)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+	if !errors.Is(errno, syscall.ENOTSUP) {
+		return errno
+	}
+
+	// Never unlocked, so the goroutine stays on the confined thread
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	_, _, errno = unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// confine unveils only the paths in c, and pledges the process to
+// stdio, file access and the terminal, for the passphrase prompt.
+func confine(c *confinement) error {
+	for _, name := range c.read {
+		if err := unix.Unveil(name, "r"); err != nil {
+			return &os.PathError{Op: "unveil", Path: name, Err: err}
+		}
+	}
+	for _, name := range c.outputs {
+		if err := unix.Unveil(name, "wc"); err != nil {
+			return &os.PathError{Op: "unveil", Path: name, Err: err}
+		}
+	}
+	for _, name := range c.remove {
+		if err := unix.Unveil(name, "c"); err != nil {
+			return &os.PathError{Op: "unveil", Path: name, Err: err}
+		}
+	}
+	if c.tmpDir != "" {
+		if err := unix.Unveil(c.tmpDir, "rwc"); err != nil {
+			return &os.PathError{Op: "unveil", Path: c.tmpDir, Err: err}
+		}
+	}
+	if c.terminal != "" {
+		if err := unix.Unveil(c.terminal, "rw"); err != nil {
+			return &os.PathError{Op: "unveil", Path: c.terminal, Err: err}
+		}
+	}
+	if err := unix.UnveilBlock(); err != nil {
+		return err
+	}
+
+	return unix.Pledge("stdio rpath wpath cpath tty", "")
+}
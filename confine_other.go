@@ -0,0 +1,8 @@
+//go:build !linux && !openbsd
+
+package main
+
+// confine does nothing where neither Landlock nor unveil is available.
+func confine(c *confinement) error {
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "time"
+
+// cpuTime is not available on this platform.
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// cpuTime returns the user plus kernel CPU time used by the process.
+func cpuTime() time.Duration {
+	var creation, exit, kernel, user windows.Filetime
+	err := windows.GetProcessTimes(windows.CurrentProcess(), &creation,
+		&exit, &kernel, &user)
+	if err != nil {
+		return 0
+	}
+
+	// Filetimes count 100ns intervals
+	ticks := func(ft windows.Filetime) int64 {
+		return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+	}
+	return time.Duration((ticks(kernel) + ticks(user)) * 100)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// Where Docker Swarm and Kubernetes mount secrets in a container, by
+// convention
+const secretsDir = "/run/secrets"
+
+// credentialPath returns the file that holds the systemd credential
+// name, in the directory that LoadCredential= and ImportCredential=
+// fill for a service, and $CREDENTIALS_DIRECTORY names. It must exist.
+func credentialPath(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("No credential %s: CREDENTIALS_DIRECTORY is not set, so no credentials were passed in; "+
+			"give the service LoadCredential=%s:FILE", name, name)
+	}
+
+	return secretFile(dir, "credential", name)
+}
+
+// secretPath returns the file that holds the container secret name,
+// which must exist.
+func secretPath(name string) (string, error) {
+	return secretFile(secretsDir, "secret", name)
+}
+
+// secretFile returns the file called name in dir, which holds a secret
+// of the kind given. The name may not lead out of dir.
+func secretFile(dir, kind, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return "", fmt.Errorf("Bad %s name %q", kind, name)
+	}
+
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("No %s %s in %s: %v", kind, name, dir, err)
+	}
+
+	return path, nil
+}
+
+// sealedPassphrase supplies the first line of the credential in the
+// file name, as systemd-creds decrypt unseals it: one encrypted with
+// systemd-creds encrypt --with-key=tpm2, and perhaps --tpm2-pcrs, can
+// only be unsealed by this machine's TPM, and only while the PCRs hold
+// what they did then. The credential's name must be that of the file,
+// as systemd-creds encrypt gives it by default.
+func sealedPassphrase(name string) symcrypt.PassphraseProvider {
+	return symcrypt.PassphraseFunc(func() ([]byte, error) {
+		cmd := exec.Command("systemd-creds", "decrypt", name, "-")
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("Unsealing %s: systemd-creds: %w", name, err)
+		}
+		if i := bytes.IndexByte(out, '\n'); i >= 0 {
+			out = out[:i]
+		}
+
+		return bytes.TrimSuffix(out, []byte("\r")), nil
+	})
+}
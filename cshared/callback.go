@@ -0,0 +1,23 @@
+//go:build cgo
+
+package main
+
+// Go cannot call C function pointers, so it calls these. They live
+// apart from the exported functions, as a preamble next to //export
+// may only declare.
+
+/*
+#include <stddef.h>
+
+typedef long (*decsym_read_fn)(void *ctx, char *buf, size_t len);
+typedef long (*decsym_write_fn)(void *ctx, const char *buf, size_t len);
+
+long decsym_call_read(decsym_read_fn f, void *ctx, char *buf, size_t len) {
+	return f(ctx, buf, len);
+}
+
+long decsym_call_write(decsym_write_fn f, void *ctx, const char *buf, size_t len) {
+	return f(ctx, buf, len);
+}
+*/
+import "C"
@@ -0,0 +1,161 @@
+//go:build cgo
+
+// Command cshared exports symcrypt as a C library, so that e.g. Python
+// or Ruby backup tooling can link it instead of running the command.
+// Build it with
+//
+//	go build -buildmode=c-shared -o libdecsym.so ./cshared
+//
+// which also writes libdecsym.h. All functions return 0 on success. On
+// failure they return -1 and, if err is not NULL, set *err to a
+// message. Buffers returned through out and err must be released with
+// decsym_free.
+package main
+
+/*
+#include <stddef.h>
+#include <stdlib.h>
+
+// A read callback returns the number of bytes it put into buf, 0 at
+// the end of the input, or -1 on error. A write callback returns the
+// number of bytes it wrote, which must be len unless there was an
+// error.
+typedef long (*decsym_read_fn)(void *ctx, char *buf, size_t len);
+typedef long (*decsym_write_fn)(void *ctx, const char *buf, size_t len);
+
+long decsym_call_read(decsym_read_fn f, void *ctx, char *buf, size_t len);
+long decsym_call_write(decsym_write_fn f, void *ctx, const char *buf, size_t len);
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"unsafe"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+func main() {}
+
+// decsym_decrypt decrypts the message of inlen bytes at in with the
+// passphrase of passlen bytes at pass, setting *out and *outlen to the
+// plain text.
+//
+//export decsym_decrypt
+func decsym_decrypt(in *C.char, inlen C.size_t, pass *C.char, passlen C.size_t,
+	out **C.char, outlen *C.size_t, err **C.char) C.int {
+	var buf bytes.Buffer
+	d := symcrypt.NewDecryptor(symcrypt.WithPassphrase(C.GoBytes(unsafe.Pointer(pass), C.int(passlen))))
+	if _, e := d.Decrypt(&buf, bytes.NewReader(C.GoBytes(unsafe.Pointer(in), C.int(inlen)))); e != nil {
+		return failed(err, e)
+	}
+
+	*out = (*C.char)(C.CBytes(buf.Bytes()))
+	*outlen = C.size_t(buf.Len())
+	return 0
+}
+
+// decsym_encrypt encrypts the inlen bytes at in with the passphrase,
+// setting *out and *outlen to the binary message.
+//
+//export decsym_encrypt
+func decsym_encrypt(in *C.char, inlen C.size_t, pass *C.char, passlen C.size_t,
+	out **C.char, outlen *C.size_t, err **C.char) C.int {
+	var buf bytes.Buffer
+	w, e := symcrypt.NewEncryptWriter(&buf, C.GoBytes(unsafe.Pointer(pass), C.int(passlen)))
+	if e == nil {
+		_, e = w.Write(C.GoBytes(unsafe.Pointer(in), C.int(inlen)))
+	}
+	if e == nil {
+		e = w.Close()
+	}
+	if e != nil {
+		return failed(err, e)
+	}
+
+	*out = (*C.char)(C.CBytes(buf.Bytes()))
+	*outlen = C.size_t(buf.Len())
+	return 0
+}
+
+// decsym_decrypt_stream decrypts the message read by calling read with
+// rctx, writing the plain text by calling write with wctx, so neither
+// has to fit in memory. As with the command line tool, the plain text
+// must be discarded if it fails.
+//
+//export decsym_decrypt_stream
+func decsym_decrypt_stream(read C.decsym_read_fn, rctx unsafe.Pointer,
+	write C.decsym_write_fn, wctx unsafe.Pointer,
+	pass *C.char, passlen C.size_t, err **C.char) C.int {
+	d := symcrypt.NewDecryptor(symcrypt.WithPassphrase(C.GoBytes(unsafe.Pointer(pass), C.int(passlen))))
+	src := &cReader{fn: read, ctx: rctx}
+	dst := &cWriter{fn: write, ctx: wctx}
+	if _, e := d.Decrypt(dst, src); e != nil {
+		return failed(err, e)
+	}
+
+	return 0
+}
+
+// decsym_free releases a buffer returned by the other functions.
+//
+//export decsym_free
+func decsym_free(p unsafe.Pointer) {
+	C.free(p)
+}
+
+func failed(err **C.char, e error) C.int {
+	if err != nil {
+		*err = C.CString(e.Error())
+	}
+
+	return -1
+}
+
+var (
+	errReadCallback  = errors.New("read callback failed")
+	errWriteCallback = errors.New("write callback failed")
+)
+
+// cReader and cWriter call the C callbacks. Go memory may be passed to
+// C for the duration of a call, so p is handed over directly.
+type cReader struct {
+	fn  C.decsym_read_fn
+	ctx unsafe.Pointer
+}
+
+func (r *cReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n := int(C.decsym_call_read(r.fn, r.ctx, (*C.char)(unsafe.Pointer(&p[0])), C.size_t(len(p))))
+	switch {
+	case n < 0:
+		return 0, errReadCallback
+	case n == 0:
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+type cWriter struct {
+	fn  C.decsym_write_fn
+	ctx unsafe.Pointer
+}
+
+func (w *cWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n := int(C.decsym_call_write(w.fn, w.ctx, (*C.char)(unsafe.Pointer(&p[0])), C.size_t(len(p))))
+	if n != len(p) {
+		return max(n, 0), errWriteCallback
+	}
+
+	return n, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// A compression format that -auto-decompress recognizes in the plain
+// text, by its magic number, and the extension of files in it.
+type plainCompression struct {
+	name   string
+	ext    string
+	magic  []byte
+	reader func(io.Reader) (io.ReadCloser, error)
+}
+
+var plainCompressions = []plainCompression{
+	{"gzip", ".gz", []byte{0x1f, 0x8b}, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}},
+	{"zstd", ".zst", []byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.ReadCloser, error) {
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}},
+	{"xz", ".xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0}, func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	}},
+	{"bzip2", ".bz2", []byte("BZh"), func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	}},
+}
+
+// Enough of the plain text to tell its compression by: the longest
+// magic number
+const compressionSniffLen = 6
+
+// decompressWriter passes the plain text written to it through to w,
+// unless it is compressed in one of plainCompressions, which is
+// decompressed, as it is written, into w instead. found is called once
+// the compression is known, before anything is written to w.
+type decompressWriter struct {
+	w     io.Writer
+	found func(c *plainCompression)
+
+	head    []byte
+	decided bool
+	// While compressed plain text is decompressed
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newDecompressWriter(w io.Writer, found func(c *plainCompression)) *decompressWriter {
+	return &decompressWriter{w: w, found: found}
+}
+
+func (d *decompressWriter) Write(p []byte) (int, error) {
+	if !d.decided {
+		d.head = append(d.head, p...)
+		if len(d.head) < compressionSniffLen {
+			return len(p), nil
+		}
+		if err := d.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if d.pw != nil {
+		return d.pw.Write(p)
+	}
+	return d.w.Write(p)
+}
+
+// decide looks at the start of the plain text, and either starts
+// decompressing it or passes it on as it is.
+func (d *decompressWriter) decide() error {
+	d.decided = true
+	head := d.head
+	d.head = nil
+
+	var c *plainCompression
+	for i := range plainCompressions {
+		if bytes.HasPrefix(head, plainCompressions[i].magic) {
+			c = &plainCompressions[i]
+			break
+		}
+	}
+	if c == nil {
+		_, err := d.w.Write(head)
+		return err
+	}
+	d.found(c)
+
+	pr, pw := io.Pipe()
+	d.pw = pw
+	d.done = make(chan error, 1)
+	go func() {
+		zr, err := c.reader(pr)
+		if err == nil {
+			_, err = io.Copy(d.w, zr)
+			zr.Close()
+		}
+		if err == nil {
+			// Anything after the compressed data is not plain text.
+			_, err = io.Copy(io.Discard, pr)
+		}
+		if err != nil {
+			err = fmt.Errorf("-auto-decompress: %s: %w", c.name, err)
+		}
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+
+	_, err := pw.Write(head)
+	return err
+}
+
+// Close finishes the plain text, and its decompression, returning its
+// error.
+func (d *decompressWriter) Close() error {
+	if !d.decided {
+		if err := d.decide(); err != nil {
+			return err
+		}
+	}
+	if d.pw == nil {
+		return nil
+	}
+
+	d.pw.Close()
+	return <-d.done
+}
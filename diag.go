@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// Whether diagnostics on stderr are for a human at a colour terminal.
+// See https://no-color.org for NO_COLOR.
+var colorStderr = os.Getenv("NO_COLOR") == "" && enableColor(os.Stderr)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiRed     = "\x1b[1;31m"
+	ansiYellow  = "\x1b[1;33m"
+	ansiAlarmBG = "\x1b[1;97;41m"
+)
+
+// Exit statuses
+const (
+	exitFailure = 1
+	exitUsage   = 2
+	// The passphrase, or key, decrypted none of the session keys
+	exitWrongPassphrase = 3
+	// The session key was right, but the encrypted data is corrupt:
+	// it failed its integrity check or did not decrypt to a valid
+	// message
+	exitCorrupt = 4
+	// Killed by a signal; 128 + the signal number is added, as shells do
+	exitSignal = 128
+)
+
+var (
+	exitMu    sync.Mutex
+	exitFuncs []func()
+	// What exitf reported, for the -audit-log
+	exitMessage string
+)
+
+// openLogFile sends the log to the -log-file instead of stderr, with
+// each line marked with the process ID, as runs from cron share it.
+func openLogFile() {
+	if logFile == "" {
+		return
+	}
+
+	flags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+	if logTruncate {
+		flags |= os.O_TRUNC
+	}
+	fd, err := os.OpenFile(logFile, flags, os.FileMode(logFileMode))
+	if err != nil {
+		fatalf("Log file: %v", err)
+	}
+	log.SetOutput(fd)
+	log.SetPrefix(fmt.Sprintf("decrypt-symmetric[%d]: ", os.Getpid()))
+	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	colorStderr = false
+}
+
+// atExit arranges for f to be called when the program exits because
+// of an error.
+func atExit(f func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+
+	exitFuncs = append(exitFuncs, f)
+}
+
+// exit runs the functions registered with atExit and exits.
+func exit(code int) {
+	exitMu.Lock()
+	for _, f := range exitFuncs {
+		f()
+	}
+
+	os.Exit(code)
+}
+
+// fatalf reports an error and exits.
+func fatalf(format string, args ...interface{}) {
+	exitf(exitFailure, format, args...)
+}
+
+// exitf reports an error and exits with status code.
+func exitf(code int, format string, args ...interface{}) {
+	exitMessage = fmt.Sprintf(format, args...)
+	loggingError.Store(true)
+	if jsonErrors {
+		reportError(code, reportInput, exitMessage, args)
+		if logFile != "" || logTarget != logStderr {
+			log.Printf(format, args...)
+		} else {
+			// What the cleanup logs, e.g. that a partial output was
+			// removed, would follow the object as prose
+			log.SetOutput(io.Discard)
+			colorStderr = false
+		}
+		exit(code)
+	}
+	if !colorStderr {
+		log.Printf(format, args...)
+	} else {
+		fmt.Fprintf(os.Stderr, ansiRed+"error:"+ansiReset+" "+format+"\n",
+			args...)
+	}
+
+	exit(code)
+}
+
+// cleanupf logs what was done to clean up after a failure, such as
+// removing a partial output. With -json, stderr only has the objects of
+// the failures, so it only goes to the -log-file or -log-target.
+func cleanupf(format string, args ...interface{}) {
+	if jsonErrors && logFile == "" && logTarget == logStderr {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// warnf reports a warning, which, unlike an error, lets the operation
+// go on.
+func warnf(format string, args ...interface{}) {
+	if !colorStderr {
+		// The log target takes the priority from the prefix
+		log.Printf("Warning: "+format, args...)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, ansiYellow+"warning:"+ansiReset+" "+format+"\n", args...)
+}
+
+// handleDumpSignals has the dumpSignals print the stacks of all
+// goroutines, to see where a hang is, and let the operation go on,
+// rather than end it as Go does for SIGQUIT.
+func handleDumpSignals() {
+	if len(dumpSignals) == 0 {
+		return
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, dumpSignals...)
+	go func() {
+		for range c {
+			dumpStacks()
+		}
+	}()
+}
+
+// dumpStacks prints the stacks of all goroutines to stderr.
+func dumpStacks() {
+	buf := make([]byte, 256*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			fmt.Fprintln(os.Stderr, string(buf[:n]))
+			return
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// isIntegrityError tells whether err means the plain text that was
+// output cannot be trusted, because its MDC, or the MAC of another
+// format, did not match.
+func isIntegrityError(err error) bool {
+	return errors.Is(err, symcrypt.ErrIntegrityFailed) || errors.Is(err, errSecretboxCorrupt) ||
+		errors.Is(err, errScryptCorrupt)
+}
+
+// integrityFailed reports an integrity failure so that it cannot be
+// missed among the rest of the output, and exits.
+func integrityFailed(err error) {
+	if jsonErrors {
+		exitf(exitCorrupt, "Data corrupted: %v", err)
+	}
+	if !colorStderr {
+		log.Printf("Data corrupted: %v", err)
+		exit(exitCorrupt)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, ansiAlarmBG+" INTEGRITY CHECK FAILED "+ansiReset)
+	fmt.Fprintln(os.Stderr, ansiRed+"The plain text output is corrupt or has been tampered with. Do not use it."+ansiReset)
+	fmt.Fprintf(os.Stderr, ansiRed+"error:"+ansiReset+" %v\n", err)
+	exit(exitCorrupt)
+}
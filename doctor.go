@@ -0,0 +1,270 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+	"golang.org/x/term"
+)
+
+func init() {
+	subcommands["doctor"] = doctor
+}
+
+// How a doctor check came out
+const (
+	checkOK   = "ok"
+	checkNote = "note"
+	checkWarn = "warn"
+	checkFail = "FAIL"
+)
+
+// Spooling the plain text of a large message, with
+// -verify-before-output, needs at least this much in the temporary
+// directory not to run out soon
+const lowTempSpace = 1 << 30
+
+// A doctorCheck is one finding of doctor: its outcome, what was
+// checked, what was found, and what to do about it, if anything.
+type doctorCheck struct {
+	status string
+	name   string
+	detail string
+	fix    string
+}
+
+// doctor checks the environment this runs in for what decryption and
+// its passphrase sources need, printing one finding per line on
+// stdout, and fails if any is a failure.
+func doctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric [flags] doctor")
+		fs.PrintDefaults()
+	}
+	configureSubcommand(fs, "doctor", args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	checks := []doctorCheck{
+		checkTerminal(),
+		checkAgent(),
+	}
+	checks = append(checks, checkBackends()...)
+	checks = append(checks, checkAES(), checkCiphers(), checkLocale(), checkTempDir())
+
+	return reportChecks(os.Stdout, checks)
+}
+
+// reportChecks prints checks on w and returns the exit status.
+func reportChecks(w io.Writer, checks []doctorCheck) int {
+	status := 0
+	for _, c := range checks {
+		fmt.Fprintf(w, "%-4s %s: %s\n", c.status, c.name, c.detail)
+		if c.fix != "" {
+			fmt.Fprintf(w, "     %s\n", c.fix)
+		}
+		if c.status == checkFail {
+			status = exitFailure
+		}
+	}
+
+	return status
+}
+
+// checkTerminal tells whether a passphrase can be prompted for: at
+// stdin, or at the controlling terminal when stdin is the input.
+func checkTerminal() doctorCheck {
+	c := doctorCheck{name: "Terminal"}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		c.status, c.detail = checkOK, "stdin is a terminal, to prompt for passphrases at"
+		return c
+	}
+	tty, err := os.OpenFile(ttyPath, os.O_WRONLY, 0)
+	if err != nil {
+		c.status = checkWarn
+		c.detail = fmt.Sprintf("none to prompt for passphrases at: %v", err)
+		c.fix = "Give the passphrase with -passphrase-file, -passphrase-fd or -passphrase-env, and -batch to be sure nothing prompts"
+		return c
+	}
+	tty.Close()
+	c.status, c.detail = checkOK, fmt.Sprintf("stdin is not a terminal, but %s is, to prompt for passphrases at", ttyPath)
+
+	return c
+}
+
+// checkAgent tells whether gpg-agent can be reached, for -use-agent and
+// -agent-keys.
+func checkAgent() doctorCheck {
+	c := doctorCheck{name: "gpg-agent"}
+	version, err := symcrypt.AgentVersion()
+	if err != nil {
+		c.status, c.detail = checkNote, fmt.Sprintf("unreachable: %v", err)
+		c.fix = "Only -use-agent and -agent-keys need it; install GnuPG 2.1 or later, with gpgconf on PATH"
+		return c
+	}
+	c.status, c.detail = checkOK, "version "+version
+
+	return c
+}
+
+// checkBackends lists which of the passphrase sources that need
+// something outside this program have it.
+func checkBackends() []doctorCheck {
+	var checks []doctorCheck
+
+	plugins := passphrasePlugins()
+	if len(plugins) == 0 {
+		checks = append(checks, doctorCheck{status: checkNote, name: "Passphrase plugins",
+			detail: "none on PATH", fix: "-passphrase-plugin NAME runs " + symcrypt.PluginPrefix + "NAME from PATH"})
+	} else {
+		checks = append(checks, doctorCheck{status: checkOK, name: "Passphrase plugins",
+			detail: strings.Join(plugins, ", ")})
+	}
+
+	for _, tool := range []struct{ cmd, flag string }{
+		{"systemd-creds", "-passphrase-tpm2"},
+		{"ykchalresp", "-passphrase-yubikey"},
+	} {
+		c := doctorCheck{name: tool.cmd}
+		if path, err := exec.LookPath(tool.cmd); err != nil {
+			c.status, c.detail = checkNote, "not on PATH"
+			c.fix = "Only " + tool.flag + " needs it"
+		} else {
+			c.status, c.detail = checkOK, path
+		}
+		checks = append(checks, c)
+	}
+
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		checks = append(checks, doctorCheck{status: checkOK, name: "systemd credentials",
+			detail: "in " + dir + ", for -passphrase-credential"})
+	}
+	if fi, err := os.Stat(secretsDir); err == nil && fi.IsDir() {
+		checks = append(checks, doctorCheck{status: checkOK, name: "Container secrets",
+			detail: "in " + secretsDir + ", for -passphrase-secret"})
+	}
+
+	return checks
+}
+
+// passphrasePlugins returns the names of the passphrase plugins on
+// PATH.
+func passphrasePlugins() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(dir, symcrypt.PluginPrefix+"*"))
+		for _, m := range matches {
+			name := strings.TrimPrefix(filepath.Base(m), symcrypt.PluginPrefix)
+			if _, err := exec.LookPath(m); err == nil && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// checkAES tells whether AES runs on instructions for it.
+func checkAES() doctorCheck {
+	c := doctorCheck{name: "Hardware AES"}
+	hw := hardwareAES()
+	switch {
+	case hw == "":
+		c.status = checkWarn
+		c.detail = fmt.Sprintf("none on this %s CPU; AES runs in software, several times slower", runtime.GOARCH)
+		c.fix = "In a VM, pass the host's AES instructions through, e.g. with the host CPU model"
+	case pureGo:
+		c.status, c.detail = checkWarn, hw+", but unused: this build has the purego tag"
+		c.fix = "Build without -tags purego"
+	default:
+		c.status, c.detail = checkOK, hw+", in use"
+	}
+
+	return c
+}
+
+// checkCiphers tells which ciphers messages may use here.
+func checkCiphers() doctorCheck {
+	if fipsMode || symcrypt.FIPSMode() {
+		return doctorCheck{status: checkNote, name: "Ciphers",
+			detail: "FIPS mode: only AES, with SHA-2 S2K; messages in the other ciphers are refused",
+			fix:    "Leave out -fips and GODEBUG=fips140=on for messages made by old software"}
+	}
+
+	c := doctorCheck{status: checkOK, name: "Ciphers", detail: strings.Join(supportedCiphers, ", ")}
+	if legacyCipher {
+		c.detail += ", and with -allow-legacy-ciphers " + strings.Join(legacyCiphers, ", ")
+	}
+
+	return c
+}
+
+// checkLocale tells whether the locale is UTF-8, as OpenPGP takes
+// passphrases and file names to be, so that what is typed at the
+// terminal is what was typed where the message was made.
+func checkLocale() doctorCheck {
+	c := doctorCheck{name: "Locale"}
+	if runtime.GOOS == "windows" {
+		c.status, c.detail = checkNote, "not checked: the console code page decides how passphrases are typed in"
+		return c
+	}
+
+	locale := "C"
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			locale = v
+			break
+		}
+	}
+	upper := strings.ToUpper(locale)
+	if strings.Contains(upper, "UTF-8") || strings.Contains(upper, "UTF8") {
+		c.status, c.detail = checkOK, locale
+		return c
+	}
+	c.status = checkWarn
+	c.detail = locale + " is not UTF-8: a passphrase with characters beyond ASCII is typed in another encoding than gpg expects"
+	c.fix = "Set LANG, e.g. LANG=C.UTF-8, and a terminal that uses it"
+
+	return c
+}
+
+// checkTempDir tells whether the temporary directory, where
+// -verify-before-output spools large plain texts, is writable and has
+// room.
+func checkTempDir() doctorCheck {
+	dir := os.TempDir()
+	c := doctorCheck{name: "Temporary directory"}
+	fd, err := os.CreateTemp(dir, "decrypt-symmetric-doctor-*")
+	if err != nil {
+		c.status, c.detail = checkFail, fmt.Sprintf("%s is not writable: %v", dir, err)
+		c.fix = "Set TMPDIR to a writable directory"
+		return c
+	}
+	fd.Close()
+	os.Remove(fd.Name())
+
+	free, err := freeSpace(dir)
+	switch {
+	case err != nil:
+		c.status, c.detail = checkOK, fmt.Sprintf("%s is writable; its free space is unknown: %v", dir, err)
+	case free < lowTempSpace:
+		c.status, c.detail = checkWarn, fmt.Sprintf("%s has only %s free", dir, humanBytes(float64(free)))
+		c.fix = "-verify-before-output spools plain text beyond -max-memory there; set TMPDIR to a larger file system"
+	default:
+		c.status, c.detail = checkOK, fmt.Sprintf("%s, %s free", dir, humanBytes(float64(free)))
+	}
+
+	return c
+}
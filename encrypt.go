@@ -0,0 +1,476 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/term"
+)
+
+func init() {
+	subcommands["encrypt"] = encrypt
+}
+
+// encrypt encrypts the file named in args, or -filename, or stdin, with
+// a passphrase, writing the message to the -outputs. A directory is
+// encrypted file by file into a mirrored tree, at the -output.
+func encrypt(args []string) int {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric [flags] encrypt [encrypt flags] [FILE]")
+		fs.PrintDefaults()
+	}
+	cipherName := fs.String("cipher", "AES-256", "Cipher to encrypt with")
+	s2kCount := fs.Int("s2k-count", 0,
+		"Bytes hashed by the S2K to derive the key. (Default is the maximum, 65011712)")
+	compress := fs.String("compress", "none", "Compress the plain text first: none, zip or zlib")
+	armored := fs.Bool("armor", false, "Write ASCII armored output")
+	var armorHeaders stringList
+	fs.Var(&armorHeaders, "armor-header",
+		"Add the armor header KEY=VALUE, e.g. Comment=backup, or leave KEY out with KEY=. May be repeated")
+	noVersion := fs.Bool("no-version-header", false, "Leave the Version header out of the armor")
+	minEntropy := fs.Float64("min-entropy", 0,
+		"Refuse passphrases estimated to be weaker than this many bits")
+	genStyle := fs.String("gen-passphrase", "",
+		"Generate a random passphrase, of words or bytes, show it on the terminal once and encrypt with it")
+	signKey := fs.String("sign-key", "", "Also sign the plain text with the secret key in this `file`")
+	var recipients stringList
+	fs.Var(&recipients, "recipient",
+		"Also encrypt to the public keys in this `file`, which can then decrypt it instead of the passphrase. May be repeated")
+	var escrowTo stringList
+	fs.Var(&escrowTo, "escrow-to",
+		"Also write the session key, encrypted to the public keys in this `file`, beside the output, as OUTPUT"+escrowSuffix+". May be repeated")
+	hideName := fs.Bool("hide-filename", false,
+		"Record no file name or modification time in the message, so that it leaks nothing about its contents")
+	jobs := fs.Int("jobs", 1, "When encrypting a directory, encrypt this many files at a time")
+	gnupgVersion := fs.String("gnupg-compat", "",
+		"Only write what this GnuPG version reads: "+strings.Join(gnupgVersionNames(), ", "))
+	keyFD := fs.Int("print-session-key", -1,
+		"Write the session key of each message, as ALGO:HEX and its name, to this file descriptor, e.g. 3, to escrow it")
+	fs.StringVar(&encryptFormat, "format", formatOpenPGP,
+		"Write an OpenPGP message, or openssl for the format of openssl enc -aes-256-cbc -pbkdf2, which has no integrity protection, "+
+			"or secretbox for a minimal Argon2id and XSalsa20-Poly1305 container")
+	argon2Time := fs.Uint("argon2-time", secretboxTime, "Argon2id passes of -format secretbox")
+	argon2Memory := fs.Uint("argon2-memory", secretboxMemory>>10, "Argon2id memory of -format secretbox, in MiB")
+	argon2Threads := fs.Uint("argon2-threads", secretboxThreads, "Argon2id lanes of -format secretbox")
+	configureSubcommand(fs, "encrypt", args)
+
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		filename = fs.Arg(0)
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+
+	headers, err := parseArmorHeaders(armorHeaders, !*noVersion)
+	if err != nil {
+		exitf(exitUsage, "%v", err)
+	}
+	if !*armored && (len(armorHeaders) != 0 || *noVersion) {
+		exitf(exitUsage, "-armor-header and -no-version-header need -armor")
+	}
+
+	ci, ok := parseCipher(*cipherName)
+	if !ok {
+		fatalf("Unknown cipher %q", *cipherName)
+	}
+	var algo packet.CompressionAlgo
+	switch *compress {
+	case "none":
+		algo = packet.CompressionNone
+	case "zip":
+		algo = packet.CompressionZIP
+	case "zlib":
+		algo = packet.CompressionZLIB
+	default:
+		fatalf("Unknown compression %q", *compress)
+	}
+
+	switch encryptFormat {
+	case formatOpenPGP:
+	case formatOpenSSL, formatSecretbox:
+		if encryptFormat == formatOpenSSL {
+			checkOpenSSLIter()
+		}
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"-armor", *armored},
+			{"-cipher", ci != symcrypt.CipherAES256},
+			{"-s2k-count", *s2kCount != 0},
+			{"-compress", algo != packet.CompressionNone},
+			{"-sign-key", *signKey != ""},
+			{"-recipient", len(recipients) != 0},
+			{"-fips", fipsMode},
+			{"-print-session-key", *keyFD >= 0},
+			{"-escrow-to", len(escrowTo) != 0},
+			{"-gnupg-compat", *gnupgVersion != ""},
+		} {
+			if f.set {
+				exitf(exitUsage, "-format %s cannot be combined with %s", encryptFormat, f.name)
+			}
+		}
+		if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+			exitf(exitUsage, "-format %s cannot encrypt a directory", encryptFormat)
+		}
+	default:
+		exitf(exitUsage, "Unknown -format %q: want %s, %s or %s", encryptFormat, formatOpenPGP, formatOpenSSL, formatSecretbox)
+	}
+	argon2 := argon2Params{Time: uint32(*argon2Time), Memory: uint32(*argon2Memory << 10), Threads: uint8(*argon2Threads)}
+	switch {
+	case *argon2Time > maxSecretboxTime || *argon2Memory > maxSecretboxMemory>>10 || *argon2Threads > 255:
+		exitf(exitUsage, "Argon2id parameters out of range: at most %d passes, %d MiB and 255 lanes",
+			maxSecretboxTime, maxSecretboxMemory>>10)
+	case encryptFormat != formatSecretbox && argon2 != defaultArgon2:
+		exitf(exitUsage, "-argon2-time, -argon2-memory and -argon2-threads need -format secretbox")
+	}
+	if err := argon2.check(); err != nil {
+		exitf(exitUsage, "%v", err)
+	}
+
+	var compat gnupgCompat
+	if *gnupgVersion != "" {
+		compat = gnupgCompatFor(*gnupgVersion)
+		compat.checkCipher(*gnupgVersion, ci)
+	}
+
+	var keys *sessionKeyLog
+	if *keyFD >= 0 {
+		if *keyFD <= 2 {
+			exitf(exitUsage, "-print-session-key needs a file descriptor other than stdin, stdout or stderr")
+		}
+		keys = &sessionKeyLog{f: os.NewFile(uintptr(*keyFD), fmt.Sprintf("fd %d", *keyFD))}
+	}
+
+	var tree *treeEncryption
+	if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+		tree = newTreeEncryption(filename)
+	} else {
+		autoOutput(true, *armored)
+	}
+	if len(escrowTo) != 0 && tree == nil && (len(outputs) == 0 || slices.Contains(outputs, "-")) {
+		exitf(exitUsage, "-escrow-to needs an -output file, beside which its escrow file goes")
+	}
+	switch {
+	case *jobs < 1:
+		exitf(exitUsage, "-jobs must be at least 1")
+	case *jobs > 1 && tree == nil:
+		exitf(exitUsage, "-jobs needs a directory to encrypt")
+	case *jobs > 1 && auditFile != "":
+		// Its digest is of the input as read, which would interleave
+		exitf(exitUsage, "-jobs cannot be combined with -audit-log")
+	}
+	if !*armored && tree == nil && term.IsTerminal(int(os.Stdout.Fd())) &&
+		(len(outputs) == 0 || len(outputs) == 1 && outputs[0] == "-") {
+		exitf(exitUsage, "Refusing to write binary cipher text to a terminal; use -armor or -output")
+	}
+
+	var fd io.ReadCloser = os.Stdin
+	if filename != "" && tree == nil {
+		var err error
+		fd, err = openSource(filename)
+		if err != nil {
+			fatalf("Input: %v", err)
+		}
+		defer fd.Close()
+	}
+
+	var signer *openpgp.Entity
+	if *signKey != "" {
+		var err error
+		if signer, err = readSigningKey(*signKey); err != nil {
+			fatalf("Signing key: %v", err)
+		}
+	}
+
+	var to []*openpgp.Entity
+	for _, name := range recipients {
+		kr, err := readKeyRing(name)
+		if err != nil {
+			fatalf("Recipient: %v", err)
+		}
+		to = append(to, kr...)
+	}
+	if *gnupgVersion != "" {
+		compat.checkKeys(*gnupgVersion, "-recipient", to)
+		if signer != nil {
+			compat.checkKeys(*gnupgVersion, "-sign-key", []*openpgp.Entity{signer})
+		}
+	}
+
+	var escrow *keyEscrow
+	if len(escrowTo) != 0 {
+		escrow = &keyEscrow{}
+		for _, name := range escrowTo {
+			kr, err := readKeyRing(name)
+			if err != nil {
+				fatalf("Escrow key: %v", err)
+			}
+			escrow.to = append(escrow.to, kr...)
+		}
+	}
+
+	var pass []byte
+	if *genStyle != "" {
+		if !interactivePassphrase() || useAgent {
+			exitf(exitUsage, "-gen-passphrase cannot be combined with another passphrase source")
+		}
+		if batchMode {
+			exitf(exitUsage, "-gen-passphrase cannot be used with -batch, as it shows the passphrase on the terminal")
+		}
+		gen, err := generatePassphrase(*genStyle)
+		if err != nil {
+			exitf(exitUsage, "%v", err)
+		}
+		if err := showOnTerminal(gen); err != nil {
+			fatalf("Passphrase: %v", err)
+		}
+		pass = []byte(gen)
+	} else {
+		pass = encryptPassphrase(*minEntropy)
+	}
+	if yubikeySlot != 0 {
+		derived, err := yubikeyResponse(yubikeySlot, pass)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		clear(pass)
+		pass = derived
+	}
+
+	opts := []symcrypt.Option{
+		symcrypt.WithCipher(ci),
+		symcrypt.WithCompression(algo),
+		symcrypt.WithS2KCount(*s2kCount),
+	}
+	if filename != "" && tree == nil && !*hideName {
+		opts = append(opts, symcrypt.WithFileName(filepath.Base(filename)))
+		if fi, err := os.Stat(filename); err == nil {
+			opts = append(opts, symcrypt.WithModTime(fi.ModTime()))
+		}
+	}
+	if fipsMode {
+		opts = append(opts, symcrypt.WithFIPSMode())
+	}
+	if signer != nil {
+		opts = append(opts, symcrypt.WithSigner(signer))
+	}
+	if len(to) != 0 {
+		opts = append(opts, symcrypt.WithRecipients(to...))
+	}
+	if keys != nil && tree == nil {
+		opts = append(opts, symcrypt.WithSessionKeyFunc(keys.record(manifestName(outputs...))))
+	}
+
+	audit, err := openAudit("encrypt")
+	if err != nil {
+		fatalf("Audit log: %v", err)
+	}
+	man := openManifest("encrypt")
+	if tree != nil {
+		tree.pass, tree.opts, tree.audit, tree.manifest = pass, opts, audit, man
+		tree.armored, tree.headers, tree.hideName = *armored, headers, *hideName
+		tree.jobs, tree.keys, tree.escrow = *jobs, keys, escrow
+		tree.run()
+		audit.finish(nil, "")
+		if err := man.write(); err != nil {
+			fatalf("Manifest: %v", err)
+		}
+		return 0
+	}
+
+	var escrowed *escrowedKey
+	if escrow != nil {
+		var opt symcrypt.Option
+		escrowed, opt = escrow.capture()
+		opts = append(opts, opt)
+	}
+	dst, outFDs := openOutputs()
+	var aw io.WriteCloser
+	if *armored {
+		aw, err = symcrypt.NewArmorWriter(dst, headers...)
+		if err != nil {
+			fatalf("Output: %v", err)
+		}
+		dst = aw
+	}
+
+	var w io.WriteCloser
+	switch encryptFormat {
+	case formatOpenSSL:
+		w, err = newOpenSSLWriter(dst, pass)
+	case formatSecretbox:
+		w, err = newSecretboxWriter(dst, pass, argon2)
+	default:
+		w, err = symcrypt.NewEncryptWriter(dst, pass, opts...)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var src io.Reader = audit.reader(fd)
+	rec := man.start(manifestName(filename), manifestName(outputs...))
+	if rec != nil {
+		src = io.TeeReader(src, rec)
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		copyFailed(fd, err)
+	}
+	if err := w.Close(); err != nil {
+		fatalf("Output: %v", err)
+	}
+	if aw != nil {
+		if err := aw.Close(); err != nil {
+			fatalf("Output: %v", err)
+		}
+	}
+
+	if keys != nil {
+		// A message whose key was not escrowed is not kept
+		if err := keys.check(); err != nil {
+			fatalf("Session key: %v", err)
+		}
+	}
+	var escrowFDs []*outputFile
+	if escrowed != nil {
+		for _, name := range outputs {
+			o, err := escrowed.write(name)
+			if err != nil {
+				fatalf("Escrow: %v", err)
+			}
+			escrowFDs = append(escrowFDs, o)
+		}
+	}
+	for _, outFD := range outFDs {
+		if err := outFD.commit(); err != nil {
+			fatalf("Output: %v", err)
+		}
+	}
+	for _, o := range escrowFDs {
+		if err := o.commit(); err != nil {
+			fatalf("Escrow: %v", err)
+		}
+	}
+	audit.finish(nil, "")
+	rec.done(nil)
+	if err := man.write(); err != nil {
+		fatalf("Manifest: %v", err)
+	}
+
+	return 0
+}
+
+// sessionKeyLog writes the session keys of the messages encrypted to
+// the -print-session-key file descriptor, a line each, of the cipher
+// and key as gpg --show-session-key and --override-session-key have
+// them, e.g. 9:6A0F..., and the name of the message.
+type sessionKeyLog struct {
+	f   *os.File
+	mu  sync.Mutex
+	err error
+}
+
+// record returns the function that writes the session key of the
+// message named name.
+func (l *sessionKeyLog) record(name string) func(symcrypt.Cipher, []byte) {
+	return func(ci symcrypt.Cipher, key []byte) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, err := fmt.Fprintf(l.f, "%d:%X  %s\n", ci, key, quoteName(name)); err != nil && l.err == nil {
+			l.err = err
+		}
+	}
+}
+
+// check returns the first error writing a session key.
+func (l *sessionKeyLog) check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+// encryptPassphrase gets the passphrase to encrypt with. One that is
+// typed in is checked for strength, with a warning if it is weak, and
+// any is refused if it is estimated below minBits.
+func encryptPassphrase(minBits float64) []byte {
+	provider, retries := passphraseProvider(passphrase, "encrypt")
+	pass, err := provider.Passphrase()
+	for ; errors.Is(err, symcrypt.ErrPassphraseMismatch) && retries > 0; retries-- {
+		log.Printf("%v, try again", err)
+		pass, err = provider.Passphrase()
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if len(pass) == 0 {
+		fatalf("Empty passphrase")
+	}
+
+	bits := passphraseBits(string(pass))
+	switch {
+	case minBits > 0 && bits < minBits:
+		fatalf("Passphrase too weak: about %.0f bits, -min-entropy is %.0f", bits, minBits)
+	case interactivePassphrase() && bits < weakPassphraseBits:
+		warnf("weak passphrase, about %.0f bits; the message is only as strong as it is", bits)
+	}
+
+	return pass
+}
+
+// parseArmorHeaders parses the -armor-header flags into the armor
+// headers to write, after a Version header if version is set. KEY=
+// leaves out KEY, including Version.
+func parseArmorHeaders(flags []string, version bool) ([]symcrypt.ArmorHeader, error) {
+	var headers []symcrypt.ArmorHeader
+	if version {
+		headers = append(headers, symcrypt.ArmorHeader{Key: "Version",
+			Value: "decrypt-symmetric " + buildVersion()})
+	}
+	for _, f := range flags {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("Bad -armor-header %q: want KEY=VALUE", f)
+		}
+		if v == "" {
+			kept := headers[:0]
+			for _, h := range headers {
+				if !strings.EqualFold(h.Key, k) {
+					kept = append(kept, h)
+				}
+			}
+			headers = kept
+			continue
+		}
+		headers = append(headers, symcrypt.ArmorHeader{Key: k, Value: v})
+	}
+
+	return headers, nil
+}
+
+// parseCipher looks a cipher up by name, e.g. AES-256, aes256 or 3des.
+func parseCipher(name string) (symcrypt.Cipher, bool) {
+	norm := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(s, "-", ""))
+	}
+	for _, c := range symcrypt.Ciphers() {
+		if norm(c.String()) == norm(name) {
+			return c, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// Categories of the failures -json reports, for orchestrators to
+// branch on
+const (
+	categoryUsage           = "usage"
+	categoryWrongPassphrase = "wrong-passphrase"
+	categoryPassphrase      = "passphrase"
+	categoryIntegrity       = "integrity"
+	categoryCorrupt         = "corrupt"
+	categoryFormat          = "format"
+	categoryUnsupported     = "unsupported"
+	categoryNotFound        = "not-found"
+	categoryPermission      = "permission"
+	categoryNetwork         = "network"
+	categoryTimeout         = "timeout"
+	categorySignal          = "signal"
+	categoryFailure         = "failure"
+)
+
+// errorReport is a failure as -json reports it, one JSON object per
+// line on stderr.
+type errorReport struct {
+	Code     int    `json:"code"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
+	Input    string `json:"input,omitempty"`
+	// How far into the input had been read; a pointer, as 0 is an
+	// offset too
+	Offset *int64 `json:"offset,omitempty"`
+}
+
+var (
+	// The input being decrypted, for reports of its failure, and how
+	// far into it has been read, once it is open
+	reportInput  string
+	reportOffset func() int64
+)
+
+// reportError writes the failure msg, with exit status code and the
+// first error among args, if any, to stderr as JSON.
+func reportError(code int, input, msg string, args []interface{}) {
+	var err error
+	for _, arg := range args {
+		if e, ok := arg.(error); ok {
+			err = e
+			break
+		}
+	}
+
+	r := errorReport{
+		Code:     code,
+		Category: errorCategory(code, err),
+		Message:  strings.TrimSpace(msg),
+		Input:    input,
+	}
+	if reportOffset != nil && input == reportInput {
+		off := reportOffset()
+		r.Offset = &off
+	}
+	json.NewEncoder(os.Stderr).Encode(r)
+}
+
+// errorCategory returns the category of a failure with exit status
+// code, caused by err, which may be nil.
+func errorCategory(code int, err error) string {
+	var netErr net.Error
+	switch {
+	case code == exitUsage:
+		return categoryUsage
+	case code == exitWrongPassphrase:
+		return categoryWrongPassphrase
+	case isIntegrityError(err):
+		return categoryIntegrity
+	case code == exitCorrupt:
+		return categoryCorrupt
+	case code > exitSignal:
+		return categorySignal
+	case err == nil:
+		return categoryFailure
+	case errors.Is(err, symcrypt.ErrNoPassphrase) || errors.Is(err, symcrypt.ErrPassphraseMismatch) ||
+		errors.Is(err, errBatchPrompt):
+		return categoryPassphrase
+	case errors.Is(err, symcrypt.ErrInvalidMessage) || errors.Is(err, symcrypt.ErrNotEncrypted) ||
+		errors.Is(err, symcrypt.ErrNotPassphraseEncrypted):
+		return categoryFormat
+	case errors.Is(err, symcrypt.ErrUnsupported) || errors.Is(err, symcrypt.ErrUnsupportedCipher) ||
+		errors.Is(err, symcrypt.ErrCipherNotAllowed) || errors.Is(err, symcrypt.ErrNotApproved):
+		return categoryUnsupported
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded):
+		return categoryTimeout
+	case errors.Is(err, fs.ErrNotExist):
+		return categoryNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return categoryPermission
+	case errors.As(err, &netErr):
+		return categoryNetwork
+	}
+
+	return categoryFailure
+}
+
+// errorCode returns the exit status a failure caused by err would have,
+// for the failures of single files that -files-from reports and goes
+// on.
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, symcrypt.ErrWrongPassphrase):
+		return exitWrongPassphrase
+	case isIntegrityError(err):
+		return exitCorrupt
+	}
+
+	return exitFailure
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+	"golang.org/x/crypto/openpgp"
+)
+
+// escrowSuffix is added to the name of a message for that of its escrow
+// file.
+const escrowSuffix = ".escrow"
+
+// keyEscrow is -escrow-to: beside each message encrypted, a file of its
+// session key encrypted to the public keys to, which cat puts back in
+// front of the message for their secret keys to decrypt it.
+type keyEscrow struct {
+	to []*openpgp.Entity
+}
+
+// escrowedKey is the session key of a message, encrypted.
+type escrowedKey struct {
+	packets []byte
+	err     error
+}
+
+// capture returns the option that has the session key of the message
+// encrypted with it escrowed in k.
+func (e *keyEscrow) capture() (*escrowedKey, symcrypt.Option) {
+	k := &escrowedKey{}
+
+	return k, symcrypt.WithSessionKeyFunc(func(ci symcrypt.Cipher, key []byte) {
+		k.packets, k.err = symcrypt.EncryptSessionKey(ci, key, e.to...)
+	})
+}
+
+// write creates the escrow file of the message named name, which is to
+// be committed along with it.
+func (k *escrowedKey) write(name string) (*outputFile, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	if k.packets == nil {
+		return nil, errors.New("no session key was escrowed")
+	}
+	o, err := createOutput(name + escrowSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := o.Write(k.packets); err != nil {
+		o.discard()
+		return nil, err
+	}
+
+	return o, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/tar"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func init() {
+	subcommands["extract"] = extract
+}
+
+// extraction is extract writing the members of an archive under root.
+type extraction struct {
+	root   *os.Root
+	filter memberFilter
+	// The number of leading directories taken off the names
+	strip int
+	// Whether to restore the xattrs and ACLs the archive records
+	xattrs bool
+	// The files and links created, to be removed if decryption fails
+	created []string
+	// The directories, whose modes and times are set last, as a
+	// read-only one would stop what goes in it, and writing in one
+	// changes its time
+	dirs []extractedDir
+	seen int
+}
+
+type extractedDir struct {
+	name  string
+	mode  fs.FileMode
+	mtime time.Time
+	// Its PAX records, for its xattrs
+	records map[string]string
+}
+
+// The PAX records of the xattrs and ACLs, as GNU tar and bsdtar write
+// them
+const (
+	paxXattr      = "SCHILY.xattr."
+	paxACLAccess  = "SCHILY.acl.access"
+	paxACLDefault = "SCHILY.acl.default"
+)
+
+// extract decrypts the message in the file named in args, or -filename,
+// or on stdin, to a tar archive, possibly gzipped, and extracts its
+// members that the -include and -exclude patterns select into the
+// -outdir, or the -C directory, or the current directory, in one pass.
+// It never leaves the directory, nor overwrites a file in it.
+func extract(args []string) int {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric [flags] extract [extract flags] [FILE]")
+		fs.PrintDefaults()
+	}
+	var x extraction
+	x.filter.flags(fs)
+	fs.IntVar(&x.strip, "strip-components", 0,
+		"Take this many leading directories off the names, as tar does, skipping the members with no more")
+	dir := fs.String("C", outDir, "Extract into this `directory`, instead of the -outdir")
+	fs.BoolVar(&x.xattrs, "preserve-xattrs", false,
+		"Restore the extended attributes, ACLs and file capabilities the archive records, e.g. with tar --xattrs --acls")
+	configureSubcommand(fs, "extract", args)
+
+	name := filename
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		name = fs.Arg(0)
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	x.filter.check()
+	if x.strip < 0 {
+		exitf(exitUsage, "-strip-components must not be negative")
+	}
+	if x.xattrs && !xattrsSupported {
+		exitf(exitUsage, "-preserve-xattrs is only implemented on Linux")
+	}
+	if len(outputs) != 0 {
+		exitf(exitUsage, "extract writes to the -outdir, or the current directory, not the -output")
+	}
+
+	if *dir == "" {
+		*dir = "."
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		fatalf("Output: %v", err)
+	}
+	root, err := os.OpenRoot(*dir)
+	if err != nil {
+		fatalf("Output: %v", err)
+	}
+	defer root.Close()
+	x.root = root
+
+	if name == "" {
+		name = "-"
+	}
+	fd, err := openSource(name)
+	if err != nil {
+		fatalf("Input: %v", err)
+	}
+	defer fd.Close()
+
+	atExit(x.removeCreated)
+	res, err := decryptTar(fd, x.member)
+	if err == nil {
+		err = x.setDirs()
+	}
+	if err != nil {
+		fatalf("Extract: %v", err)
+	}
+	reportMessage(&res)
+	log.Printf("Extracted %d of %d members to %s", len(x.created)+len(x.dirs), x.seen, *dir)
+
+	return 0
+}
+
+// member extracts the member hdr, whose data is in r, if it is
+// selected, and still has a name once -strip-components is applied.
+func (x *extraction) member(hdr *tar.Header, r io.Reader) error {
+	x.seen++
+	if !x.filter.match(hdr.Name) {
+		return nil
+	}
+	name := memberName(hdr.Name)
+	if name == "" {
+		// The top of the archive, ./
+		return nil
+	}
+	if leavesArchive(hdr.Name) {
+		log.Printf("Skipping %s, which leads out of the archive", quoteName(hdr.Name))
+		return nil
+	}
+	name, ok := stripComponents(name, x.strip)
+	if !ok {
+		return nil
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := x.root.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		f, err := x.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		x.created = append(x.created, name)
+		_, err = io.Copy(f, r)
+		if err == nil {
+			// Once written, which would clear the file capabilities
+			x.restoreXattrs(f, name, hdr.PAXRecords)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.root.Chmod(name, fs.FileMode(hdr.Mode).Perm()); err != nil {
+			return err
+		}
+		return x.root.Chtimes(name, hdr.ModTime, hdr.ModTime)
+	case tar.TypeDir:
+		if err := x.root.MkdirAll(name, 0o700); err != nil {
+			return err
+		}
+		x.dirs = append(x.dirs, extractedDir{name, fs.FileMode(hdr.Mode).Perm(), hdr.ModTime, hdr.PAXRecords})
+	case tar.TypeSymlink:
+		if err := x.root.Symlink(hdr.Linkname, name); err != nil {
+			return err
+		}
+		x.created = append(x.created, name)
+	case tar.TypeLink:
+		// The target is in the archive, and so is stripped too
+		target, ok := stripComponents(memberName(hdr.Linkname), x.strip)
+		if _, err := x.root.Lstat(target); leavesArchive(hdr.Linkname) || !ok || err != nil {
+			log.Printf("Skipping %s, a hard link to %s, which was not extracted", quoteName(hdr.Name),
+				quoteName(hdr.Linkname))
+			return nil
+		}
+		if err := x.root.Link(target, name); err != nil {
+			return err
+		}
+		x.created = append(x.created, name)
+	default:
+		log.Printf("Skipping %s, which is not a file, directory or link", quoteName(hdr.Name))
+	}
+
+	return nil
+}
+
+// setDirs gives the directories extracted their modes and times.
+func (x *extraction) setDirs() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		d := x.dirs[i]
+		// Last, so that a default ACL is not inherited by what was
+		// extracted into it, which has its own ACLs in the archive
+		if x.xattrs && hasXattrs(d.records) {
+			f, err := x.root.Open(d.name)
+			if err != nil {
+				return err
+			}
+			x.restoreXattrs(f, d.name, d.records)
+			f.Close()
+		}
+		if err := x.root.Chmod(d.name, d.mode); err != nil {
+			return err
+		}
+		if err := x.root.Chtimes(d.name, d.mtime, d.mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreXattrs restores on f, with -preserve-xattrs, the xattrs and
+// ACLs in records, the PAX records of the member extracted as name. It
+// is done before the mode is set, which the ACL agrees with, so that a
+// read-only file can still be opened for it. Those that cannot be, e.g.
+// file capabilities without CAP_SETFCAP, or on a file system without
+// xattrs, are warned about, as tar does. Symlinks are left alone: they
+// cannot be opened to set them on.
+func (x *extraction) restoreXattrs(f *os.File, name string, records map[string]string) {
+	if !x.xattrs || !hasXattrs(records) {
+		return
+	}
+	if err := restoreXattrs(f, records); err != nil {
+		warnf("%s: not all of its extended attributes were restored: %v", quoteName(name), err)
+	}
+}
+
+// hasXattrs tells whether the PAX records of a member hold xattrs or
+// ACLs.
+func hasXattrs(records map[string]string) bool {
+	for k := range records {
+		if strings.HasPrefix(k, paxXattr) || k == paxACLAccess || k == paxACLDefault {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeCreated removes the files and links extracted, when decryption
+// fails, as their contents cannot be trusted.
+func (x *extraction) removeCreated() {
+	for i := len(x.created) - 1; i >= 0; i-- {
+		x.root.Remove(x.created[i])
+	}
+	if len(x.created) > 0 {
+		cleanupf("Removed the %d files and links extracted", len(x.created))
+	}
+}
+
+// stripComponents takes the first n directories off the cleaned member
+// name, and reports whether anything of it is left.
+func stripComponents(name string, n int) (string, bool) {
+	for ; n > 0; n-- {
+		i := strings.IndexByte(name, '/')
+		if i < 0 {
+			return "", false
+		}
+		name = name[i+1:]
+	}
+
+	return name, true
+}
+
+// leavesArchive tells whether the member name goes up out of the top of
+// the archive, as only a malicious archive has it. A leading / is taken
+// off, as tar does.
+func leavesArchive(name string) bool {
+	for _, c := range strings.Split(name, "/") {
+		if c == ".." {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// openWait opens name with open. Opening a FIFO blocks until the
+// other end is opened too; if that takes longer than openTimeout (when
+// set), openWait gives up.
+func openWait(name string, open func(string) (*os.File, error)) (*os.File, error) {
+	if openTimeout <= 0 {
+		return open(name)
+	}
+
+	type result struct {
+		fd  *os.File
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		fd, err := open(name)
+		c <- result{fd, err}
+	}()
+
+	select {
+	case r := <-c:
+		return r.fd, r.err
+	case <-time.After(openTimeout):
+		// The goroutine stays blocked, but we are about to exit.
+		return nil, fmt.Errorf("%s: nothing opened the other end within %v",
+			name, openTimeout)
+	}
+}
+
+// isFIFO tells whether fd is a named or anonymous pipe.
+func isFIFO(fd *os.File) bool {
+	fi, err := fd.Stat()
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// isWriterGone tells whether err is a FIFO or pipe on the input side
+// being closed by its writer before the message was complete.
+func isWriterGone(in io.Reader, err error) bool {
+	fd, ok := in.(*os.File)
+	return ok && isFIFO(fd) && errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isReaderGone tells whether err means that whatever was reading our
+// output went away, e.g. `decrypt-symmetric | head`.
+func isReaderGone(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
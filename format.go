@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// Formats of encrypted files other than OpenPGP messages, which are
+// told by their magic, and most of which encrypt -format writes. Only
+// OpenPGP messages are decrypted by symcrypt, or the -backend, and
+// reported on.
+const (
+	formatOpenPGP   = "openpgp"
+	formatOpenSSL   = "openssl"
+	formatSecretbox = "secretbox"
+	formatScrypt    = "scrypt"
+	formatCMS       = "cms"
+)
+
+// The -format that encrypt writes
+var encryptFormat = formatOpenPGP
+
+// formatMagics are the magics that the other formats start with.
+var formatMagics = []struct {
+	format string
+	magic  []byte
+	desc   string
+}{
+	{formatOpenSSL, opensslMagic, "an openssl enc file"},
+	{formatSecretbox, secretboxMagic, "a secretbox file"},
+	{formatScrypt, scryptMagic, "a scrypt enc file"},
+}
+
+// How much of the input sniffFormat needs to tell its format
+const formatSniffLen = 32
+
+// inputFormat returns the format of the input in br, which is not
+// consumed.
+func inputFormat(br *bufio.Reader) (string, string) {
+	head, _ := br.Peek(formatSniffLen)
+	return sniffFormat(head)
+}
+
+// sniffFormat returns the format of an input that starts with head,
+// and a description of it.
+func sniffFormat(head []byte) (string, string) {
+	for _, f := range formatMagics {
+		if bytes.HasPrefix(head, f.magic) {
+			return f.format, f.desc
+		}
+	}
+
+	if isCMS(head) {
+		return formatCMS, "CMS enveloped data"
+	}
+
+	return formatOpenPGP, "an OpenPGP message"
+}
+
+// checkInputFormat refuses flags that only apply to OpenPGP messages
+// for an input in another format, described by desc.
+func checkInputFormat(format, desc string) {
+	if format == formatOpenPGP {
+		return
+	}
+	switch format {
+	case formatOpenSSL:
+		checkNoIntegrity(desc)
+		checkOpenSSLIter()
+	case formatCMS:
+		checkNoIntegrity(desc)
+	}
+
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-backend", backendName != defaultBackend},
+		{"-keyring", keyringFile != ""},
+		{"-unwrap-nested", unwrapDepth > 0},
+		{"-fips", fipsMode},
+		{"-allow-legacy-ciphers", legacyCipher},
+		{"-summary", showSummary},
+		{"-no-decompress", noDecompress},
+		{"-debug-packets", debugPackets},
+	} {
+		if f.set {
+			exitf(exitUsage, "The input is %s, which %s does not apply to", desc, f.name)
+		}
+	}
+}
+
+// checkNoIntegrity refuses to decrypt an input in a format with no
+// integrity protection, described by desc, without -allow-no-mdc.
+func checkNoIntegrity(desc string) {
+	if !allowNoMDC {
+		fatalf("The input is %s, which has no integrity protection: "+
+			"it cannot be told whether it has been tampered with; -allow-no-mdc decrypts it anyway.", desc)
+	}
+}
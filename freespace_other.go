@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !openbsd && !windows
+
+package main
+
+import "errors"
+
+// freeSpace cannot tell the free space here.
+func freeSpace(dir string) (int64, error) {
+	return 0, errors.New("not supported on this system")
+}
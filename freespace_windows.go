@@ -0,0 +1,18 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// freeSpace returns the bytes available to this user on the volume of
+// dir.
+func freeSpace(dir string) (int64, error) {
+	name, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var avail uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &avail, nil, nil); err != nil {
+		return 0, err
+	}
+
+	return int64(avail), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// wordlist has 1024 words, so each one picked at random adds 10 bits.
+//
+//go:embed wordlist.txt
+var wordlist string
+
+// Sizes of generated passphrases, of about 80 and 128 bits
+const (
+	genWords = 8
+	genBytes = 16
+)
+
+// generatePassphrase returns a random passphrase of the style named:
+// words from the word list, or random bytes encoded as base32.
+func generatePassphrase(style string) (string, error) {
+	switch style {
+	case "words":
+		words := strings.Fields(wordlist)
+		picked := make([]string, genWords)
+		var b [2]byte
+		for i := range picked {
+			if _, err := rand.Read(b[:]); err != nil {
+				return "", err
+			}
+			// len(words) is a power of two, so there is no bias.
+			picked[i] = words[int(binary.BigEndian.Uint16(b[:]))%len(words)]
+		}
+		return strings.Join(picked, "-"), nil
+
+	case "bytes":
+		b := make([]byte, genBytes)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+		return strings.ToLower(enc.EncodeToString(b)), nil
+	}
+
+	return "", fmt.Errorf("unknown passphrase style %q, want words or bytes", style)
+}
+
+// showOnTerminal writes the generated passphrase to the controlling
+// terminal, rather than to stdout or stderr, which may be logged.
+func showOnTerminal(pass string) error {
+	tty, err := os.OpenFile(ttyPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("no terminal to show it on: %w", err)
+	}
+	defer tty.Close()
+
+	_, err = fmt.Fprintf(tty, "Generated passphrase: %s\nIt is not shown again, so keep it somewhere safe.\n", pass)
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// gnupgCompat is what a GnuPG version reads of what encrypt can write,
+// for -gnupg-compat to keep to. What does not vary is left out: encrypt
+// always writes a version 4 SKESK with the iterated and salted S2K over
+// SHA-256, which GnuPG derives keys with for any count -s2k-count takes,
+// and a version 1 SEIPD packet with an MDC, in partial body lengths,
+// compressed with at most zip or zlib, all of which every GnuPG since
+// 1.4 reads.
+type gnupgCompat struct {
+	ciphers []symcrypt.Cipher
+	// Of the keys of -recipient and -sign-key
+	keyAlgos []packet.PublicKeyAlgorithm
+}
+
+var (
+	gnupgCiphers = []symcrypt.Cipher{symcrypt.CipherAES128, symcrypt.CipherAES192, symcrypt.CipherAES256,
+		symcrypt.CipherCAST5, symcrypt.Cipher3DES, symcrypt.CipherTwofish,
+		symcrypt.CipherCamellia128, symcrypt.CipherCamellia192, symcrypt.CipherCamellia256}
+	gnupgRSAKeys = []packet.PublicKeyAlgorithm{packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly,
+		packet.PubKeyAlgoRSASignOnly, packet.PubKeyAlgoElGamal, packet.PubKeyAlgoDSA}
+)
+
+// The GnuPG versions for -gnupg-compat, by the series they name. 2.3
+// and later warn of CAST5 and 3DES, but still decrypt them; it is only
+// encrypting with them that --allow-old-cipher-algos is for.
+var gnupgVersions = map[string]gnupgCompat{
+	// Camellia since 1.4.10; no elliptic curve keys
+	"1.4": {ciphers: gnupgCiphers, keyAlgos: gnupgRSAKeys},
+	"2.2": {ciphers: gnupgCiphers, keyAlgos: append(slices.Clip(gnupgRSAKeys), packet.PubKeyAlgoECDH, packet.PubKeyAlgoECDSA)},
+	"2.4": {ciphers: gnupgCiphers, keyAlgos: append(slices.Clip(gnupgRSAKeys), packet.PubKeyAlgoECDH, packet.PubKeyAlgoECDSA)},
+}
+
+// gnupgVersionNames returns the versions -gnupg-compat takes, in order.
+func gnupgVersionNames() []string {
+	var names []string
+	for name := range gnupgVersions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// gnupgCompatFor exits unless version is one -gnupg-compat takes.
+func gnupgCompatFor(version string) gnupgCompat {
+	compat, ok := gnupgVersions[version]
+	if !ok {
+		exitf(exitUsage, "Unknown -gnupg-compat %q: want %s", version, strings.Join(gnupgVersionNames(), ", "))
+	}
+
+	return compat
+}
+
+// checkCipher exits unless GnuPG version reads cipher ci.
+func (compat gnupgCompat) checkCipher(version string, ci symcrypt.Cipher) {
+	if !slices.Contains(compat.ciphers, ci) {
+		exitf(exitUsage, "-gnupg-compat %s: GnuPG %s does not read %v", version, version, ci)
+	}
+}
+
+// checkKeys exits unless GnuPG version reads the keys of entities, those
+// of the flag what, e.g. -recipient.
+func (compat gnupgCompat) checkKeys(version, what string, entities []*openpgp.Entity) {
+	for _, e := range entities {
+		keys := []*packet.PublicKey{e.PrimaryKey}
+		for _, sub := range e.Subkeys {
+			keys = append(keys, sub.PublicKey)
+		}
+		for _, k := range keys {
+			if !slices.Contains(compat.keyAlgos, k.PubKeyAlgo) {
+				exitf(exitUsage, "-gnupg-compat %s: GnuPG %s does not read the %s key %X, of public key algorithm %d",
+					version, version, what, k.Fingerprint, k.PubKeyAlgo)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// harden applies -harden and -mlock, before any secret is read.
+func harden() {
+	if hardenProcess {
+		if err := disableCoreDumps(); err != nil {
+			fatalf("Harden: %v", err)
+		}
+	}
+	if lockMemory {
+		if err := lockAllMemory(); err != nil {
+			fatalf("Harden: locking memory: %v", err)
+		}
+	}
+}
+
+// hardenedProvider copies each passphrase from p into memory that is
+// left out of core dumps, where the platform allows that.
+type hardenedProvider struct {
+	symcrypt.PassphraseProvider
+}
+
+func (hp hardenedProvider) Passphrase() ([]byte, error) {
+	pass, err := hp.PassphraseProvider.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := secretBytes(len(pass))
+	if err != nil {
+		log.Printf("Harden: %v", err)
+		return pass, nil
+	}
+	copy(secret, pass)
+	clear(pass)
+
+	return secret, nil
+}
+
+func (hp hardenedProvider) Forget() {
+	if f, ok := hp.PassphraseProvider.(symcrypt.Forgetter); ok {
+		f.Forget()
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// disableCoreDumps sets RLIMIT_CORE to 0 and makes the process
+// undumpable, which also keeps other users' ptrace out.
+func disableCoreDumps() error {
+	if err := unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{}); err != nil {
+		return err
+	}
+
+	return unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+}
+
+// lockAllMemory keeps the process, including what it allocates later,
+// out of swap.
+func lockAllMemory() error {
+	return unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE)
+}
+
+// secretBytes returns n bytes of memory of their own, outside the Go
+// heap, that are left out of core dumps. They are never freed; there
+// are only ever a few.
+func secretBytes(n int) ([]byte, error) {
+	if n == 0 {
+		return []byte{}, nil
+	}
+
+	b, err := unix.Mmap(-1, 0, n, unix.PROT_READ|unix.PROT_WRITE,
+		unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Madvise(b, unix.MADV_DONTDUMP); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+var errHardenUnsupported = errors.New("not supported on this platform")
+
+func disableCoreDumps() error {
+	return errHardenUnsupported
+}
+
+func lockAllMemory() error {
+	return errHardenUnsupported
+}
+
+func secretBytes(n int) ([]byte, error) {
+	return make([]byte, n), nil
+}
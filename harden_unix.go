@@ -0,0 +1,23 @@
+//go:build unix && !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// disableCoreDumps sets RLIMIT_CORE to 0.
+func disableCoreDumps() error {
+	return syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{})
+}
+
+func lockAllMemory() error {
+	return errors.New("not supported on this platform")
+}
+
+// secretBytes cannot exclude memory from core dumps here, but they are
+// disabled anyway with -harden.
+func secretBytes(n int) ([]byte, error) {
+	return make([]byte, n), nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	sources["http"] = openHTTP
+	sources["https"] = openHTTP
+	sinks["http"] = createHTTP
+	sinks["https"] = createHTTP
+}
+
+// openHTTP reads the input with a GET request. If the connection fails,
+// or the server fails with a status that is likely to be transient, it
+// is retried, up to -http-retries times in a row, resuming with a Range
+// request from where it broke off.
+func openHTTP(u *url.URL) (io.ReadCloser, error) {
+	r := &httpReader{u: u}
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// httpReader is the body of a GET request that it resumes when it fails.
+type httpReader struct {
+	u *url.URL
+	// The response being read, or nil once its connection has failed
+	// with err
+	body io.ReadCloser
+	err  error
+	// Bytes read, where a resumed request starts
+	off int64
+	// The validator that a resumed request must match for the server
+	// to send the rest of the same file, rather than of a new one
+	validator string
+	// Retries since bytes were last read
+	retries int
+}
+
+func (r *httpReader) Read(p []byte) (int, error) {
+	if r.body == nil {
+		if r.retries >= httpRetries {
+			return 0, r.err
+		}
+		r.wait(r.err)
+		if err := r.connect(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	if n > 0 {
+		r.retries = 0
+	}
+	if err != nil && err != io.EOF {
+		r.body.Close()
+		r.body, r.err = nil, err
+		if n > 0 {
+			err = nil
+		} else {
+			return r.Read(p)
+		}
+	}
+
+	return n, err
+}
+
+func (r *httpReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	return r.body.Close()
+}
+
+// wait logs the failure err, and waits before retrying, for longer each
+// time.
+func (r *httpReader) wait(err error) {
+	r.retries++
+	wait := time.Second << min(r.retries-1, 5)
+	log.Printf("Input: %v; retrying at byte %d in %v, %d of %d", err, r.off, wait, r.retries, httpRetries)
+	time.Sleep(wait)
+}
+
+// connect makes the request, retrying it while it fails transiently.
+func (r *httpReader) connect() error {
+	for {
+		err := r.get()
+		if err == nil || !errors.Is(err, errTransient) || r.retries >= httpRetries {
+			return err
+		}
+		r.wait(err)
+	}
+}
+
+// errTransient marks the failures of a request that are worth retrying.
+var errTransient = errors.New("transient failure")
+
+// get makes the request, for the rest of the input after the bytes
+// read.
+func (r *httpReader) get() error {
+	req, err := http.NewRequest(http.MethodGet, r.u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if tp := traceParent(); tp != "" {
+		req.Header.Set("traceparent", tp)
+	}
+	if r.off > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.off))
+		if r.validator != "" {
+			req.Header.Set("If-Range", r.validator)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errTransient, err)
+	}
+	switch {
+	case r.off == 0 && resp.StatusCode == http.StatusOK:
+		// A weak ETag cannot be used with If-Range
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			r.validator = etag
+		} else {
+			r.validator = resp.Header.Get("Last-Modified")
+		}
+	case r.off > 0 && resp.StatusCode == http.StatusPartialContent && rangeStart(resp) == r.off:
+	case r.off > 0 && resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return fmt.Errorf("%s: cannot resume at byte %d: the server sent the whole file, "+
+			"as it does not take Range requests or the file has changed", r.u.Redacted(), r.off)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		resp.Body.Close()
+		return fmt.Errorf("%w: %s: %s", errTransient, r.u.Redacted(), resp.Status)
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("%s: %s", r.u.Redacted(), resp.Status)
+	}
+	r.body = resp.Body
+
+	return nil
+}
+
+// rangeStart returns the first byte of the Content-Range of resp, or -1.
+func rangeStart(resp *http.Response) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d", &start, &end); err != nil {
+		return -1
+	}
+
+	return start
+}
+
+// httpSink streams the output as the body of a PUT request.
+type httpSink struct {
+	*io.PipeWriter
+	u    *url.URL
+	done chan error
+	once sync.Once
+}
+
+var errDiscarded = errors.New("output discarded")
+
+func createHTTP(u *url.URL) (sink, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPut, u.String(), pr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &httpSink{PipeWriter: pw, u: u, done: make(chan error, 1)}
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("%s: %s", u.Redacted(), resp.Status)
+			}
+		}
+		// Stop writes to a request that has already failed
+		pr.CloseWithError(err)
+		s.done <- err
+	}()
+	atExit(s.discard)
+
+	return s, nil
+}
+
+func (s *httpSink) commit() error {
+	err := fmt.Errorf("Output: already discarded")
+	s.once.Do(func() {
+		s.Close()
+		err = <-s.done
+	})
+
+	return err
+}
+
+// discard aborts the request, so the server does not get a complete
+// body.
+func (s *httpSink) discard() {
+	s.once.Do(func() {
+		s.CloseWithError(errDiscarded)
+		<-s.done
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+
+	"golang.org/x/sys/cpu"
+)
+
+// hardwareAES returns the instructions that accelerate AES on this CPU,
+// which Go's crypto/aes uses unless it is built with the purego tag,
+// or "" if there are none.
+func hardwareAES() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		if cpu.X86.HasAES {
+			return "AES-NI"
+		}
+	case "arm64":
+		if cpu.ARM64.HasAES {
+			return "ARMv8 Crypto Extensions"
+		}
+	case "s390x":
+		if cpu.S390X.HasAES {
+			return "CPACF"
+		}
+	case "ppc64", "ppc64le":
+		// POWER8 and later, which Go requires
+		return "POWER8 vector crypto"
+	}
+
+	return ""
+}
+
+// reportHardware tells on w whether AES, the cipher of nearly all
+// messages, runs on instructions for it, since without them decryption
+// is several times slower, through no fault of this program. CAST5 and
+// 3DES always run in software.
+func reportHardware(w io.Writer) {
+	hw := hardwareAES()
+	switch {
+	case hw == "":
+		fmt.Fprintf(w, "Hardware AES: none on this %s CPU; AES runs in software, several times slower\n",
+			runtime.GOARCH)
+	case pureGo:
+		fmt.Fprintf(w, "Hardware AES: %s, but unused: this build has the purego tag\n", hw)
+	default:
+		fmt.Fprintf(w, "Hardware AES: %s, in use\n", hw)
+	}
+}
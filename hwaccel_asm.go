@@ -0,0 +1,6 @@
+//go:build !purego
+
+package main
+
+// Whether Go's crypto packages were built without their assembly
+const pureGo = false
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+func init() {
+	subcommands["inspect"] = inspect
+}
+
+// inspect prints the packet structure of the message in the file named
+// in args, or -filename, or on stdin, without decrypting it.
+func inspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric [flags] inspect [FILE]")
+	}
+	configureSubcommand(fs, "inspect", args)
+	name := filename
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		name = fs.Arg(0)
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+
+	if name == "" {
+		name = "-"
+	}
+	fd, err := openSource(name)
+	if err != nil {
+		fatalf("Input: %v", err)
+	}
+	defer fd.Close()
+
+	info, err := symcrypt.Inspect(fd)
+	if info != nil {
+		printMessageInfo(os.Stdout, info)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	return 0
+}
+
+func printMessageInfo(w io.Writer, info *symcrypt.MessageInfo) {
+	if info.Armored {
+		fmt.Fprintln(w, "ASCII armored")
+		var keys []string
+		for k := range info.ArmorHeaders {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "\t%s: %s\n", k, info.ArmorHeaders[k])
+		}
+	}
+
+	skesk, pkesk := 0, 0
+	for _, p := range info.Packets {
+		length := fmt.Sprintf("%d bytes", p.Length)
+		if p.Partial {
+			length += ", partial"
+		}
+		fmt.Fprintf(w, "off=%d tag=%d %s (%s)\n", p.Offset, p.Tag, p.Name, length)
+
+		switch p.Tag {
+		case symcrypt.TagSKESK:
+			s := info.SKESKs[skesk]
+			skesk++
+			fmt.Fprintf(w, "\tversion %d, cipher %v, s2k %v", s.Version, s.Cipher, s.S2K)
+			if s.EncryptedKey {
+				fmt.Fprint(w, ", encrypted session key")
+			}
+			fmt.Fprintln(w)
+		case symcrypt.TagPKESK:
+			fmt.Fprintf(w, "\tkey ID %016X\n", info.PublicKeyIDs[pkesk])
+			pkesk++
+		case symcrypt.TagSEIPD, symcrypt.TagAEAD:
+			fmt.Fprintf(w, "\tversion %d\n", p.Version)
+		}
+	}
+
+	if info.Integrity {
+		fmt.Fprintln(w, "Integrity protected")
+	} else {
+		fmt.Fprintln(w, "NOT integrity protected")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openJournal() (io.Writer, error) {
+	return nil, errors.New("journald is only on Linux")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// GnuPG's keybox format, pubring.kbx since GnuPG 2.1, is a sequence of
+// blobs, each with a 4-byte big-endian length and its type. The first
+// is a header blob with the magic "KBXf". OpenPGP blobs hold the
+// keyblock, the key as a transferable public key, at an offset from
+// the start of the blob, followed by an index of its keys and user IDs
+// that is not needed here.
+const (
+	kbxTypeHeader  = 1
+	kbxTypeOpenPGP = 2
+
+	// Larger than any key gpg writes
+	maxKeyboxBlob = 16 << 20
+)
+
+var kbxMagic = []byte("KBXf")
+
+// isKeybox tells whether br, which is not consumed, starts with a
+// keybox header blob.
+func isKeybox(br *bufio.Reader) bool {
+	head, _ := br.Peek(12)
+	return len(head) == 12 && head[4] == kbxTypeHeader && bytes.Equal(head[8:12], kbxMagic)
+}
+
+// keyboxKeys returns the keyblocks of the OpenPGP blobs in the keybox
+// read from r, one after the other, as a binary key ring.
+func keyboxKeys(r io.Reader) (io.Reader, error) {
+	var keys bytes.Buffer
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err == io.EOF {
+			return &keys, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("keybox: %w", err)
+		}
+
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n < 6 || n > maxKeyboxBlob {
+			return nil, fmt.Errorf("keybox: bad blob length %d", n)
+		}
+		blob := make([]byte, n)
+		copy(blob, hdr[:])
+		if _, err := io.ReadFull(r, blob[4:]); err != nil {
+			return nil, fmt.Errorf("keybox: %w", io.ErrUnexpectedEOF)
+		}
+		// X.509 certificates, and blobs removed by gpg, are skipped
+		if blob[4] != kbxTypeOpenPGP {
+			continue
+		}
+
+		if n < 16 {
+			return nil, errors.New("keybox: truncated OpenPGP blob")
+		}
+		off := binary.BigEndian.Uint32(blob[8:12])
+		length := binary.BigEndian.Uint32(blob[12:16])
+		if uint64(off)+uint64(length) > uint64(n) {
+			return nil, fmt.Errorf("keybox: keyblock at %d of %d bytes is outside its blob of %d",
+				off, length, n)
+		}
+		keys.Write(blob[off : off+length])
+	}
+}
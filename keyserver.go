@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// How long a key lookup may take
+const keyLookupTimeout = 30 * time.Second
+
+// Largest key accepted from a lookup
+const maxFetchedKey = 1 << 20
+
+var keyClient = &http.Client{Timeout: keyLookupTimeout}
+
+// signerSource is where the key of the last signer looked up came
+// from, for the report.
+var signerSource string
+
+// lookupSigner is the symcrypt signer lookup for -wkd and the
+// -keyserver: it fetches the key of a signer that is not in the
+// -keyring, with WKD by the user ID that the signature names, if any,
+// and otherwise from the keyserver by its key ID.
+func lookupSigner(keyID uint64, userID string) []openpgp.Key {
+	if useWKD && userID != "" {
+		keys, source, err := lookupWKD(keyID, userID)
+		if err == nil {
+			signerSource = source
+			return keys
+		}
+		log.Printf("WKD: %s: %v", userID, err)
+	}
+	if keyserver == "" {
+		return nil
+	}
+
+	u, err := hkpURL(keyserver, keyID)
+	if err == nil {
+		var kr openpgp.EntityList
+		if kr, err = fetchKeys(u, true); err == nil {
+			signerSource = u.Host
+			return kr.KeysByIdUsage(keyID, packet.KeyFlagSign)
+		}
+	}
+	log.Printf("Keyserver: key %016X: %v", keyID, err)
+
+	return nil
+}
+
+// hkpURL returns the HKP lookup, draft-shaw-openpgp-hkp section 3, for
+// the key keyID on the keyserver, an hkps:// or hkp:// URL.
+func hkpURL(keyserver string, keyID uint64) (*url.URL, error) {
+	u, err := url.Parse(keyserver)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "hkps":
+		u.Scheme = "https"
+	case "hkp":
+		u.Scheme = "http"
+		if u.Port() == "" {
+			u.Host += ":11371"
+		}
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("%s: not an hkps:// or hkp:// URL", keyserver)
+	}
+
+	u.Path = "/pks/lookup"
+	u.RawQuery = url.Values{
+		"op":      {"get"},
+		"options": {"mr"},
+		"search":  {fmt.Sprintf("0x%016X", keyID)},
+	}.Encode()
+
+	return u, nil
+}
+
+// fetchKeys reads the keys at u, armored or binary.
+func fetchKeys(u *url.URL, armored bool) (openpgp.EntityList, error) {
+	resp, err := keyClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u.Redacted(), resp.Status)
+	}
+
+	body := io.LimitReader(resp.Body, maxFetchedKey)
+	if armored {
+		return openpgp.ReadArmoredKeyRing(body)
+	}
+	return openpgp.ReadKeyRing(body)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/tar"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	subcommands["list"] = list
+}
+
+// list prints the members of the tar archive, possibly gzipped, that
+// the message in the file named in args, or -filename, or on stdin,
+// decrypts to, as tar tv does, without writing out any of their data.
+func list(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric [flags] list [list flags] [FILE]")
+		fs.PrintDefaults()
+	}
+	var filter memberFilter
+	filter.flags(fs)
+	configureSubcommand(fs, "list", args)
+
+	name := filename
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		name = fs.Arg(0)
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	filter.check()
+
+	if name == "" {
+		name = "-"
+	}
+	fd, err := openSource(name)
+	if err != nil {
+		fatalf("Input: %v", err)
+	}
+	defer fd.Close()
+
+	res, err := decryptTar(fd, func(hdr *tar.Header, _ io.Reader) error {
+		if !filter.match(hdr.Name) {
+			return nil
+		}
+		_, err := fmt.Fprintln(os.Stdout, memberLine(hdr))
+		return err
+	})
+	if err != nil {
+		fatalf("List: %v", err)
+	}
+	reportMessage(&res)
+
+	return 0
+}
+
+// memberLine describes a member as tar tv does.
+func memberLine(hdr *tar.Header) string {
+	owner := hdr.Uname
+	if owner == "" {
+		owner = strconv.Itoa(hdr.Uid)
+	}
+	group := hdr.Gname
+	if group == "" {
+		group = strconv.Itoa(hdr.Gid)
+	}
+	line := fmt.Sprintf("%s %s/%s %10d %s %s", tarMode(hdr), owner, group, hdr.Size,
+		hdr.ModTime.Format("2006-01-02 15:04"), quoteName(hdr.Name))
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		line += " -> " + quoteName(hdr.Linkname)
+	case tar.TypeLink:
+		line += " link to " + quoteName(hdr.Linkname)
+	}
+
+	return line
+}
+
+// tarMode formats the type and mode of a member as tar tv does, e.g.
+// lrwxrwxrwx or -rwsr-xr-x.
+func tarMode(hdr *tar.Header) string {
+	typ := byte('-')
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		typ = 'd'
+	case tar.TypeSymlink:
+		typ = 'l'
+	case tar.TypeLink:
+		typ = 'h'
+	case tar.TypeChar:
+		typ = 'c'
+	case tar.TypeBlock:
+		typ = 'b'
+	case tar.TypeFifo:
+		typ = 'p'
+	}
+
+	m := []byte(fs.FileMode(hdr.Mode).Perm().String())
+	m[0] = typ
+	for _, s := range []struct {
+		bit  int64
+		pos  int
+		x, n byte
+	}{
+		{04000, 3, 's', 'S'},
+		{02000, 6, 's', 'S'},
+		{01000, 9, 't', 'T'},
+	} {
+		if hdr.Mode&s.bit == 0 {
+			continue
+		}
+		if m[s.pos] == 'x' {
+			m[s.pos] = s.x
+		} else {
+			m[s.pos] = s.n
+		}
+	}
+
+	return string(m)
+}
+
+// quoteName quotes a member name that has characters in it that are
+// not printable, such as a newline that would fake another line.
+func quoteName(name string) string {
+	if strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return strconv.Quote(name)
+	}
+
+	return name
+}
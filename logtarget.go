@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Log targets, for -log-target
+const (
+	logStderr   = "stderr"
+	logSyslog   = "syslog"
+	logJournald = "journald"
+)
+
+// Priorities of log lines in the system log, as in syslog(3)
+const (
+	prioErr     = 3
+	prioWarning = 4
+	prioInfo    = 6
+)
+
+// Set while exitf logs the error it exits with
+var loggingError atomic.Bool
+
+// linePriority returns the priority to log the line msg with: that of
+// an error for the one the program fails with, of a warning for
+// warnings, and informational otherwise.
+func linePriority(msg string) int {
+	switch {
+	case loggingError.Load():
+		return prioErr
+	case strings.HasPrefix(msg, "Warning"):
+		return prioWarning
+	}
+
+	return prioInfo
+}
+
+// openLogTarget sends the log to the system log with -log-target
+// syslog or journald, which record the time and the process
+// themselves. -log-file is the alternative for a file.
+func openLogTarget() {
+	switch logTarget {
+	case logStderr:
+		return
+	case logSyslog, logJournald:
+	default:
+		exitf(exitUsage, "Bad -log-target %q: want %s, %s or %s", logTarget, logStderr, logSyslog, logJournald)
+	}
+	if logFile != "" {
+		exitf(exitUsage, "-log-file cannot be combined with -log-target %s", logTarget)
+	}
+
+	open := openSyslogTarget
+	if logTarget == logJournald {
+		open = openJournal
+	}
+	w, err := open()
+	if err != nil {
+		fatalf("Log target %s: %v", logTarget, err)
+	}
+	log.SetOutput(w)
+	log.SetPrefix("")
+	log.SetFlags(0)
+	colorStderr = false
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslogTarget() (io.Writer, error) {
+	return nil, errors.New("there is no syslog on this platform")
+}
@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"strings"
+)
+
+// syslogTarget logs each line to syslog with its linePriority.
+type syslogTarget struct {
+	w *syslog.Writer
+}
+
+func openSyslogTarget() (io.Writer, error) {
+	w, err := syslog.New(syslog.LOG_USER|syslog.LOG_INFO, "decrypt-symmetric")
+	if err != nil {
+		return nil, err
+	}
+
+	return syslogTarget{w}, nil
+}
+
+func (st syslogTarget) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	var err error
+	switch linePriority(msg) {
+	case prioErr:
+		err = st.w.Err(msg)
+	case prioWarning:
+		err = st.w.Warning(msg)
+	default:
+		err = st.w.Info(msg)
+	}
+
+	return len(p), err
+}
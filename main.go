@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,118 +12,1096 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"runtime"
+	"path/filepath"
 	"runtime/pprof"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/marete/decrypt-symmetric/symcrypt"
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/term"
 )
 
-// An empty Keyring
-type emptyKR struct {
+var (
+	passphrase    string
+	filename      string
+	outputs       stringList
+	armorParts    stringList
+	cpuprofile    string
+	showVersion   bool
+	configFile    string
+	timeout       time.Duration
+	debugStacks   bool
+	debugPackets  bool
+	openTimeout   time.Duration
+	verifyFirst   bool
+	maxMemory     = byteSize(64 << 20)
+	bwLimit       byteSize
+	readAhead     = byteSize(1 << 20)
+	showStats     bool
+	progressEvery time.Duration
+	showSummary   bool
+	ignoreCRC     bool
+	noDecompress  bool
+	autoDecomp    bool
+	autoName      bool
+	outDir        string
+	outTemplate   string
+	ifExists      string
+	compareFile   string
+	logFile       string
+	logFileMode   = fileMode(0o600)
+	logTruncate   bool
+	logTarget     string
+	allowNoMDC    bool
+	legacyCipher  bool
+	noQuarantine  bool
+	messagesMode  string
+	passFD        int
+	passFile      string
+	passEnv       string
+	passCred      string
+	passSecret    string
+	keyPassFD     int
+	keyPassFile   string
+	keyPassEnv    string
+	passTPM2      string
+	yubikeySlot   int
+	useAgent      bool
+	batchMode     bool
+	jsonErrors    bool
+	passPlugin    string
+	keyringFile   string
+	agentKeys     bool
+	keyserver     string
+	otlpEndpoint  string
+	useWKD        bool
+	hardenProcess bool
+	lockMemory    bool
+	sandbox       bool
+	noConfine     bool
+	runAs         string
+	fipsMode      bool
+	auditFile     string
+	manifestFile  string
+	tempSuffix    string
+	filesFrom     string
+	nulSeparated  bool
+	deleteAfter   bool
+	inPlace       bool
+	verbose       bool
+	unwrapDepth   int
+	maxNesting    int
+	httpRetries   int
+	backendName   string
+	opensslIter   int
+)
+
+// Subcommands, selected by the first non-flag argument. Each one
+// registers itself from an init function in its own file.
+var subcommands = make(map[string]func(args []string) int)
+
+func init() {
+	flag.StringVar(&filename, "filename", "",
+		"Read the input from this `file`. (Default is stdin if no filename is supplied)")
+	flag.BoolVar(&autoName, "auto-output", false,
+		"Without -output, name the output after -filename: without .gpg, .pgp or .asc when decrypting, with it added when encrypting")
+	flag.StringVar(&outDir, "outdir", "",
+		"Write the outputs, named as -auto-output names them, under this `directory`, created if need be")
+	flag.StringVar(&outTemplate, "output-template", "",
+		"Name each -files-from output with this Go template, e.g. '{{.Stem}}.{{.Date}}.txt', of the input's and the message's file name and date")
+	flag.StringVar(&ifExists, "if-exists", existsFail,
+		"What to do when a -files-from output exists: "+strings.Join(existsPolicies, ", "))
+	flag.StringVar(&compareFile, "compare", "",
+		"Compare the plain text with this `file`, reporting where they first differ, instead of writing it")
+	flag.Var(&armorParts, "part",
+		"A `file` holding a part of a multi-part armored message, in any order. Repeat for each part, or give -filename a directory of them")
+	flag.StringVar(&filesFrom, "files-from", "",
+		"Decrypt each file named in this `file`, or - for stdin, one per line, beside it or into the -output directory")
+	flag.BoolVar(&nulSeparated, "0", false,
+		"The -files-from names are separated by NUL bytes, as find -print0 writes them")
+	flag.Var(&outputs, "output",
+		"Write the plain text to this `file`, or - for stdout. May be repeated to write several copies. (Default is stdout)")
+	flag.StringVar(&tempSuffix, "temp-suffix", "",
+		"Write each output file as its name with this suffix, e.g. .part, renamed to its name once it is complete")
+	flag.StringVar(&passphrase, "passphrase", "",
+		"Passphrase. (Prompted for on the terminal if not supplied)")
+	flag.IntVar(&passFD, "passphrase-fd", -1,
+		"Read the passphrase from the first line of this file descriptor")
+	flag.StringVar(&passFile, "passphrase-file", "",
+		"Read the passphrase from the first line of this `file`")
+	flag.IntVar(&keyPassFD, "key-passphrase-fd", -1,
+		"Unlock passphrase protected secret keys, of the -keyring or -sign-key, with the first line of this file descriptor")
+	flag.StringVar(&keyPassFile, "key-passphrase-file", "",
+		"Unlock passphrase protected secret keys, of the -keyring or -sign-key, with the first line of this `file`")
+	flag.StringVar(&keyPassEnv, "key-passphrase-env", "",
+		"Unlock passphrase protected secret keys, of the -keyring or -sign-key, with this environment variable")
+	flag.StringVar(&passEnv, "passphrase-env", "",
+		"Take the passphrase from this environment variable")
+	flag.StringVar(&passCred, "passphrase-credential", "",
+		"Read the passphrase from this systemd credential, in $CREDENTIALS_DIRECTORY")
+	flag.StringVar(&passSecret, "passphrase-secret", "",
+		"Read the passphrase from this container secret, in /run/secrets")
+	flag.StringVar(&passTPM2, "passphrase-tpm2", "",
+		"Unseal the passphrase from this credential `file`, sealed to the TPM with systemd-creds encrypt --with-key=tpm2")
+	flag.IntVar(&yubikeySlot, "passphrase-yubikey", 0,
+		"Use the HMAC-SHA1 response of this YubiKey slot, 1 or 2, to the passphrase as the passphrase, for a hardware factor")
+	flag.BoolVar(&useAgent, "use-agent", false,
+		"Ask gpg-agent for the passphrase, which it may have cached")
+	flag.BoolVar(&batchMode, "batch", false,
+		"Never prompt, at the terminal or in gpg-agent's pinentry, failing instead, e.g. under cron or in CI")
+	flag.BoolVar(&jsonErrors, "json", false,
+		"Report failures on stderr as JSON objects, with the exit code, a category, the message, and the input and offset where known")
+	flag.StringVar(&passPlugin, "passphrase-plugin", "",
+		"Get the passphrase from the decsym-passphrase-NAME plugin on PATH")
+	flag.BoolVar(&hardenProcess, "harden", false,
+		"Disable core dumps, and keep the passphrase out of them, so secrets do not end up on disk")
+	flag.BoolVar(&lockMemory, "mlock", false,
+		"Lock all memory so that secrets are never swapped out (needs RLIMIT_MEMLOCK or privilege)")
+	flag.BoolVar(&sandbox, "sandbox", false,
+		"Once the input and outputs are open, restrict the process to I/O on them with seccomp (Linux)")
+	flag.StringVar(&runAs, "run-as", "",
+		"When started as root, switch to this user[:group] once the files are open")
+	flag.BoolVar(&noConfine, "no-confine", false,
+		"Do not confine the process to the input and output paths with Landlock (Linux) or unveil (OpenBSD)")
+	flag.BoolVar(&fipsMode, "fips", false,
+		"Only accept FIPS 140-3 approved algorithms: AES and SHA-2. (Implied when Go runs in FIPS mode)")
+	flag.StringVar(&auditFile, "audit-log", "",
+		"Append a line recording each operation and its outcome to this `file`, or syslog")
+	flag.StringVar(&manifestFile, "manifest", "",
+		"Write a manifest of each file processed, with its output, plain text SHA-256, size and status, to this `file`: CSV if it ends in .csv, JSON otherwise")
+	flag.BoolVar(&inPlace, "in-place", false,
+		"Replace the -filename FILE.gpg with its plain text FILE, removing it only once that is complete and verified")
+	flag.BoolVar(&deleteAfter, "delete-after", false,
+		"Remove the -filename input once it has been decrypted and its integrity verified")
+	flag.StringVar(&keyringFile, "keyring", "",
+		"Also decrypt with the secret keys, and verify signatures with the public keys, in this OpenPGP key ring `file`")
+	flag.BoolVar(&agentKeys, "agent-keys", false,
+		"Also decrypt with the secret keys of the -keyring's public keys that gpg-agent holds, e.g. on an OpenPGP card")
+	flag.StringVar(&keyserver, "keyserver", "",
+		"Fetch the key of a signer that is not in the -keyring from this keyserver, e.g. hkps://keys.openpgp.org")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"Export a trace of the decryption and its phases to this OTLP/HTTP endpoint, e.g. http://localhost:4318/v1/traces; "+
+			"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT and OTEL_EXPORTER_OTLP_ENDPOINT give it too")
+	flag.BoolVar(&useWKD, "wkd", false,
+		"Fetch the key of a signer that is not in the -keyring from the Web Key Directory of the user ID the signature names")
+	flag.StringVar(&logFile, "log-file", "",
+		"Write the log to this `file`, appending to it, instead of to stderr")
+	flag.Var(&logFileMode, "log-file-mode",
+		"Permissions to create the -log-file with")
+	flag.BoolVar(&logTruncate, "log-truncate", false,
+		"Empty the -log-file first rather than append to it")
+	flag.StringVar(&logTarget, "log-target", logStderr,
+		"Where the log goes: stderr, or the system log, syslog or journald, with priorities")
+	flag.StringVar(&cpuprofile, "cpuprofile", "",
+		"Recoird CPU profile in this `file`")
+	flag.BoolVar(&showVersion, "version", false,
+		"Print version and build information, then exit")
+	flag.StringVar(&configFile, "config", defaultConfigPath(),
+		"Read flag defaults from this TOML `file`")
+	flag.DurationVar(&timeout, "timeout", 0,
+		"Abort if decryption has not finished after this long, e.g. 30m")
+	flag.BoolVar(&debugStacks, "debug", false,
+		"Print the stacks of all goroutines when interrupted; SIGQUIT or SIGUSR2 prints them without interrupting")
+	flag.BoolVar(&debugPackets, "debug-packets", false,
+		"Dump each packet header to stderr as it is parsed, with its offset and an annotated hexdump")
+	flag.DurationVar(&openTimeout, "open-timeout", 0,
+		"Give up if opening a FIFO input or output blocks for longer than this")
+	flag.BoolVar(&verifyFirst, "verify-before-output", false,
+		"Hold back all plain text until its integrity has been verified")
+	flag.Var(&maxMemory, "max-memory",
+		"Memory budget for buffers; held back plain text beyond it is spooled to a temporary file")
+	flag.Var(&bwLimit, "bwlimit",
+		"Limit reading the cipher text to this many bytes per second, e.g. 50M")
+	flag.Var(&readAhead, "read-ahead",
+		"Read the next this many bytes of cipher text while the last are decrypted, or 0 not to read ahead")
+	flag.BoolVar(&verbose, "v", false,
+		"Log how the message was decrypted")
+	flag.IntVar(&unwrapDepth, "unwrap-nested", 0,
+		"If the plain text is another encrypted message, decrypt it too, up to this many layers deep")
+	flag.IntVar(&httpRetries, "http-retries", 5,
+		"Retry an http or https input that fails this many times in a row, resuming it where it broke off")
+	flag.IntVar(&maxNesting, "max-nesting", 8,
+		"Refuse messages with compressed data nested more than this many layers deep, and -unwrap-nested beyond it")
+	flag.BoolVar(&showStats, "stats", false,
+		"Log bytes in and out, timings and throughput at the end")
+	flag.DurationVar(&progressEvery, "progress-interval", 0,
+		"When the log is not at a terminal, log how far decryption has got every this long, e.g. 1m")
+	flag.BoolVar(&showSummary, "summary", false,
+		"Log a summary of the message at the end, as gpg -d does: cipher, integrity, signature and file name")
+	flag.BoolVar(&ignoreCRC, "ignore-armor-crc", false,
+		"Go on if the armor checksum does not match, e.g. armor mangled in transit, relying on the MDC instead")
+	flag.StringVar(&backendName, "backend", defaultBackend,
+		"Decrypt with this OpenPGP implementation, to compare it with symcrypt: "+backendNames())
+	flag.BoolVar(&noDecompress, "no-decompress", false,
+		"Write compressed data as it is, without decompressing it, e.g. for forensics")
+	flag.BoolVar(&autoDecomp, "auto-decompress", false,
+		"Decompress plain text that is itself gzip, zstd, xz or bzip2 compressed, e.g. from pg_dump | gzip | gpg -c")
+	flag.BoolVar(&allowNoMDC, "allow-no-mdc", false,
+		"Decrypt messages that have no integrity protection (MDC), as made by old or misconfigured software")
+	flag.BoolVar(&legacyCipher, "allow-legacy-ciphers", false,
+		"Decrypt messages encrypted with IDEA or Blowfish, as PGP 2 and early GnuPG made them")
+	flag.StringVar(&messagesMode, "messages", messagesFirst,
+		"With several messages back to back in the input, decrypt the first only, or split them into the -output with .1, .2, ... added, or join them with a ==> message N <== line before each")
+	flag.BoolVar(&noQuarantine, "no-unverified-suffix", false,
+		"With -allow-no-mdc, keep plain text that was not integrity protected under its own name, not with "+unverifiedSuffix+" added")
+	flag.IntVar(&opensslIter, "openssl-iter", defaultOpenSSLIter,
+		"PBKDF2 iterations of openssl enc files, as its -iter gave")
 }
 
-func (kr emptyKR) KeysById(id uint64) []openpgp.Key {
-	return nil
+// passphraseProvider returns the source of the passphrase selected by
+// the flags, to op (decrypt or encrypt) the input with, and how many
+// times it may be asked again if it is wrong.
+func passphraseProvider(passphrase, op string) (symcrypt.PassphraseProvider, int) {
+	if yubikeySlot != 0 && yubikeySlot != 1 && yubikeySlot != 2 {
+		exitf(exitUsage, "Bad -passphrase-yubikey %d: a YubiKey has slots 1 and 2", yubikeySlot)
+	}
+
+	switch {
+	case passphrase != "":
+		return symcrypt.StaticPassphrase([]byte(passphrase)), 0
+	case passFD >= 0:
+		return symcrypt.FDPassphrase(uintptr(passFD)), 0
+	case passFile != "":
+		return symcrypt.FilePassphrase(passFile), 0
+	case passEnv != "":
+		return symcrypt.EnvPassphrase(passEnv), 0
+	case passCred != "":
+		name, err := credentialPath(passCred)
+		if err != nil {
+			exitf(exitUsage, "%v", err)
+		}
+		return symcrypt.FilePassphrase(name), 0
+	case passSecret != "":
+		name, err := secretPath(passSecret)
+		if err != nil {
+			exitf(exitUsage, "%v", err)
+		}
+		return symcrypt.FilePassphrase(name), 0
+	case passTPM2 != "":
+		return sealedPassphrase(passTPM2), 0
+	case passPlugin != "":
+		return symcrypt.PluginPassphrase(passPlugin, passphraseID(),
+			"Passphrase to "+op+" "+inputName()), 0
+	case useAgent && batchMode && op == "encrypt":
+		return promptPassphrase(""), 0
+	case useAgent && batchMode:
+		// Only what the agent has cached; a wrong one is not asked for
+		// again
+		return symcrypt.AgentCachedPassphrase(passphraseID()), 0
+	case useAgent && op == "encrypt":
+		return symcrypt.AgentNewPassphrase("Enter a passphrase to encrypt " + inputName()), 0
+	case useAgent:
+		return symcrypt.AgentPassphrase(passphraseID(),
+			"Enter the passphrase to "+op+" "+inputName()), 2
+	case batchMode:
+		return promptPassphrase(""), 0
+	case op == "encrypt":
+		return symcrypt.PromptNewPassphrase("Passphrase: ", "Repeat passphrase: "), 2
+	}
+
+	return symcrypt.PromptPassphrase("Passphrase: "), 2
 }
 
-func (kr emptyKR) DecryptionKeys() []openpgp.Key {
-	return nil
+// keyPassphraseProvider returns the source of the passphrase that
+// unlocks secret keys, if one was given apart from the message's.
+func keyPassphraseProvider() (symcrypt.PassphraseProvider, bool) {
+	var p symcrypt.PassphraseProvider
+	n := 0
+	if keyPassFD >= 0 {
+		if keyPassFD == passFD {
+			exitf(exitUsage, "-key-passphrase-fd cannot be the -passphrase-fd")
+		}
+		p = symcrypt.FDPassphrase(uintptr(keyPassFD))
+		n++
+	}
+	if keyPassFile != "" {
+		p = symcrypt.FilePassphrase(keyPassFile)
+		n++
+	}
+	if keyPassEnv != "" {
+		p = symcrypt.EnvPassphrase(keyPassEnv)
+		n++
+	}
+	switch {
+	case n == 0:
+		return nil, false
+	case n > 1:
+		exitf(exitUsage, "Only one of -key-passphrase-fd, -key-passphrase-file and -key-passphrase-env can be given")
+	}
+	if hardenProcess {
+		p = hardenedProvider{p}
+	}
+
+	// Read once, for all the keys that need it
+	return symcrypt.Cache(p), true
 }
 
-func (kr emptyKR) KeysByIdUsage(uint64, byte) []openpgp.Key {
-	return nil
+// errBatchPrompt is why a passphrase that would have to be typed in
+// cannot be had with -batch.
+var errBatchPrompt = errors.New("-batch: a passphrase is needed, but would have to be typed in; " +
+	"give it with -passphrase-file, -passphrase-fd, -passphrase-env or another source")
+
+// promptPassphrase prompts for a passphrase at the terminal, unless
+// -batch forbids it, when asking for it fails at once.
+func promptPassphrase(prompt string) symcrypt.PassphraseProvider {
+	if batchMode {
+		return symcrypt.PassphraseFunc(func() ([]byte, error) {
+			return nil, errBatchPrompt
+		})
+	}
+
+	return symcrypt.PromptPassphrase(prompt)
 }
 
-var (
-	passphrase string
-	filename   string
-	cpuprofile string
-)
+// interactivePassphrase tells whether the passphrase is typed in by the
+// user, at the terminal or in gpg-agent's pinentry.
+func interactivePassphrase() bool {
+	return passphrase == "" && passFD < 0 && passFile == "" && passEnv == "" &&
+		passCred == "" && passSecret == "" && passTPM2 == "" && passPlugin == ""
+}
 
-func init() {
-	flag.StringVar(&filename, "filename", "",
-		"Filename. (Default is stdin if no filename is supplied)")
-	flag.StringVar(&passphrase, "passphrase", "", "Passphrase")
-	flag.StringVar(&cpuprofile, "cpuprofile", "",
-		"Recoird CPU profile in this file")
+// inputName names the input in prompts.
+func inputName() string {
+	if filename == "" {
+		return "stdin"
+	}
+
+	return filename
+}
+
+// passphraseID identifies the passphrase of the input to caching
+// passphrase sources. gpg-agent limits it to 50 bytes.
+func passphraseID() string {
+	sum := sha256.Sum256([]byte(inputName()))
+	return fmt.Sprintf("decsym:%x", sum[:16])
 }
 
-func newPromptFunction() func([]openpgp.Key, bool) ([]byte, error) {
-	first := true
+// newDecryptor returns a Decryptor for the command line flags, which
+// uses passphrase or, if that is empty, the other passphrase flags.
+func newDecryptor(passphrase string) *symcrypt.Decryptor {
+	policy := symcrypt.IntegrityRequired
+	if allowNoMDC {
+		policy = symcrypt.IntegrityOptional
+	}
 
-	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
-		if !symmetric {
-			// We only support passhphrases for symmetrically
-			// encrypted decryption keys
-			return nil, errors.New("Decrypting private keys not supported")
+	provider, retries := passphraseProvider(passphrase, "decrypt")
+	if yubikeySlot != 0 {
+		provider = yubikeyProvider{provider, yubikeySlot}
+	}
+	if hardenProcess {
+		provider = hardenedProvider{provider}
+	}
+	provider = symcrypt.Cache(provider)
+	if sandbox || runAs != "" {
+		// Sources such as gpg-agent and plugins are out of reach in
+		// the sandbox, and a passphrase file may only be readable by
+		// root, so the passphrase is fetched beforehand.
+		if _, err := provider.Passphrase(); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	opts := []symcrypt.Option{
+		symcrypt.WithPassphraseProvider(provider),
+		symcrypt.WithPassphraseRetries(retries),
+		symcrypt.WithIntegrityPolicy(policy),
+		symcrypt.WithPhaseFunc(labelPhase),
+		symcrypt.WithMaxNesting(maxNesting),
+	}
+	if fipsMode {
+		opts = append(opts, symcrypt.WithFIPSMode())
+	}
+	if ignoreCRC {
+		opts = append(opts, symcrypt.WithIgnoreArmorChecksum())
+	}
+	if noDecompress {
+		opts = append(opts, symcrypt.WithNoDecompress())
+	}
+	if legacyCipher {
+		opts = append(opts, symcrypt.WithLegacyCiphers())
+	}
+	if debugPackets {
+		opts = append(opts, symcrypt.WithPacketDump(os.Stderr))
+	}
+	if keyringFile != "" {
+		kr, err := readKeyRing(keyringFile)
+		if err != nil {
+			fatalf("Keyring: %v", err)
+		}
+		opts = append(opts, symcrypt.WithKeyRing(kr))
+	}
+	if keyPass, ok := keyPassphraseProvider(); ok {
+		if keyringFile == "" {
+			exitf(exitUsage, "-key-passphrase-fd, -file and -env need a -keyring with the secret keys")
 		}
+		if sandbox || runAs != "" {
+			if _, err := keyPass.Passphrase(); err != nil {
+				fatalf("Key passphrase: %v", err)
+			}
+		}
+		opts = append(opts, symcrypt.WithKeyPassphrase(keyPass))
+	}
+	if agentKeys {
+		if keyringFile == "" {
+			exitf(exitUsage, "-agent-keys needs a -keyring with the public keys, e.g. ~/.gnupg/pubring.kbx")
+		}
+		if batchMode {
+			exitf(exitUsage, "-agent-keys cannot be used with -batch, as gpg-agent may ask for a key's passphrase or PIN")
+		}
+		opts = append(opts, symcrypt.WithAgentKeys())
+	}
+	if keyserver != "" || useWKD {
+		opts = append(opts, symcrypt.WithSignerLookup(lookupSigner))
+	}
 
-		if first {
-			first = false
-			return []byte(passphrase), nil
+	return symcrypt.NewDecryptor(opts...)
+}
+
+// readKeyRing reads a binary or ASCII armored key ring, or a GnuPG
+// keybox such as ~/.gnupg/pubring.kbx.
+func readKeyRing(name string) (openpgp.EntityList, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	br := bufio.NewReader(fd)
+	if head, _ := br.Peek(len(armorStart)); bytes.Equal(head, armorStart) {
+		return openpgp.ReadArmoredKeyRing(br)
+	}
+	if isKeybox(br) {
+		keys, err := keyboxKeys(br)
+		if err != nil {
+			return nil, err
 		}
+		return openpgp.ReadKeyRing(keys)
+	}
+
+	return openpgp.ReadKeyRing(br)
+}
+
+var armorStart = []byte("-----BEGIN PGP")
+
+// signalError is the cause of a pipeline cancelled by a signal.
+type signalError struct {
+	sig os.Signal
+}
 
-		return nil, errors.New("Already called")
+func (e signalError) Error() string {
+	return "Interrupted by " + e.sig.String()
+}
+
+func (e signalError) exitCode() int {
+	if n, ok := e.sig.(syscall.Signal); ok {
+		return exitSignal + int(n)
+	}
 
+	return exitSignal
+}
+
+// copyBufferSize returns the size of the buffer for copying plain
+// text: a small slice of -max-memory, within sensible bounds.
+func copyBufferSize() int {
+	n := int64(maxMemory) / 64
+	switch {
+	case n < 4<<10:
+		n = 4 << 10
+	case n > 1<<20:
+		n = 1 << 20
 	}
+
+	return int(n)
 }
 
 func main() {
 	flag.Parse()
 
+	// Precedence is command line, then environment, then config file
+	set := setFlags(flag.CommandLine)
+	setGroups(set)
+	if err := applyEnv(flag.CommandLine, "", set); err != nil {
+		fatalf("%v", err)
+	}
+	setGroups(set)
+	if configFile != "" {
+		if err := loadConfig(configFile, set["config"], set); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	if showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+	openLogFile()
+	openLogTarget()
+
+	harden()
+	handleDumpSignals()
+
+	if flag.NArg() > 0 {
+		cmd, ok := subcommands[flag.Arg(0)]
+		if !ok {
+			fatalf("Unknown command %q", flag.Arg(0))
+		}
+		os.Exit(cmd(flag.Args()[1:]))
+	}
+
 	if cpuprofile != "" {
 		profFD, err := os.Create(cpuprofile)
 		if err != nil {
-			log.Fatalf("Cpuprofile: os.Create(): %v", err)
+			fatalf("Cpuprofile: os.Create(): %v", err)
 		}
 
 		pprof.StartCPUProfile(profFD)
 		defer pprof.StopCPUProfile()
 	}
 
+	// Both a timeout and a signal cancel the pipeline. It notices at
+	// its next read, but it may be stuck inside one, e.g. on a hung
+	// network filesystem, so the abort happens from here.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout,
+			fmt.Errorf("Timed out after %v", timeout))
+		defer cancelTimeout()
+	}
+
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP,
-		syscall.SIGPIPE)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	// Writes to a pipe whose reader has gone away fail with EPIPE
+	// instead, which is reported for what it is.
+	signal.Ignore(syscall.SIGPIPE)
 	go func() {
-		<-c
+		sig := <-c
+
+		if debugStacks {
+			// In case we had a hang, we print the stack trace here.
+			dumpStacks()
+		}
+
+		cancel(signalError{sig})
+	}()
+
+	go func() {
+		<-ctx.Done()
+		err := context.Cause(ctx)
+		if err == context.Canceled {
+			// Normal completion
+			return
+		}
 
 		if cpuprofile != "" {
 			pprof.StopCPUProfile()
 		}
 
-		// In case we had a hang, we print the stack trace here.
-		buf := make([]byte, 256*1024)
-		n := runtime.Stack(buf, true)
-		fmt.Fprintln(os.Stderr, string(buf[0:n]))
-
-		os.Exit(1)
+		code := exitFailure
+		if sigErr, ok := err.(signalError); ok {
+			code = sigErr.exitCode()
+		}
+		exitf(code, "%v", err)
 	}()
 
-	var fd *os.File = os.Stdin
+	quarantine = allowNoMDC && !noQuarantine
+	startTracing()
+	if filesFrom != "" {
+		tracing.attr("decrypt_symmetric.files_from", filesFrom)
+		decryptFiles(ctx)
+		tracing.finish("")
+		return
+	}
+	if outTemplate != "" {
+		exitf(exitUsage, "-output-template needs -files-from")
+	}
+	if ifExists != existsFail {
+		exitf(exitUsage, "-if-exists needs -files-from")
+	}
+
+	reportInput = filename
+	if filename == "" && len(armorParts) == 0 {
+		reportInput = "-"
+	}
+	tracing.attr("decrypt_symmetric.input", reportInput)
+	var fd io.ReadCloser = os.Stdin
 	var err error
-	if filename != "" {
-		fd, err = os.Open(filename)
+	parts := []string(armorParts)
+	if len(parts) != 0 && filename != "" {
+		exitf(exitUsage, "-part cannot be combined with -filename")
+	}
+	if _, ok := parseURL(filename); !ok && filename != "" {
+		if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+			if parts, err = armorPartFiles(filename); err != nil {
+				fatalf("Input: %v", err)
+			}
+		}
+	}
+	if len(parts) == 0 && filename == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+		// Rather than silently wait for cipher text to be typed in
+		exitf(exitUsage, "No -filename given and stdin is a terminal.\n"+
+			"Usage: decrypt-symmetric -filename FILE.gpg, or decrypt-symmetric < FILE.gpg\n"+
+			"Run decrypt-symmetric -help for all the flags.")
+	}
+	if inPlace {
+		// The plain text is written as FILE.part, renamed to FILE once
+		// it is complete, and only then is FILE.gpg removed.
+		if len(outputs) != 0 || outDir != "" {
+			exitf(exitUsage, "-in-place cannot be combined with -output or -outdir")
+		}
+		if _, ok := parseURL(filename); ok || filename == "" || filename == "-" || len(parts) != 0 {
+			exitf(exitUsage, "-in-place needs a -filename that is a local file")
+		}
+		autoName, deleteAfter = true, true
+		if tempSuffix == "" {
+			tempSuffix = ".part"
+		}
+	}
+	if sandbox {
+		// Which rename the output once it is complete, or remove the
+		// input, and the sandbox allows neither
+		switch {
+		case inPlace:
+			exitf(exitUsage, "-in-place cannot be combined with -sandbox")
+		case tempSuffix != "":
+			exitf(exitUsage, "-temp-suffix cannot be combined with -sandbox")
+		case quarantine:
+			exitf(exitUsage, "-allow-no-mdc needs -no-unverified-suffix with -sandbox, which cannot rename the %s output", unverifiedSuffix)
+		case deleteAfter:
+			exitf(exitUsage, "-delete-after cannot be combined with -sandbox, which removes no files")
+		}
+	}
+	if deleteAfter {
+		if _, ok := parseURL(filename); ok || filename == "" || filename == "-" || len(parts) != 0 {
+			exitf(exitUsage, "-delete-after needs a -filename that is a local file")
+		}
+	}
+	checkBackend()
+	if noDecompress && unwrapDepth > 0 {
+		exitf(exitUsage, "-no-decompress cannot be combined with -unwrap-nested")
+	}
+	if autoDecomp && noDecompress {
+		exitf(exitUsage, "-auto-decompress cannot be combined with -no-decompress")
+	}
+	if autoDecomp && messagesMode != messagesFirst {
+		exitf(exitUsage, "-auto-decompress cannot be combined with -messages %s", messagesMode)
+	}
+	if legacyCipher && fipsMode {
+		exitf(exitUsage, "-allow-legacy-ciphers cannot be combined with -fips, which only decrypts AES")
+	}
+	switch {
+	case maxNesting < 1:
+		exitf(exitUsage, "-max-nesting must be at least 1")
+	case unwrapDepth > maxNesting:
+		exitf(exitUsage, "-unwrap-nested %d is beyond -max-nesting %d", unwrapDepth, maxNesting)
+	}
+	if compareFile != "" {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"-output", len(outputs) != 0},
+			{"-auto-output", autoName},
+			{"-outdir", outDir != ""},
+			{"-verify-before-output", verifyFirst},
+			{"-delete-after", deleteAfter},
+		} {
+			if f.set {
+				exitf(exitUsage, "-compare writes nothing, so cannot be combined with %s", f.name)
+			}
+		}
+	}
+	autoOutput(false, false)
+	checkMessages()
+	if len(parts) != 0 {
+		// The parts are small, as they were made to fit in mails.
+		msg, err := joinArmorParts(parts)
+		if err != nil {
+			fatalf("Input: %v", err)
+		}
+		fd = io.NopCloser(bytes.NewReader(msg))
+	} else if filename != "" {
+		fd, err = openSource(filename)
 		if err != nil {
-			log.Fatalf("Input: os.Open(): %v", err)
+			fatalf("Input: %v", err)
 		}
 		defer fd.Close()
 	}
 
-	md, err := openpgp.ReadMessage(fd, emptyKR{}, newPromptFunction(), nil)
+	start := time.Now()
+	audit, err := openAudit("decrypt")
 	if err != nil {
-		log.Fatalf("openpgp.ReadMessage(): %v", err)
+		fatalf("Audit log: %v", err)
+	}
+	var src io.Reader = fd
+	if bwLimit > 0 {
+		src = newRateReader(src, int64(bwLimit))
+	}
+	if readAhead > 0 {
+		ra := newReadAheadReader(src, int(readAhead))
+		defer ra.Close()
+		src = ra
+	}
+	inCount := &countingReader{r: audit.reader(src)}
+	reportOffset = inCount.count
+	stopProgress := startProgress(inCount, inputSize(fd))
+	br := bufio.NewReader(inCount)
+	var in io.Reader = br
+	format, desc := inputFormat(br)
+	checkInputFormat(format, desc)
+	if messagesMode != messagesFirst && format != formatOpenPGP {
+		exitf(exitUsage, "-messages %s only applies to OpenPGP messages, not %s", messagesMode, desc)
+	}
+
+	// With -verify-before-output, the outputs are only created once
+	// the plain text has been verified, so nothing ever sees any of it
+	// otherwise. Without it, they are created at the first write, once
+	// the passphrase has proven right.
+	var sp *spool
+	var lw *lazyWriter
+	var dst io.Writer
+	var outFDs []sink
+	var outW io.Writer
+	open := func() io.Writer {
+		if outW == nil {
+			outW, outFDs = openOutputs()
+		}
+		return outW
+	}
+	var cmp *compareWriter
+	switch {
+	case compareFile != "":
+		if cmp, err = newCompareWriter(compareFile); err != nil {
+			fatalf("Compare: %v", err)
+		}
+		dst = cmp
+	case verifyFirst:
+		sp = newSpool(int64(maxMemory))
+		dst = sp
+	case messagesMode == messagesSplit:
+		// Each message has an output of its own
+		dst = io.Discard
+	default:
+		lw = &lazyWriter{open: open}
+		dst = lw
+	}
+
+	var d *symcrypt.Decryptor
+	var backendPass []byte
+	if backendName == defaultBackend && format == formatOpenPGP {
+		d = newDecryptor(passphrase)
+	} else {
+		provider, _ := passphraseProvider(passphrase, "decrypt")
+		if yubikeySlot != 0 {
+			provider = yubikeyProvider{provider, yubikeySlot}
+		}
+		if backendPass, err = provider.Passphrase(); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	// Created up front, as the outputs are
+	man := openManifest("decrypt")
+	if runAs != "" {
+		uid, gid, err := lookupRunAs(runAs)
+		if err != nil {
+			fatalf("Run as: %v", err)
+		}
+		// The outputs are created, as the user we were started as,
+		// before any cipher text is parsed as the other one.
+		open()
+		if err := dropPrivileges(uid, gid); err != nil {
+			fatalf("Run as %s: %v", runAs, err)
+		}
+	}
+	if c, ok := confinePaths(man); ok && !noConfine {
+		if err := confine(c); err != nil {
+			fatalf("Confine: %v", err)
+		}
+	}
+	if sandbox {
+		// Everything that needs the file system or the network has to
+		// happen before the sandbox goes up, so the outputs cannot
+		// wait for the plain text.
+		if keyserver != "" || useWKD {
+			fatalf("Sandbox: cannot look up keys with -keyserver or -wkd")
+		}
+		if agentKeys {
+			fatalf("Sandbox: cannot reach gpg-agent for -agent-keys")
+		}
+		if tracing != nil {
+			fatalf("Sandbox: cannot export the trace to %s", tracing.endpoint)
+		}
+		for _, name := range outputs {
+			if _, ok := parseURL(name); ok {
+				fatalf("Sandbox: cannot write to URL %s", name)
+			}
+		}
+		open()
+		if sp != nil {
+			if err := sp.spill(); err != nil {
+				fatalf("Sandbox: %v", err)
+			}
+			// Removed while that can be done; it is only used through
+			// its descriptor
+			os.Remove(sp.file.Name())
+		}
+		if err := enterSandbox(); err != nil {
+			fatalf("Sandbox: %v", err)
+		}
+		sandboxed = true
 	}
-	log.Println("openpgp.ReadMessage() returned without error")
 
-	_, err = io.Copy(os.Stdout, md.UnverifiedBody)
+	rec := man.start(manifestName(filename), manifestName(outputs...))
+	if rec != nil {
+		dst = io.MultiWriter(dst, rec)
+	}
+	outCount := &countingWriter{w: dst}
+	var plain io.Writer = outCount
+	var decomp *decompressWriter
+	if autoDecomp {
+		decomp = newDecompressWriter(outCount, func(c *plainCompression) {
+			verbosef("The plain text is %s compressed; decompressing it", c.name)
+			if outW == nil && autoOutputExt(c.ext) {
+				rec.renamed(manifestName(outputs...), "")
+			}
+		})
+		plain = decomp
+	}
+	var unwrap *unwrapWriter
+	if unwrapDepth > 0 {
+		unwrap = newUnwrapWriter(ctx, plain, d, unwrapDepth)
+		plain = unwrap
+	}
+	var res symcrypt.Result
+	switch {
+	case d != nil && messagesMode == messagesSplit:
+		res, err = decryptSplit(ctx, d, in)
+	case d != nil && messagesMode == messagesJoin:
+		res, err = decryptJoined(ctx, d, plain, in)
+	case d != nil:
+		res, err = d.DecryptContext(ctx, plain, in)
+	case format == formatOpenSSL:
+		err = decryptOpenSSL(plain, in, backendPass)
+	case format == formatSecretbox:
+		err = decryptSecretbox(plain, in, backendPass)
+	case format == formatScrypt:
+		err = decryptScrypt(plain, in, backendPass)
+	case format == formatCMS:
+		err = decryptCMS(plain, in, backendPass)
+	default:
+		res.Integrity, err = backends[backendName](plain, in, backendPass)
+	}
+	stopProgress()
+	if err == nil && unwrap != nil {
+		err = unwrap.Close()
+	}
+	if err == nil && decomp != nil {
+		err = decomp.Close()
+	}
 	if err != nil {
-		log.Fatalf("Reading unverified plain text: io.Copy(): %v", err)
+		audit.finish(&res, err.Error())
+		if errors.Is(err, symcrypt.ErrNotPassphraseEncrypted) && keyringFile == "" {
+			fatalf("%v: it is encrypted to public keys %s; -keyring decrypts it with their secret keys",
+				err, keyIDs(res.PublicKeyIDs))
+		}
+		decryptFailed(&res, err)
+		copyFailed(fd, err)
+	}
+	if d != nil {
+		reportMessage(&res)
+		tracing.result(&res)
+	}
+	switch format {
+	case formatOpenSSL:
+		warnf("openssl enc files have no integrity protection")
+	case formatCMS:
+		warnf("CMS enveloped data has no integrity protection")
+	}
+	if noDecompress {
+		reportNoDecompress(&res)
+	}
+
+	if sp != nil {
+		setPhase("write")
+		if _, err := sp.WriteTo(open()); err != nil {
+			copyFailed(fd, err)
+		}
+		sp.Close()
+	} else if lw != nil {
+		// In case there was no plain text at all
+		lw.ensure()
+	}
+
+	if deleteAfter {
+		// The plain text has to be on disk before the cipher text goes.
+		for _, outFD := range outFDs {
+			if o, ok := outFD.(*outputFile); ok && o.regular {
+				if err := o.Sync(); err != nil {
+					fatalf("Output: %v", err)
+				}
+			}
+		}
+	}
+	for _, outFD := range outFDs {
+		if o, ok := outFD.(*outputFile); ok && !res.Integrity {
+			o.unverified()
+		}
+		if err := outFD.commit(); err != nil {
+			fatalf("Output: %v", err)
+		}
+		// And so does its rename from the -temp-suffix name
+		if o, ok := outFD.(*outputFile); ok && deleteAfter && o.final != "" {
+			syncDir(filepath.Dir(o.final))
+		}
+	}
+	if deleteAfter {
+		if !res.Integrity {
+			log.Printf("Not removing %s: the plain text was not integrity protected", filename)
+		} else if err := os.Remove(filename); err != nil {
+			fatalf("Delete: %v", err)
+		}
+	}
+
+	if cmp != nil {
+		compared(cmp, outCount.n)
+	}
+
+	audit.finish(&res, "")
+	rec.done(nil)
+	if err := man.write(); err != nil {
+		fatalf("Manifest: %v", err)
 	}
+	if showSummary {
+		logSummary(&res)
+	}
+	tracing.finish("")
+	if showStats {
+		logStats(inCount.n, outCount.n, start)
+		if verbose {
+			logPhaseTimes(&res)
+		}
+	}
+}
+
+// compared reports the -compare of n bytes of plain text, failing if the
+// file differs.
+func compared(cmp *compareWriter, n int64) {
+	diff, err := cmp.finish()
+	switch {
+	case err != nil:
+		fatalf("%v", err)
+	case diff == n:
+		fatalf("%s differs: it is longer than the %d bytes of plain text", compareFile, n)
+	case cmp.short:
+		fatalf("%s differs: it ends after %d of the %d bytes of plain text", compareFile, diff, n)
+	case diff >= 0:
+		fatalf("%s differs from the plain text at byte offset %d", compareFile, diff)
+	}
+	log.Printf("%s is the same as the plain text, %d bytes", compareFile, n)
+}
+
+// reportMessage logs what decryption found out about the message: how
+// it was decrypted, its integrity and its signature.
+func reportMessage(res *symcrypt.Result) {
+	reportDecryption(res)
+	if res.ArmorChecksumMismatch {
+		reportArmorChecksum(res)
+	}
+	if !res.Integrity {
+		warnf("the message was not integrity protected")
+	}
+	if res.SignedBy != nil && res.SignerLookedUp {
+		log.Printf("Good signature from key %X, fetched from %s and NOT trusted: it is not in the -keyring",
+			res.SignedBy.PublicKey.Fingerprint, signerSource)
+	} else if res.SignedBy != nil {
+		log.Printf("Good signature from key %X", res.SignedBy.PublicKey.Fingerprint)
+	} else if res.Signed {
+		reportUnverified(res)
+	}
+	if res.Signed {
+		reportSignature(res)
+	}
+}
+
+// openOutputs creates the -outputs, returning a writer to all of them.
+// It is only called once the passphrase has proven right, so that a
+// typo does not clobber an existing file.
+func openOutputs() (io.Writer, []sink) {
+	if len(outputs) == 0 {
+		outputs = stringList{"-"}
+	}
+
+	var outs []io.Writer
+	var outFDs []sink
+	for _, name := range outputs {
+		outFD, err := openSink(name)
+		if err != nil {
+			fatalf("Output: %v", err)
+		}
+		outs = append(outs, outFD)
+		outFDs = append(outFDs, outFD)
+	}
+
+	return io.MultiWriter(outs...), outFDs
+}
+
+// decryptFailed exits if err says that the passphrase was wrong, or
+// that the data was corrupt once the passphrase had been accepted,
+// which are told apart by the session key checksums and the quick
+// check bytes.
+func decryptFailed(res *symcrypt.Result, err error) {
+	switch {
+	case errors.Is(err, symcrypt.ErrWrongPassphrase):
+		exitf(exitWrongPassphrase, "Incorrect passphrase: it decrypts none of the message's session keys")
+	case errors.Is(err, symcrypt.ErrKeyLocked):
+		exitf(exitWrongPassphrase, "Incorrect key passphrase: %v\n"+
+			"-key-passphrase-file, -fd or -env gives the passphrase of the -keyring's secret keys, where it is not the message's.", err)
+	case errors.Is(err, symcrypt.ErrArmorChecksum):
+		exitf(exitCorrupt, "Data corrupted: %v\n-ignore-armor-crc goes on regardless, leaving it to the MDC to tell whether the plain text is intact.", err)
+	case res.Cipher != 0 && errors.Is(err, symcrypt.ErrInvalidMessage):
+		// A wrong session key passes the quick check once in 65536
+		// times, and would end up here too.
+		exitf(exitCorrupt, "Data corrupted: the session key was accepted but %v", err)
+	case errors.Is(err, symcrypt.ErrNotOpenPGP):
+		fatalf("The input is not an OpenPGP message: %v\n"+
+			"Binary messages and ASCII armor, starting -----BEGIN PGP MESSAGE-----, are both recognized without a flag; "+
+			"armor that is quoted or indented in a mail, or has lost its BEGIN line, fails like this.", err)
+	case errors.Is(err, symcrypt.ErrLegacyCipher):
+		fatalf("%v\n-allow-legacy-ciphers decrypts it; nothing has encrypted with these ciphers by default since the 1990s, "+
+			"and this program never does.", err)
+	case errors.Is(err, symcrypt.ErrAEAD):
+		fatalf("%v\nIt was encrypted as RFC 9580 or GnuPG's OCB mode have it; "+
+			"-backend gocrypto, in a build with -tags gocrypto, decrypts it.", err)
+	case errors.Is(err, errOpenSSLPadding):
+		exitf(exitWrongPassphrase, "Incorrect passphrase, or -openssl-iter, or the data is corrupt: %v\n"+
+			"Only files made with openssl enc -aes-256-cbc -pbkdf2 are read; without -pbkdf2, openssl derives the key another way.", err)
+	case errors.Is(err, errSecretboxKey):
+		exitf(exitWrongPassphrase, "Incorrect passphrase, or the data is corrupt: %v", err)
+	case errors.Is(err, errScryptKey):
+		exitf(exitWrongPassphrase, "Incorrect passphrase: %v", err)
+	case errors.Is(err, errCMSKey):
+		exitf(exitWrongPassphrase, "Incorrect passphrase: %v", err)
+	case isStructuralError(err):
+		fatalf("%v\nThe input is not an OpenPGP message that -backend %s can read; "+
+			"-backend %s says more about what is wrong with it.", err, backendName, defaultBackend)
+	}
+}
 
-	// Check that any authentication code for the message was
-	// verified successfully
-	if md.SignatureError != nil {
-		log.Fatalln("Integrity Check FAILED:", md.SignatureError)
+// copyFailed reports err from copying the plain text read from fd, and
+// exits.
+func copyFailed(fd io.Reader, err error) {
+	switch {
+	case isIntegrityError(err):
+		integrityFailed(err)
+	case isReaderGone(err):
+		exitf(exitSignal+int(syscall.SIGPIPE),
+			"Output: the reader went away before the end of the plain text: %v", err)
+	case isWriterGone(fd, err):
+		fatalf("Input: the writer closed the pipe before the end of the message: %v", err)
+	case errors.Is(err, symcrypt.ErrNoIntegrityProtection) && !fipsMode && !symcrypt.FIPSMode():
+		fatalf("%v\nIt cannot be told whether it has been tampered with; -allow-no-mdc decrypts it anyway.", err)
 	}
+	fatalf("%v", err)
 }
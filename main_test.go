@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Set in the environment of the test binary when it is run as the
+// program itself, by runMain
+const testMainEnv = "DECRYPT_SYMMETRIC_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runMain runs the program with args, and env added to an environment
+// of no more than PATH and a home directory of its own, so that no
+// config file or DECSYM_* variable of the user's applies. It returns
+// the exit status and what was written to stderr.
+func runMain(t *testing.T, env []string, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	home := t.TempDir()
+	cmd.Env = append([]string{testMainEnv + "=1", "PATH=" + os.Getenv("PATH"),
+		"HOME=" + home, "XDG_CONFIG_HOME=" + filepath.Join(home, ".config")}, env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, stderr.String()
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode(), stderr.String()
+	}
+	t.Fatal(err)
+
+	return 0, ""
+}
+
+// writeFile writes data to name in dir, and returns its path.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// corruptCompressed returns a message encrypted with the selftest
+// passphrase, whose MDC is right but whose compressed data is not.
+func corruptCompressed(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	config := &packet.Config{DefaultCipher: packet.CipherAES256}
+	key, err := packet.SerializeSymmetricKeyEncrypted(&buf, []byte(selftestPassphrase), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := packet.SerializeSymmetricallyEncrypted(&buf, config.Cipher(), key, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A compressed data packet of indeterminate length, of ZIP, and a
+	// deflate block of the reserved type
+	w.Write([]byte{0x80 | 8<<2 | 3, 1, 0xff, 0xff, 0xff, 0xff})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	right := writeFile(t, dir, "right", selftestPassphrase+"\n")
+	wrong := writeFile(t, dir, "wrong", "wrong\n")
+	corrupt := writeFile(t, dir, "corrupt.gpg", string(corruptCompressed(t)))
+	notOpenPGP := writeFile(t, dir, "plain.txt", "not a message\n")
+
+	for _, tt := range []struct {
+		name     string
+		pass     string
+		input    string
+		flags    []string
+		want     int
+		category string
+	}{
+		{"decrypted", right, "vectors/aes256-iterated.gpg", nil, 0, ""},
+		{"wrong passphrase", wrong, "vectors/aes256-iterated.gpg", nil, exitWrongPassphrase, categoryWrongPassphrase},
+		{"tampered MDC", right, "vectors/tampered-mdc.gpg", nil, exitCorrupt, categoryIntegrity},
+		{"corrupt compressed data", right, corrupt, nil, exitCorrupt, categoryCorrupt},
+		{"not OpenPGP", right, notOpenPGP, nil, exitFailure, categoryFormat},
+		{"no MDC", right, "vectors/aes256-nomdc.gpg", nil, exitFailure, categoryFailure},
+		{"no MDC allowed", right, "vectors/aes256-nomdc.gpg", []string{"-allow-no-mdc"}, 0, ""},
+		{"xcrypto", right, "vectors/aes256-iterated.gpg", []string{"-backend", "xcrypto"}, 0, ""},
+		{"xcrypto, no MDC", right, "vectors/aes256-nomdc.gpg", []string{"-backend", "xcrypto"}, exitFailure, categoryFailure},
+		{"xcrypto, armored without an MDC", right, "vectors/3des-nomdc.asc", []string{"-backend", "xcrypto"}, exitFailure, categoryFailure},
+		{"xcrypto, no MDC allowed", right, "vectors/aes256-nomdc.gpg", []string{"-backend", "xcrypto", "-allow-no-mdc"}, 0, ""},
+		{"bench", right, "vectors/aes256-iterated.gpg", []string{"bench", "-count", "1"}, 0, ""},
+		{"bench, wrong passphrase", wrong, "vectors/aes256-iterated.gpg", []string{"bench", "-count", "1"}, exitWrongPassphrase, categoryWrongPassphrase},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-batch", "-json", "-passphrase-file", tt.pass, "-filename", tt.input,
+				"-output", filepath.Join(t.TempDir(), "out")}, tt.flags...)
+			code, stderr := runMain(t, nil, args...)
+			if code != tt.want {
+				t.Fatalf("exit status %d, want %d; stderr:\n%s", code, tt.want, stderr)
+			}
+			if tt.category == "" {
+				return
+			}
+			// Nothing but the object, not even the removal of the
+			// partial output
+			var report errorReport
+			if err := json.Unmarshal([]byte(stderr), &report); err != nil || strings.Count(stderr, "\n") != 1 {
+				t.Fatalf("stderr is not one -json report: %v\n%s", err, stderr)
+			}
+			if report.Category != tt.category || report.Code != tt.want {
+				t.Errorf("reported %s with status %d, want %s with %d", report.Category, report.Code, tt.category, tt.want)
+			}
+		})
+	}
+}
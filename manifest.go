@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// manifestEntry is a line of the -manifest: a file that was processed,
+// and what became of it.
+type manifestEntry struct {
+	Input           string `json:"input"`
+	Output          string `json:"output"`
+	PlaintextSHA256 string `json:"plaintext_sha256,omitempty"`
+	PlaintextSize   int64  `json:"plaintext_size"`
+	// ok, unchanged, skipped or error
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// encrypt or decrypt, which says which of the input and output is
+	// the plain text
+	Operation string `json:"operation,omitempty"`
+}
+
+// manifest collects the entries for the -manifest, and writes it at
+// the end, in CSV if its name ends in .csv and in JSON otherwise. It is
+// written on an error exit too, with the files that were being
+// processed as failed.
+type manifest struct {
+	name string
+	op   string
+	// The file that the manifest is written to next: a temporary one,
+	// created up front, before the process is confined, and renamed
+	// over it, or, in the sandbox, which can neither create nor rename
+	// files, the manifest itself
+	file    *os.File
+	inPlace bool
+	written bool
+	mu      sync.Mutex
+	entries []manifestEntry
+	pending map[*manifestRecord]bool
+}
+
+// manifestRecord is the entry for a file that is being processed,
+// whose plain text is written to it to be digested.
+type manifestRecord struct {
+	m *manifest
+	e manifestEntry
+	h hash.Hash
+}
+
+// openManifest returns the -manifest of the operation op, or nil
+// without one.
+func openManifest(op string) *manifest {
+	if manifestFile == "" {
+		return nil
+	}
+
+	m := &manifest{name: manifestFile, op: op, pending: make(map[*manifestRecord]bool)}
+	var err error
+	if sandbox {
+		// Readable by no one else, as CreateTemp's would be: the
+		// manifest names what was decrypted and digests its plain text
+		m.file, err = os.OpenFile(manifestFile, os.O_RDWR|os.O_CREATE, 0o600)
+		m.inPlace = true
+	} else {
+		m.file, err = os.CreateTemp(filepath.Dir(manifestFile), ".manifest-*")
+	}
+	if err != nil {
+		fatalf("Manifest: %v", err)
+	}
+	atExit(func() {
+		m.mu.Lock()
+		for r := range m.pending {
+			m.entries = append(m.entries, r.failed(exitMessage))
+		}
+		// Unless it was written, or failed to be, with all there is
+		// to go in it
+		done := m.written && len(m.pending) == 0
+		m.pending = nil
+		m.mu.Unlock()
+		if done {
+			return
+		}
+		if err := m.write(); err != nil {
+			log.Printf("Manifest: %v", err)
+		}
+	})
+
+	return m
+}
+
+// start begins the entry for input, or returns nil without a manifest.
+func (m *manifest) start(input, output string) *manifestRecord {
+	if m == nil {
+		return nil
+	}
+
+	r := &manifestRecord{m: m, e: manifestEntry{Input: input, Output: output, Operation: m.op}, h: sha256.New()}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[r] = true
+
+	return r
+}
+
+func (r *manifestRecord) Write(p []byte) (int, error) {
+	r.e.PlaintextSize += int64(len(p))
+	return r.h.Write(p)
+}
+
+// renamed records that the output was given another name, and, unless
+// status is empty, how it got it, for a success.
+func (r *manifestRecord) renamed(output, status string) {
+	if r != nil {
+		r.e.Output, r.e.Status = output, status
+	}
+}
+
+// done finishes the entry as successful, or, if err is not nil, as
+// failed with it.
+func (r *manifestRecord) done(err error) {
+	if r == nil {
+		return
+	}
+
+	e := r.e
+	if err != nil {
+		e = r.failed(err.Error())
+	} else {
+		if e.Status == "" {
+			e.Status = "ok"
+		}
+		e.PlaintextSHA256 = hex.EncodeToString(r.h.Sum(nil))
+	}
+	r.finish(e)
+}
+
+// skipped finishes the entry as a file that was not decrypted, as its
+// output exists.
+func (r *manifestRecord) skipped() {
+	if r == nil {
+		return
+	}
+
+	e := r.e
+	e.Status, e.PlaintextSize = "skipped", 0
+	r.finish(e)
+}
+
+func (r *manifestRecord) finish(e manifestEntry) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	delete(r.m.pending, r)
+	r.m.entries = append(r.m.entries, e)
+}
+
+func (r *manifestRecord) failed(reason string) manifestEntry {
+	e := r.e
+	e.Status, e.Error = "error", reason
+	return e
+}
+
+// note adds an entry for a file that was not processed, with status
+// and the size of its plain text.
+func (m *manifest) note(input, output, status string, size int64) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, manifestEntry{Input: input, Output: output,
+		PlaintextSize: size, Status: status, Operation: m.op})
+}
+
+// write writes the manifest, replacing any that was there.
+func (m *manifest) write() error {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written = true
+	tmp := m.file
+	if m.inPlace {
+		return m.writeTo(tmp)
+	}
+	if tmp == nil {
+		// The one created up front was renamed by an earlier write
+		var err error
+		if tmp, err = os.CreateTemp(filepath.Dir(m.name), ".manifest-*"); err != nil {
+			return err
+		}
+	}
+	m.file = nil
+	defer os.Remove(tmp.Name())
+
+	err := m.writeTo(tmp)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.name)
+}
+
+// writeTo writes the entries to fd, from its start, replacing what was
+// there.
+func (m *manifest) writeTo(fd *os.File) error {
+	if err := fd.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var err error
+	if strings.EqualFold(filepath.Ext(m.name), ".csv") {
+		cw := csv.NewWriter(fd)
+		cw.Write(manifestColumns)
+		for _, e := range m.entries {
+			cw.Write([]string{e.Input, e.Output, e.PlaintextSHA256,
+				strconv.FormatInt(e.PlaintextSize, 10), e.Status, e.Error, e.Operation})
+		}
+		cw.Flush()
+		err = cw.Error()
+	} else {
+		entries := m.entries
+		if entries == nil {
+			entries = []manifestEntry{}
+		}
+		enc := json.NewEncoder(fd)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	}
+
+	return err
+}
+
+// paths returns the files that writing the manifest needs, once the
+// process is confined.
+func (m *manifest) paths() []string {
+	if m == nil || m.inPlace {
+		return nil
+	}
+
+	return []string{m.file.Name(), m.name}
+}
+
+// manifestColumns is the header of a CSV manifest.
+var manifestColumns = []string{"input", "output", "plaintext_sha256", "plaintext_size", "status", "error", "operation"}
+
+// readManifest reads a manifest that write wrote, in CSV if name ends
+// in .csv and in JSON otherwise. The operation column, which older ones
+// do not have, may be missing.
+func readManifest(name string) ([]manifestEntry, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	if !strings.EqualFold(filepath.Ext(name), ".csv") {
+		var entries []manifestEntry
+		if err := json.NewDecoder(fd).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return entries, nil
+	}
+
+	cr := csv.NewReader(fd)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(records) == 0 || len(records[0]) < 6 || len(records[0]) > len(manifestColumns) ||
+		!slices.Equal(records[0], manifestColumns[:len(records[0])]) {
+		return nil, fmt.Errorf("%s: not a manifest: its columns are not %s", name, strings.Join(manifestColumns, ","))
+	}
+	var entries []manifestEntry
+	for i, rec := range records[1:] {
+		if len(rec) < 6 {
+			return nil, fmt.Errorf("%s: line %d has %d columns", name, i+2, len(rec))
+		}
+		size, err := strconv.ParseInt(rec[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: bad size %q", name, i+2, rec[3])
+		}
+		e := manifestEntry{Input: rec[0], Output: rec[1], PlaintextSHA256: rec[2], PlaintextSize: size,
+			Status: rec[4], Error: rec[5]}
+		if len(rec) > 6 {
+			e.Operation = rec[6]
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// manifestName is how names, of the input or outputs, appear in the
+// manifest: - for the standard input or output.
+func manifestName(names ...string) string {
+	if len(names) == 0 || len(names) == 1 && names[0] == "" {
+		return "-"
+	}
+
+	return strings.Join(names, ",")
+}
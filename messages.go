@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// What -messages does with an input of several messages back to back:
+// decrypt only the first, as gpg does, or each, into numbered outputs
+// or one output with a marker line before each.
+const (
+	messagesFirst = "first"
+	messagesSplit = "split"
+	messagesJoin  = "join"
+)
+
+// checkMessages exits if -messages is unknown, or combined with a flag
+// that only takes a single message.
+func checkMessages() {
+	switch messagesMode {
+	case messagesFirst:
+		return
+	case messagesSplit, messagesJoin:
+	default:
+		exitf(exitUsage, "Unknown -messages %q: want %s, %s or %s", messagesMode, messagesFirst, messagesSplit, messagesJoin)
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-verify-before-output", verifyFirst},
+		{"-compare", compareFile != ""},
+		{"-unwrap-nested", unwrapDepth > 0},
+		{"-backend", backendName != defaultBackend},
+		{"-delete-after", deleteAfter},
+		{"-part", len(armorParts) != 0},
+	} {
+		if f.set {
+			exitf(exitUsage, "-messages %s cannot be combined with %s", messagesMode, f.name)
+		}
+	}
+	if messagesMode == messagesSplit {
+		// Which create the -output before any plain text
+		if sandbox || runAs != "" {
+			exitf(exitUsage, "-messages split cannot be combined with -sandbox or -run-as")
+		}
+		if len(outputs) != 1 || outputs[0] == "-" {
+			exitf(exitUsage, "-messages split needs one -output file, after which the outputs are numbered")
+		}
+		if _, ok := parseURL(outputs[0]); ok {
+			exitf(exitUsage, "-messages split cannot write to URL %s", outputs[0])
+		}
+	}
+}
+
+// decryptJoined decrypts each message in r to w, after a line
+// ==> message N <== for each, and returns the Result of the last, which
+// is only integrity protected if they all were.
+func decryptJoined(ctx context.Context, d *symcrypt.Decryptor, w io.Writer, r io.Reader) (symcrypt.Result, error) {
+	results, err := d.DecryptEach(ctx, r, func(prev []symcrypt.Result) (io.Writer, error) {
+		if _, err := fmt.Fprintf(w, "==> message %d <==\n", len(prev)+1); err != nil {
+			return nil, err
+		}
+		return w, nil
+	})
+
+	return lastMessage(results, err)
+}
+
+// decryptSplit decrypts each message in r to its own output, the
+// -output with .1, .2 and so on added, each committed once it has been
+// decrypted in full, and returns the Result of the last, as
+// decryptJoined does.
+func decryptSplit(ctx context.Context, d *symcrypt.Decryptor, r io.Reader) (symcrypt.Result, error) {
+	mr := d.Messages(ctx, r)
+	defer mr.Close()
+
+	var results []symcrypt.Result
+	for {
+		res, plain, err := mr.NextMessage()
+		if err == io.EOF {
+			return lastMessage(results, nil)
+		} else if err != nil {
+			return lastMessage(results, err)
+		}
+		o, err := createOutput(fmt.Sprintf("%s.%d", outputs[0], len(results)+1))
+		if err != nil {
+			return lastMessage(results, err)
+		}
+		if _, err = io.Copy(o, plain); err != nil {
+			mr.Close()
+			o.discard()
+		} else {
+			if !res.Integrity {
+				o.unverified()
+			}
+			err = o.commit()
+		}
+		results = append(results, *res)
+		if err != nil {
+			return lastMessage(results, err)
+		}
+	}
+}
+
+// lastMessage reports on each message but the last, which the caller
+// does, and returns its Result, Integrity only if all of theirs is.
+func lastMessage(results []symcrypt.Result, err error) (symcrypt.Result, error) {
+	if len(results) == 0 {
+		return symcrypt.Result{}, err
+	}
+	last := results[len(results)-1]
+	for i := range results[:len(results)-1] {
+		verbosef("Message %d:", i+1)
+		reportMessage(&results[i])
+		last.Integrity = last.Integrity && results[i].Integrity
+	}
+	if err == nil {
+		log.Printf("Decrypted %d messages", len(results))
+	}
+
+	return last, err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The format of `openssl enc -aes-256-cbc -pbkdf2 -salt`: the magic,
+// an 8-byte salt, and the plain text in AES-256-CBC with PKCS #7
+// padding, under a key and IV derived from the passphrase and the salt
+// with PBKDF2-HMAC-SHA256, 10000 iterations unless -iter said
+// otherwise. There is no MAC, so nothing tells whether it has been
+// tampered with.
+const defaultOpenSSLIter = 10000
+
+var opensslMagic = []byte("Salted__")
+
+// errOpenSSLPadding is what a wrong passphrase, a wrong -openssl-iter,
+// or corrupt data, all show up as.
+var errOpenSSLPadding = errors.New("openssl: bad padding at the end of the data")
+
+// checkOpenSSLIter refuses an -openssl-iter that PBKDF2 cannot take.
+func checkOpenSSLIter() {
+	if opensslIter < 1 {
+		exitf(exitUsage, "-openssl-iter must be at least 1")
+	}
+}
+
+// opensslCipher returns the AES-256 cipher and the CBC IV for pass and
+// salt.
+func opensslCipher(pass, salt []byte, iter int) (cipher.Block, []byte) {
+	km := pbkdf2.Key(pass, salt, iter, 32+aes.BlockSize, sha256.New)
+	block, _ := aes.NewCipher(km[:32])
+
+	return block, km[32:]
+}
+
+// decryptOpenSSL decrypts the openssl enc file r with pass to w. A
+// wrong passphrase only shows at the end, as bad padding in the last
+// block.
+func decryptOpenSSL(w io.Writer, r io.Reader, pass []byte) error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("openssl: short header: %w", err)
+	}
+	block, iv := opensslCipher(pass, hdr[8:], opensslIter)
+
+	return decryptCBC(w, r, cipher.NewCBCDecrypter(block, iv), "openssl", errOpenSSLPadding)
+}
+
+// decryptCBC decrypts r with cbc to w, and takes off the PKCS #7
+// padding, holding the last block back until then. Bad padding is
+// errPadding; other errors are prefixed with name.
+func decryptCBC(w io.Writer, r io.Reader, cbc cipher.BlockMode, name string, errPadding error) error {
+	bs := cbc.BlockSize()
+	br := bufio.NewReader(r)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			_, err := br.Peek(1)
+			last = err == io.EOF
+		}
+		chunk := buf[:n]
+		if len(chunk)%bs != 0 || last && len(chunk) == 0 {
+			return fmt.Errorf("%s: the data is not a whole number of blocks: truncated?", name)
+		}
+		cbc.CryptBlocks(chunk, chunk)
+		if last {
+			pad := int(chunk[len(chunk)-1])
+			if pad == 0 || pad > bs || !bytes.Equal(chunk[len(chunk)-pad:],
+				bytes.Repeat([]byte{byte(pad)}, pad)) {
+				return errPadding
+			}
+			_, err := w.Write(chunk[:len(chunk)-pad])
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// opensslWriter encrypts what is written to it in the openssl enc
+// format to w. Close writes the padded last block; it does not close w.
+type opensslWriter struct {
+	w    io.Writer
+	cbc  cipher.BlockMode
+	held []byte
+}
+
+func newOpenSSLWriter(w io.Writer, pass []byte) (*opensslWriter, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	block, iv := opensslCipher(pass, salt, opensslIter)
+	if _, err := w.Write(append(append([]byte{}, opensslMagic...), salt...)); err != nil {
+		return nil, err
+	}
+
+	return &opensslWriter{w: w, cbc: cipher.NewCBCEncrypter(block, iv)}, nil
+}
+
+func (ow *opensslWriter) Write(p []byte) (int, error) {
+	ow.held = append(ow.held, p...)
+	whole := len(ow.held) / aes.BlockSize * aes.BlockSize
+	if whole == 0 {
+		return len(p), nil
+	}
+
+	ow.cbc.CryptBlocks(ow.held[:whole], ow.held[:whole])
+	if _, err := ow.w.Write(ow.held[:whole]); err != nil {
+		return 0, err
+	}
+	ow.held = append(ow.held[:0], ow.held[whole:]...)
+
+	return len(p), nil
+}
+
+func (ow *opensslWriter) Close() error {
+	pad := aes.BlockSize - len(ow.held)
+	last := append(ow.held, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	ow.cbc.CryptBlocks(last, last)
+	_, err := ow.w.Write(last)
+
+	return err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// outputFile is a plain text file that is removed again unless
+// decryption completes and passes its integrity checks, so that
+// nothing downstream picks up truncated or unauthenticated data. With
+// -temp-suffix, it is written under a name with the suffix, and only
+// renamed to its own once it is complete.
+//
+// With -allow-no-mdc, it is written under a name with the .UNVERIFIED
+// suffix instead, which it keeps unless the plain text turns out to be
+// integrity protected, so that nothing downstream takes plain text that
+// could have been tampered with for the real thing.
+type outputFile struct {
+	*os.File
+	// Only regular files are removed; not devices or FIFOs
+	regular bool
+	// The name to rename the file to at commit, if it is written
+	// under another
+	final string
+
+	once sync.Once
+}
+
+// unverifiedSuffix is added to the name of plain text that was not
+// integrity protected.
+const unverifiedSuffix = ".UNVERIFIED"
+
+// quarantine is whether decrypted outputs are written with the
+// unverifiedSuffix until they are known to be integrity protected.
+var quarantine bool
+
+// sandboxed is whether -sandbox is in force, when files can no longer
+// be removed.
+var sandboxed bool
+
+func createOutput(name string) (*outputFile, error) {
+	final := ""
+	if quarantine {
+		if fi, err := os.Stat(name); err != nil || fi.Mode().IsRegular() {
+			return openOutput(name+unverifiedSuffix, name)
+		}
+	}
+	if tempSuffix != "" {
+		if fi, err := os.Stat(name); err != nil || fi.Mode().IsRegular() {
+			final, name = name, name+tempSuffix
+		}
+	}
+
+	return openOutput(name, final)
+}
+
+// createStaged creates the output name under a temporary name, with the
+// -temp-suffix or .part, that is renamed to it at commit, e.g. over a
+// file that is only replaced if decryption succeeds.
+func createStaged(name string) (*outputFile, error) {
+	suffix := tempSuffix
+	if suffix == "" {
+		suffix = ".part"
+	}
+	if _, err := os.Lstat(name + suffix); err == nil {
+		return nil, fmt.Errorf("%s already exists", name+suffix)
+	}
+
+	return openOutput(name+suffix, name)
+}
+
+func openOutput(name, final string) (*outputFile, error) {
+	fd, err := openWait(name, os.Create)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &outputFile{File: fd, final: final}
+	if fi, err := fd.Stat(); err == nil {
+		o.regular = fi.Mode().IsRegular()
+	}
+	atExit(o.discard)
+
+	return o, nil
+}
+
+// commit closes the file, keeping it.
+func (o *outputFile) commit() error {
+	err := fmt.Errorf("Output: already discarded")
+	o.once.Do(func() {
+		err = o.Close()
+		if err == nil && o.final != "" {
+			if err = os.Rename(o.Name(), o.final); err != nil {
+				os.Remove(o.Name())
+			}
+		}
+	})
+
+	return err
+}
+
+// unverified has commit keep the file under its name with the
+// unverifiedSuffix, when quarantined, as its plain text was not integrity
+// protected.
+func (o *outputFile) unverified() {
+	if !quarantine || !o.regular {
+		return
+	}
+	name := o.final
+	if name == "" {
+		name = o.Name()
+	}
+	if o.final = name + unverifiedSuffix; o.final == o.Name() {
+		o.final = ""
+	}
+	warnf("keeping the plain text, which was not integrity protected, as %s", name+unverifiedSuffix)
+}
+
+// discard closes and removes the file, unless it was committed. In the
+// sandbox, which removes no files, it is emptied instead.
+func (o *outputFile) discard() {
+	o.once.Do(func() {
+		if sandboxed && o.regular {
+			if err := o.Truncate(0); err == nil {
+				cleanupf("Emptied partial output %s, which -sandbox cannot remove", o.Name())
+			}
+			o.Close()
+			return
+		}
+		o.Close()
+		if o.regular {
+			if err := os.Remove(o.Name()); err == nil {
+				cleanupf("Removed partial output %s", o.Name())
+			}
+		}
+	})
+}
+
+// lazyWriter opens its destination at the first write, so that the
+// outputs are not created, or truncated, until there is plain text to
+// go in them.
+type lazyWriter struct {
+	open func() io.Writer
+	w    io.Writer
+}
+
+func (lw *lazyWriter) Write(p []byte) (int, error) {
+	lw.ensure()
+	return lw.w.Write(p)
+}
+
+// ensure opens the destination if it has not been opened yet.
+func (lw *lazyWriter) ensure() {
+	if lw.w == nil {
+		lw.w = lw.open()
+	}
+}
+
+// syncDir flushes the directory dir to disk, so that a file renamed in
+// it keeps its new name after a crash. Where directories cannot be
+// synced, as on Windows, there is nothing to do.
+func syncDir(dir string) {
+	if fd, err := os.Open(dir); err == nil {
+		fd.Sync()
+		fd.Close()
+	}
+}
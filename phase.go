@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"runtime/pprof"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// setPhase labels the current goroutine with the pipeline phase it is
+// in, so that -cpuprofile output attributes time to phases. View them
+// with e.g. `go tool pprof -tagfocus phase=s2k`. It also records the
+// phase in the trace, with -otlp-endpoint.
+func setPhase(phase string) {
+	tracing.phase(phase)
+	if cpuprofile == "" {
+		return
+	}
+
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(),
+		pprof.Labels("phase", phase)))
+}
+
+// labelPhase is the symcrypt phase hook that keeps the labels current.
+func labelPhase(phase symcrypt.Phase) {
+	setPhase(string(phase))
+}
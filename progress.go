@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// startProgress logs, every -progress-interval until the returned
+// function is called, how far into the cipher text read through in
+// decryption has got, out of size bytes if that is known, and how fast
+// it went since the last time. It only does when the log is not read at
+// a terminal, to give long runs from cron evidence in their logs that
+// they are alive, or where they got stuck. One of the progressSignals,
+// such as SIGINFO from Ctrl-T on macOS and the BSDs, logs it at once,
+// wherever the log goes.
+func startProgress(in *countingReader, size int64) func() {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if progressEvery > 0 && (logFile != "" || logTarget != logStderr || !term.IsTerminal(int(os.Stderr.Fd()))) {
+		ticker = time.NewTicker(progressEvery)
+		tick = ticker.C
+	}
+	var sig chan os.Signal
+	if len(progressSignals) > 0 {
+		sig = make(chan os.Signal, 1)
+		signal.Notify(sig, progressSignals...)
+	}
+	if ticker == nil && sig == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if ticker != nil {
+			defer ticker.Stop()
+		}
+		start := time.Now()
+		var last int64
+		lastTime := start
+		for {
+			var now time.Time
+			select {
+			case <-done:
+				return
+			case now = <-tick:
+			case <-sig:
+				now = time.Now()
+			}
+			n := in.count()
+			rate := float64(n-last) / now.Sub(lastTime).Seconds()
+			last, lastTime = n, now
+
+			of := ""
+			if size > 0 {
+				of = fmt.Sprintf(" of %s, %.1f%%", humanBytes(float64(size)), 100*float64(n)/float64(size))
+			}
+			log.Printf("Progress: offset=%d (%s%s) throughput=%s/s elapsed=%v",
+				n, humanBytes(float64(n)), of, humanBytes(rate), now.Sub(start).Round(time.Second))
+		}
+	}()
+
+	return func() {
+		if sig != nil {
+			signal.Stop(sig)
+		}
+		close(done)
+		<-stopped
+	}
+}
+
+// inputSize returns the size of the input fd if it is a regular file,
+// or -1.
+func inputSize(fd io.Reader) int64 {
+	if f, ok := fd.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+
+	return -1
+}
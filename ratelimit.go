@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// rateReader limits reads from r to an average of rate bytes per
+// second, using a token bucket holding up to one second's worth.
+type rateReader struct {
+	r    io.Reader
+	rate int64
+
+	tokens int64
+	last   time.Time
+}
+
+func newRateReader(r io.Reader, rate int64) *rateReader {
+	return &rateReader{r: r, rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (rr *rateReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > rr.rate {
+		p = p[:rr.rate]
+	}
+
+	now := time.Now()
+	rr.tokens += int64(now.Sub(rr.last).Seconds() * float64(rr.rate))
+	if rr.tokens > rr.rate {
+		rr.tokens = rr.rate
+	}
+	rr.last = now
+
+	if short := int64(len(p)) - rr.tokens; short > 0 {
+		time.Sleep(time.Duration(float64(short) / float64(rr.rate) *
+			float64(time.Second)))
+		rr.tokens += short
+		rr.last = time.Now()
+	}
+
+	n, err := rr.r.Read(p)
+	rr.tokens -= int64(n)
+
+	return n, err
+}
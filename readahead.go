@@ -0,0 +1,77 @@
+package main
+
+import "io"
+
+// readAheadReader reads from r in a goroutine of its own, into one
+// buffer while the other is being decrypted, so that on storage with
+// high latency, e.g. a network file system, the next read is under way
+// rather than the CPU waiting for it.
+type readAheadReader struct {
+	full chan readAheadChunk
+	free chan []byte
+	done chan struct{}
+
+	chunk readAheadChunk
+	rest  []byte
+}
+
+type readAheadChunk struct {
+	buf []byte
+	n   int
+	err error
+}
+
+// newReadAheadReader starts reading r in chunks of size. Close stops
+// it, once a read that is under way returns.
+func newReadAheadReader(r io.Reader, size int) *readAheadReader {
+	ra := &readAheadReader{
+		full: make(chan readAheadChunk, 2),
+		free: make(chan []byte, 2),
+		done: make(chan struct{}),
+	}
+	ra.free <- make([]byte, size)
+	ra.free <- make([]byte, size)
+
+	go func() {
+		for {
+			var buf []byte
+			select {
+			case buf = <-ra.free:
+			case <-ra.done:
+				return
+			}
+
+			n, err := r.Read(buf)
+			ra.full <- readAheadChunk{buf: buf, n: n, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return ra
+}
+
+func (ra *readAheadReader) Read(p []byte) (int, error) {
+	for len(ra.rest) == 0 {
+		if ra.chunk.err != nil {
+			return 0, ra.chunk.err
+		}
+		if ra.chunk.buf != nil {
+			ra.free <- ra.chunk.buf
+		}
+		ra.chunk = <-ra.full
+		ra.rest = ra.chunk.buf[:ra.chunk.n]
+	}
+
+	n := copy(p, ra.rest)
+	ra.rest = ra.rest[n:]
+
+	return n, nil
+}
+
+// Close stops the reading. It does not close r.
+func (ra *readAheadReader) Close() error {
+	close(ra.done)
+	return nil
+}
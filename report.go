@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// verbosef logs, with -v only.
+func verbosef(format string, args ...interface{}) {
+	if verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// reportDecryption logs, with -v, how the message was decrypted.
+func reportDecryption(res *symcrypt.Result) {
+	switch {
+	case !res.Encrypted:
+		verbosef("The message was not encrypted")
+	case res.DecryptedWith != nil && res.DecryptedByAgent:
+		verbosef("Decrypted with secret key %X, held by gpg-agent", res.DecryptedWith.PublicKey.Fingerprint)
+	case res.DecryptedWith != nil:
+		verbosef("Decrypted with secret key %X", res.DecryptedWith.PublicKey.Fingerprint)
+	default:
+		verbosef("Decrypted with the passphrase, which matched session key %d of %d",
+			res.SKESKIndex+1, res.SKESKCount)
+		verbosef("S2K: %v", res.S2K)
+		if len(res.PublicKeyIDs) != 0 {
+			verbosef("Skipped the session keys for public keys %s", keyIDs(res.PublicKeyIDs))
+		}
+	}
+	if res.Encrypted {
+		verbosef("Session cipher: %v", res.Cipher)
+	}
+}
+
+// reportSignature logs, with -v, what the signature says about itself.
+func reportSignature(res *symcrypt.Result) {
+	if !res.SignatureTime.IsZero() {
+		verbosef("Signature made %v", res.SignatureTime.UTC().Format(time.RFC3339))
+	}
+	if res.SignatureLifetime != 0 {
+		verbosef("Signature expires %v",
+			res.SignatureTime.Add(res.SignatureLifetime).UTC().Format(time.RFC3339))
+	}
+	if res.SignerUserID != "" {
+		verbosef("Signer's user ID: %q", res.SignerUserID)
+	}
+	for _, n := range res.Notations {
+		if v, text := notationValue(n); text {
+			verbosef("Signature notation: %s=%q", n.Name, v)
+		} else {
+			verbosef("Signature notation: %s=%s (hex)", n.Name, v)
+		}
+	}
+}
+
+// notationValue returns the value of a notation as text, if it is
+// marked as such and is valid UTF-8, or else in hex.
+func notationValue(n symcrypt.Notation) (v string, text bool) {
+	if n.HumanReadable && utf8.Valid(n.Value) {
+		return string(n.Value), true
+	}
+
+	return hex.EncodeToString(n.Value), false
+}
+
+// reportUnverified logs the signer of a signature that could not be
+// verified, so that it is not mistaken for an unsigned message.
+func reportUnverified(res *symcrypt.Result) {
+	signer := fmt.Sprintf("key ID %016X", res.SignerKeyID)
+	if res.SignerFingerprint != nil {
+		signer = fmt.Sprintf("key %X", res.SignerFingerprint)
+	}
+	algo := res.SignerAlgo.String()
+	if res.SignerHash != 0 {
+		algo += "/" + res.SignerHash.String()
+	}
+
+	if keyringFile == "" {
+		log.Printf("Signature present, unverified: %s, %s; -keyring with the signer's public key verifies it",
+			signer, algo)
+	} else {
+		log.Printf("Signature present, unverified: %s, %s, is not in the key ring", signer, algo)
+	}
+}
+
+// reportArmorChecksum warns that the armor checksum did not match,
+// with -ignore-armor-crc, naming the lines that look corrupted.
+func reportArmorChecksum(res *symcrypt.Result) {
+	lines := "no line looks corrupted by its length or characters"
+	if len(res.ArmorSuspectLines) != 0 {
+		s := make([]string, len(res.ArmorSuspectLines))
+		for i, l := range res.ArmorSuspectLines {
+			s[i] = strconv.Itoa(l)
+		}
+		lines = "suspect lines: " + strings.Join(s, ", ")
+	}
+
+	if res.Integrity {
+		warnf("the armor checksum did not match (%s), but the MDC did", lines)
+	} else {
+		warnf("the armor checksum did not match (%s), and there was no MDC", lines)
+	}
+}
+
+// reportNoDecompress tells, with -no-decompress, what the output is.
+func reportNoDecompress(res *symcrypt.Result) {
+	if res.NotDecompressed {
+		log.Printf("Wrote the %s compressed data as it is, without decompressing it",
+			compressionName(res.Compression))
+	} else {
+		log.Printf("The message was not compressed, so the plain text was written")
+	}
+}
+
+// compressionName names a compression algorithm, RFC 4880 section 9.3.
+func compressionName(algo packet.CompressionAlgo) string {
+	switch algo {
+	case packet.CompressionNone:
+		return "uncompressed"
+	case packet.CompressionZIP:
+		return "ZIP (raw deflate)"
+	case packet.CompressionZLIB:
+		return "ZLIB"
+	case 3:
+		return "BZip2"
+	}
+
+	return fmt.Sprintf("algorithm %d", algo)
+}
+
+// keyIDs formats OpenPGP key IDs for messages.
+func keyIDs(ids []uint64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprintf("%016X", id)
+	}
+
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// lookupRunAs resolves -run-as, user or user:group by name or number,
+// to the ids to switch to. The group defaults to the user's primary
+// group.
+func lookupRunAs(s string) (uid, gid int, err error) {
+	name, group, hasGroup := strings.Cut(s, ":")
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %s", name)
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, fmt.Errorf("user %s: non-numeric uid %s", name, u.Uid)
+	}
+
+	gidStr := u.Gid
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, fmt.Errorf("unknown group %s", group)
+			}
+		}
+		gidStr = g.Gid
+	}
+	if gid, err = strconv.Atoi(gidStr); err != nil {
+		return 0, 0, fmt.Errorf("group %s: non-numeric gid %s", group, gidStr)
+	}
+
+	return uid, gid, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func dropPrivileges(uid, gid int) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// dropPrivileges switches every thread to uid and gid, with no
+// supplementary groups, for good.
+func dropPrivileges(uid, gid int) error {
+	if os.Geteuid() != 0 {
+		return errors.New("only root can switch users")
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+
+	return syscall.Setuid(uid)
+}
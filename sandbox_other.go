@@ -0,0 +1,10 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import "errors"
+
+// enterSandbox is only implemented for Linux on amd64 and arm64.
+func enterSandbox() error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// The format of scrypt(1), Colin Percival's scrypt enc, version 0: a
+// header of
+//
+//	magic       6 bytes, "scrypt"
+//	version     1 byte, 0
+//	log2 N      1 byte
+//	r           4 bytes, big-endian
+//	p           4 bytes, big-endian
+//	salt        32 bytes
+//	checksum    16 bytes: the start of SHA-256 of what precedes it
+//	signature   32 bytes: HMAC-SHA256 of what precedes it
+//
+// then the plain text in AES-256-CTR from a zero counter, and
+// HMAC-SHA256 of all that precedes it. The first half of the 64 bytes
+// that scrypt derives from the passphrase and the salt, with N, r and
+// p, is the AES key, the second the HMAC key: the signature tells a
+// wrong passphrase at once, but the data is only authenticated at its
+// end.
+const (
+	scryptHeader = 96
+
+	// Largest scrypt memory, 128*r*N bytes, and work, that times p,
+	// accepted from a file, so that one cannot make decryption
+	// allocate any amount or take any time. scrypt enc itself uses up
+	// to 1 GiB with p = 1.
+	maxScryptMemory = 1 << 30
+	maxScryptWork   = 8 << 30
+)
+
+var scryptMagic = []byte("scrypt")
+
+var (
+	// The header's signature does not match: most likely a wrong
+	// passphrase
+	errScryptKey = errors.New("scrypt: the header's HMAC does not match")
+	// The data's does not: it is corrupt or was tampered with
+	errScryptCorrupt = errors.New("scrypt: authentication failed")
+)
+
+// scryptKeys derives the AES and HMAC keys from pass with the
+// parameters in the header hdr.
+func scryptKeys(pass, hdr []byte) ([]byte, []byte, error) {
+	if hdr[6] != 0 {
+		return nil, nil, fmt.Errorf("scrypt: unknown version %d", hdr[6])
+	}
+	if sum := sha256.Sum256(hdr[:48]); !bytes.Equal(sum[:16], hdr[48:64]) {
+		return nil, nil, errors.New("scrypt: the header's checksum does not match: it is corrupt")
+	}
+	logN := uint(hdr[7])
+	r := uint64(binary.BigEndian.Uint32(hdr[8:12]))
+	p := uint64(binary.BigEndian.Uint32(hdr[12:16]))
+	if logN < 1 || logN >= 30 || r == 0 || p == 0 {
+		return nil, nil, fmt.Errorf("scrypt: bad parameters log2 N=%d r=%d p=%d", logN, r, p)
+	}
+	if r > maxScryptMemory/128>>logN {
+		return nil, nil, fmt.Errorf("scrypt: log2 N=%d r=%d takes more than the %d MiB allowed",
+			logN, r, maxScryptMemory>>20)
+	}
+	if 128*r<<logN*p > maxScryptWork {
+		return nil, nil, fmt.Errorf("scrypt: log2 N=%d r=%d p=%d is more work than allowed", logN, r, p)
+	}
+
+	dk, err := scrypt.Key(pass, hdr[16:48], 1<<logN, int(r), int(p), 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("scrypt: %v", err)
+	}
+
+	return dk[:32], dk[32:], nil
+}
+
+// decryptScrypt decrypts the scrypt enc file r with pass to w. As the
+// HMAC of the data comes at its end, what precedes the last chunk is
+// written before it has been authenticated, as with an MDC.
+func decryptScrypt(w io.Writer, r io.Reader, pass []byte) error {
+	hdr := make([]byte, scryptHeader)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("scrypt: short header: %w", err)
+	}
+	encKey, macKey, err := scryptKeys(pass, hdr)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(hdr[:64])
+	if !hmac.Equal(mac.Sum(nil), hdr[64:]) {
+		return errScryptKey
+	}
+	mac.Reset()
+	mac.Write(hdr)
+	block, _ := aes.NewCipher(encKey)
+	ctr := cipher.NewCTR(block, make([]byte, aes.BlockSize))
+
+	// The HMAC is held back at the end of what is peeked at
+	const chunk = 32 << 10
+	br := bufio.NewReaderSize(r, chunk+sha256.Size)
+	buf := make([]byte, chunk)
+	for {
+		head, err := br.Peek(chunk + sha256.Size)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		n := len(head) - sha256.Size
+		if n < 0 {
+			return fmt.Errorf("%w: the file ends before the HMAC of the data: truncated?", errScryptCorrupt)
+		}
+		mac.Write(head[:n])
+		ctr.XORKeyStream(buf[:n], head[:n])
+		if err == io.EOF {
+			if !hmac.Equal(mac.Sum(nil), head[n:]) {
+				return fmt.Errorf("%w: the HMAC of the data does not match: it is corrupt or cut short",
+					errScryptCorrupt)
+			}
+			_, err := w.Write(buf[:n])
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		br.Discard(n)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The secretbox format is a minimal container for those who want a
+// modern AEAD and little format to trust. After the magic come:
+//
+//	version     1 byte, 1
+//	time        4 bytes, big-endian: Argon2id passes
+//	memory      4 bytes, big-endian: Argon2id memory, in KiB
+//	threads     1 byte: Argon2id lanes
+//	salt        16 bytes
+//	nonce       16 bytes
+//
+// The key is Argon2id of the passphrase and salt, with those
+// parameters, so that changing any of the header makes it wrong. The
+// plain text follows in chunks of secretboxChunk bytes, the last of
+// which may be shorter or empty, each sealed with NaCl secretbox,
+// XSalsa20-Poly1305, under the 16-byte nonce followed by the chunk's
+// number as 8 big-endian bytes, with the top bit set for the last one:
+// a chunk that is dropped, moved or cut short, or any data after the
+// last one, fails authentication.
+const (
+	secretboxVersion = 1
+	secretboxHeader  = 1 + 4 + 4 + 1 + 16 + 16
+	secretboxChunk   = 64 << 10
+
+	// RFC 9106's second recommended option
+	secretboxTime    = 3
+	secretboxMemory  = 64 << 10
+	secretboxThreads = 4
+
+	// Largest Argon2id passes and memory, in KiB, accepted from a
+	// file, so that one cannot make decryption take any time or
+	// allocate any amount: as far as s2k-calibrate goes
+	maxSecretboxTime   = symcrypt.MaxArgon2Time
+	maxSecretboxMemory = symcrypt.MaxArgon2Memory
+
+	secretboxLast = 1 << 63
+)
+
+var secretboxMagic = []byte("DSSBOX")
+
+// argon2Params are the Argon2id parameters of a secretbox file.
+type argon2Params symcrypt.Argon2Params
+
+var defaultArgon2 = argon2Params{Time: secretboxTime, Memory: secretboxMemory, Threads: secretboxThreads}
+
+// check returns an error unless decryption accepts p.
+func (p argon2Params) check() error {
+	switch {
+	case p.Time == 0 || p.Threads == 0 || p.Memory < 8*uint32(p.Threads):
+		return fmt.Errorf("secretbox: bad Argon2id parameters t=%d m=%d p=%d", p.Time, p.Memory, p.Threads)
+	case p.Memory > maxSecretboxMemory:
+		return fmt.Errorf("secretbox: Argon2id memory of %d KiB is more than the %d allowed",
+			p.Memory, maxSecretboxMemory)
+	case p.Time > maxSecretboxTime:
+		return fmt.Errorf("secretbox: %d Argon2id passes are more than the %d allowed",
+			p.Time, maxSecretboxTime)
+	}
+
+	return nil
+}
+
+// key derives the key from pass and salt.
+func (p argon2Params) key(pass, salt []byte) []byte {
+	return argon2.IDKey(pass, salt, p.Time, p.Memory, p.Threads, 32)
+}
+
+var (
+	// The first chunk did not authenticate: most likely a wrong
+	// passphrase
+	errSecretboxKey = errors.New("secretbox: the first chunk does not authenticate")
+	// A later one did not: the data is corrupt or was tampered with
+	errSecretboxCorrupt = errors.New("secretbox: authentication failed")
+)
+
+// secretboxKey derives the key from pass with the parameters in the
+// header hdr, which follows the magic.
+func secretboxKey(pass []byte, hdr []byte) (*[32]byte, error) {
+	if hdr[0] != secretboxVersion {
+		return nil, fmt.Errorf("secretbox: unknown version %d", hdr[0])
+	}
+	p := argon2Params{Time: binary.BigEndian.Uint32(hdr[1:5]), Memory: binary.BigEndian.Uint32(hdr[5:9]), Threads: hdr[9]}
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	var key [32]byte
+	copy(key[:], p.key(pass, hdr[10:26]))
+
+	return &key, nil
+}
+
+// secretboxNonce returns the nonce of chunk n, of the header hdr.
+func secretboxNonce(hdr []byte, n uint64, last bool) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], hdr[26:42])
+	if last {
+		n |= secretboxLast
+	}
+	binary.BigEndian.PutUint64(nonce[16:], n)
+
+	return &nonce
+}
+
+// decryptSecretbox decrypts the secretbox file r with pass to w, a
+// chunk at a time, each once it has been authenticated.
+func decryptSecretbox(w io.Writer, r io.Reader, pass []byte) error {
+	hdr := make([]byte, len(secretboxMagic)+secretboxHeader)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("secretbox: short header: %w", err)
+	}
+	hdr = hdr[len(secretboxMagic):]
+	key, err := secretboxKey(pass, hdr)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	sealed := make([]byte, secretboxChunk+secretbox.Overhead)
+	plain := make([]byte, 0, secretboxChunk)
+	for n := uint64(0); ; n++ {
+		m, err := io.ReadFull(br, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			_, err := br.Peek(1)
+			last = err == io.EOF
+		}
+
+		out, ok := secretbox.Open(plain[:0], sealed[:m], secretboxNonce(hdr, n, last), key)
+		// A whole chunk that opens as one with more to follow: the file
+		// was cut off after it
+		if !ok && last && m == len(sealed) {
+			if _, cut := secretbox.Open(plain[:0], sealed, secretboxNonce(hdr, n, false), key); cut {
+				return fmt.Errorf("%w: the file ends after chunk %d, before the last one: truncated?",
+					errSecretboxCorrupt, n)
+			}
+		}
+		switch {
+		case !ok && n == 0:
+			return errSecretboxKey
+		case !ok:
+			return fmt.Errorf("%w: chunk %d, at byte %d of the plain text, is corrupt, out of place or cut short",
+				errSecretboxCorrupt, n, n*secretboxChunk)
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// secretboxWriter encrypts what is written to it in the secretbox
+// format to w. Close seals the last chunk; it does not close w.
+type secretboxWriter struct {
+	w    io.Writer
+	hdr  []byte
+	key  *[32]byte
+	n    uint64
+	held []byte
+	out  []byte
+}
+
+func newSecretboxWriter(w io.Writer, pass []byte, p argon2Params) (*secretboxWriter, error) {
+	hdr := make([]byte, secretboxHeader)
+	hdr[0] = secretboxVersion
+	binary.BigEndian.PutUint32(hdr[1:5], p.Time)
+	binary.BigEndian.PutUint32(hdr[5:9], p.Memory)
+	hdr[9] = p.Threads
+	if _, err := rand.Read(hdr[10:42]); err != nil {
+		return nil, err
+	}
+	key, err := secretboxKey(pass, hdr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte{}, secretboxMagic...), hdr...)); err != nil {
+		return nil, err
+	}
+
+	return &secretboxWriter{w: w, hdr: hdr, key: key,
+		held: make([]byte, 0, secretboxChunk), out: make([]byte, 0, secretboxChunk+secretbox.Overhead)}, nil
+}
+
+// Write holds a full chunk back until more follows, as only then is it
+// known not to be the last.
+func (sw *secretboxWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if len(sw.held) == secretboxChunk {
+			if err := sw.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		k := copy(sw.held[len(sw.held):secretboxChunk], p)
+		sw.held = sw.held[:len(sw.held)+k]
+		p = p[k:]
+	}
+
+	return written, nil
+}
+
+func (sw *secretboxWriter) seal(last bool) error {
+	out := secretbox.Seal(sw.out[:0], sw.held, secretboxNonce(sw.hdr, sw.n, last), sw.key)
+	sw.n++
+	sw.held = sw.held[:0]
+	_, err := sw.w.Write(out)
+
+	return err
+}
+
+func (sw *secretboxWriter) Close() error {
+	return sw.seal(true)
+}
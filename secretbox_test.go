@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArgon2ParamsCheck(t *testing.T) {
+	for _, tt := range []struct {
+		p  argon2Params
+		ok bool
+	}{
+		{defaultArgon2, true},
+		{argon2Params{Time: 1, Memory: 8, Threads: 1}, true},
+		{argon2Params{Time: maxSecretboxTime, Memory: maxSecretboxMemory, Threads: 255}, true},
+		{argon2Params{Time: 0, Memory: 64, Threads: 1}, false},
+		{argon2Params{Time: 1, Memory: 64, Threads: 0}, false},
+		{argon2Params{Time: 1, Memory: 8*4 - 1, Threads: 4}, false},
+		{argon2Params{Time: maxSecretboxTime + 1, Memory: 64, Threads: 1}, false},
+		{argon2Params{Time: 1, Memory: maxSecretboxMemory + 1, Threads: 1}, false},
+	} {
+		if err := tt.p.check(); (err == nil) != tt.ok {
+			t.Errorf("%+v: got %v, want ok %v", tt.p, err, tt.ok)
+		}
+	}
+}
+
+// The parameters a file was written with are the ones it is decrypted
+// with, read from its header.
+func TestSecretboxArgon2Params(t *testing.T) {
+	pass := []byte(selftestPassphrase)
+	plaintext := bytes.Repeat([]byte(selftestPlaintext), 3000)
+	p := argon2Params{Time: 2, Memory: 1 << 10, Threads: 2}
+
+	var buf bytes.Buffer
+	w, err := newSecretboxWriter(&buf, pass, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := decryptSecretbox(&out, bytes.NewReader(buf.Bytes()), pass); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("plain text of %d bytes, want %d", out.Len(), len(plaintext))
+	}
+
+	// The passes are part of the key
+	buf.Bytes()[len(secretboxMagic)+4]++
+	if err := decryptSecretbox(&out, bytes.NewReader(buf.Bytes()), pass); err != errSecretboxKey {
+		t.Errorf("with the passes changed: got %v, want errSecretboxKey", err)
+	}
+}
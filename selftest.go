@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// The known-answer vectors were produced with GnuPG 2.2 by
+// symmetrically encrypting selftestPlaintext with selftestPassphrase.
+//
+//go:embed vectors
+var vectors embed.FS
+
+const (
+	selftestPassphrase = "selftest"
+	selftestPlaintext  = "The quick brown fox jumps over the lazy dog.\n"
+)
+
+var selftestVectors = []struct {
+	file string
+	// The error that decryption is expected to fail with, if any
+	fail error
+	// Whether the vector has no MDC, so must be allowed to lack one
+	noMDC bool
+}{
+	{file: "aes128-simple.gpg"},
+	{file: "aes192-salted.gpg"},
+	{file: "aes256-iterated.gpg"},
+	{file: "aes256-nomdc.gpg", noMDC: true},
+	{file: "cast5-iterated.asc"},
+	{file: "3des-nomdc.asc", noMDC: true},
+	// One bit of the cipher text flipped, so the MDC must not match
+	{file: "tampered-mdc.gpg", fail: symcrypt.ErrIntegrityFailed},
+}
+
+func init() {
+	subcommands["selftest"] = func(args []string) int {
+		fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric selftest")
+		}
+		configureSubcommand(fs, "selftest", args)
+		if fs.NArg() != 0 {
+			fs.Usage()
+			return exitUsage
+		}
+
+		return selftest(os.Stdout)
+	}
+}
+
+// selftest decrypts the embedded vectors, reports the result of each
+// on w and returns the process exit status.
+func selftest(w io.Writer) int {
+	status := 0
+
+	for _, v := range selftestVectors {
+		err := selftestOne(v.file, v.noMDC)
+		switch {
+		case err == nil && v.fail != nil:
+			fmt.Fprintf(w, "FAIL %s: decrypted without error\n", v.file)
+			status = 1
+		case err != nil && !errors.Is(err, v.fail):
+			fmt.Fprintf(w, "FAIL %s: %v\n", v.file, err)
+			status = 1
+		default:
+			fmt.Fprintf(w, "PASS %s\n", v.file)
+		}
+	}
+	reportHardware(w)
+
+	return status
+}
+
+func selftestOne(file string, noMDC bool) error {
+	fd, err := vectors.Open("vectors/" + file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	policy := symcrypt.IntegrityRequired
+	if noMDC {
+		policy = symcrypt.IntegrityOptional
+	}
+	d := symcrypt.NewDecryptor(
+		symcrypt.WithPassphrase([]byte(selftestPassphrase)),
+		symcrypt.WithIntegrityPolicy(policy))
+
+	var out bytes.Buffer
+	res, err := d.Decrypt(&out, fd)
+	if err != nil {
+		return err
+	}
+	if res.Integrity == noMDC {
+		return fmt.Errorf("Unexpected integrity protection %v", res.Integrity)
+	}
+	if out.String() != selftestPlaintext {
+		return fmt.Errorf("Unexpected plain text %q", out.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// The signals that log how far decryption has got: SIGINFO, which the
+// terminal sends for Ctrl-T here, and SIGUSR1, as on other systems
+var progressSignals = []os.Signal{syscall.SIGINFO, syscall.SIGUSR1}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// There is no signal here that can ask for the stacks without ending
+// the operation.
+var dumpSignals []os.Signal
+
+// Nor one to ask how far decryption has got.
+var progressSignals []os.Signal
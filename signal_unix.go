@@ -0,0 +1,12 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// The signals that print the stacks of all goroutines and let the
+// operation go on
+var dumpSignals = []os.Signal{syscall.SIGQUIT, syscall.SIGUSR2}
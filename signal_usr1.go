@@ -0,0 +1,12 @@
+//go:build unix && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// The signal that logs how far decryption has got, as SIGUSR1 makes dd
+// report its progress; there is no SIGINFO
+var progressSignals = []os.Signal{syscall.SIGUSR1}
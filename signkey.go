@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// readSigningKey reads the secret key to sign with from the key file
+// called name, which may be ASCII armored, unlocking it, if it is
+// locked, with the -key-passphrase source or by prompting.
+func readSigningKey(name string) (*openpgp.Entity, error) {
+	kr, err := readKeyRing(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var e *openpgp.Entity
+	for _, candidate := range kr {
+		if candidate.PrivateKey != nil {
+			e = candidate
+			break
+		}
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%s: no secret key", name)
+	}
+
+	locked := lockedKeys(e)
+	if len(locked) == 0 {
+		return e, nil
+	}
+	if keyPass, ok := keyPassphraseProvider(); ok {
+		pass, err := keyPass.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if err := unlockKeys(locked, pass); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	prompt := promptPassphrase(fmt.Sprintf("Passphrase for signing key %X: ",
+		e.PrimaryKey.Fingerprint))
+	for attempt := 0; ; attempt++ {
+		pass, err := prompt.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		err = unlockKeys(locked, pass)
+		if err == nil {
+			return e, nil
+		}
+		if attempt == 2 {
+			return nil, err
+		}
+		log.Printf("%v, try again", err)
+	}
+}
+
+// lockedKeys returns the private keys of e that are encrypted.
+func lockedKeys(e *openpgp.Entity) []*packet.PrivateKey {
+	var locked []*packet.PrivateKey
+	if e.PrivateKey.Encrypted {
+		locked = append(locked, e.PrivateKey)
+	}
+	for _, sub := range e.Subkeys {
+		if sub.PrivateKey != nil && sub.PrivateKey.Encrypted {
+			locked = append(locked, sub.PrivateKey)
+		}
+	}
+
+	return locked
+}
+
+// unlockKeys decrypts the private keys with pass.
+func unlockKeys(keys []*packet.PrivateKey, pass []byte) error {
+	for _, k := range keys {
+		if err := k.Decrypt(pass); err != nil {
+			return errors.New("Wrong passphrase for the signing key")
+		}
+	}
+
+	return nil
+}
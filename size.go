@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag.Value for sizes such as 512K, 64M or 2G. The
+// suffixes are powers of 1024.
+type byteSize int64
+
+var sizeSuffixes = []struct {
+	suffix string
+	factor int64
+}{
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+}
+
+func (s *byteSize) String() string {
+	for i := len(sizeSuffixes) - 1; i >= 0; i-- {
+		f := sizeSuffixes[i].factor
+		if *s != 0 && int64(*s)%f == 0 {
+			return fmt.Sprintf("%d%s", int64(*s)/f, sizeSuffixes[i].suffix)
+		}
+	}
+
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(v string) error {
+	n, err := parseSize(v)
+	if err != nil {
+		return err
+	}
+
+	*s = byteSize(n)
+	return nil
+}
+
+func parseSize(v string) (int64, error) {
+	u := strings.ToUpper(strings.TrimSuffix(strings.TrimSuffix(v, "B"), "b"))
+	factor := int64(1)
+	for _, s := range sizeSuffixes {
+		if strings.HasSuffix(u, s.suffix) {
+			u = strings.TrimSuffix(u, s.suffix)
+			factor = s.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(u, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+
+	return n * factor, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// spool holds plain text until it has been verified: in memory up to
+// limit bytes, and in a temporary file beyond that.
+type spool struct {
+	limit int64
+	mem   bytes.Buffer
+	file  *os.File
+
+	once sync.Once
+}
+
+func newSpool(limit int64) *spool {
+	s := &spool{limit: limit}
+	atExit(s.Close)
+
+	return s
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && int64(s.mem.Len()+len(p)) <= s.limit {
+		return s.mem.Write(p)
+	}
+
+	if s.file == nil {
+		log.Println("Plain text exceeds -max-memory, spooling to a temporary file")
+		if err := s.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	return s.file.Write(p)
+}
+
+// spill moves the spool to a temporary file, from now on.
+func (s *spool) spill() error {
+	if s.file != nil {
+		return nil
+	}
+
+	fd, err := os.CreateTemp("", "decrypt-symmetric-spool-*")
+	if err != nil {
+		return err
+	}
+	s.file = fd
+
+	if _, err := s.mem.WriteTo(fd); err != nil {
+		return err
+	}
+	s.mem = bytes.Buffer{}
+
+	return nil
+}
+
+// WriteTo copies everything written to the spool to w.
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	if s.file == nil {
+		return s.mem.WriteTo(w)
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.CopyBuffer(w, s.file, make([]byte, copyBufferSize()))
+}
+
+// Close removes the temporary file, if any.
+func (s *spool) Close() {
+	s.once.Do(func() {
+		if s.file != nil {
+			s.file.Close()
+			os.Remove(s.file.Name())
+		}
+	})
+}
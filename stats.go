@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(&cr.n, int64(n))
+	return n, err
+}
+
+// count returns the bytes read so far, while reads may be going on.
+func (cr *countingReader) count() int64 {
+	return atomic.LoadInt64(&cr.n)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// logStats reports the -stats summary for a run that read in bytes of
+// cipher text and produced out bytes of plain text.
+func logStats(in, out int64, start time.Time) {
+	wall := time.Since(start)
+
+	ratio := 0.0
+	if in > 0 {
+		ratio = float64(out) / float64(in)
+	}
+	throughput := 0.0
+	if wall > 0 {
+		throughput = float64(in) / wall.Seconds()
+	}
+
+	log.Printf("Stats: in=%d out=%d ratio=%.3f wall=%v cpu=%v throughput=%s/s",
+		in, out, ratio, wall.Round(time.Millisecond),
+		cpuTime().Round(time.Millisecond), humanBytes(throughput))
+}
+
+// statsPhases are the phases that -stats -v breaks the time down by, in
+// the order they are listed.
+var statsPhases = []symcrypt.Phase{
+	symcrypt.PhaseRead, symcrypt.PhaseArmor, symcrypt.PhaseParse, symcrypt.PhasePassphrase,
+	symcrypt.PhaseS2K, symcrypt.PhaseDecrypt, symcrypt.PhaseDecompress, symcrypt.PhaseWrite,
+}
+
+// logPhaseTimes reports, for -stats -v, how the time decrypting the
+// message went on its phases, so that a slow run can be told to be
+// waiting on storage (read, write), on the S2K or on the CPU (armor,
+// decrypt, decompress). Time in the -output's writes is in write.
+func logPhaseTimes(res *symcrypt.Result) {
+	var total time.Duration
+	for _, t := range res.PhaseTimes {
+		total += t
+	}
+	if total <= 0 {
+		return
+	}
+
+	var b strings.Builder
+	for _, p := range statsPhases {
+		t, ok := res.PhaseTimes[p]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, " %s=%v (%.1f%%)", p, t.Round(time.Microsecond), 100*float64(t)/float64(total))
+	}
+	log.Printf("Stats: phases%s", b.String())
+}
+
+// humanBytes formats n bytes with a binary unit, e.g. 12.3MiB.
+func humanBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+
+	return strconv.FormatFloat(n, 'f', 1, 64) + units[i]
+}
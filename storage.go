@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Inputs and outputs are named by a path, - for stdin or stdout, or a
+// URL whose scheme has a transport registered here. Each transport
+// registers itself from an init function in its own file, and every
+// command that opens inputs or outputs by name gains it.
+var (
+	sources = make(map[string]func(u *url.URL) (io.ReadCloser, error))
+	sinks   = make(map[string]func(u *url.URL) (sink, error))
+)
+
+// A sink is an output that is only kept if decryption succeeds.
+type sink interface {
+	io.Writer
+	// commit finishes the output, keeping it.
+	commit() error
+	// discard abandons the output, removing what it can of it.
+	discard()
+}
+
+func init() {
+	sources["file"] = func(u *url.URL) (io.ReadCloser, error) {
+		return openWait(u.Path, os.Open)
+	}
+	sinks["file"] = func(u *url.URL) (sink, error) {
+		return createOutput(u.Path)
+	}
+}
+
+// parseURL returns the URL that name is, if it is one; not a path.
+func parseURL(name string) (*url.URL, bool) {
+	if !strings.Contains(name, "://") {
+		return nil, false
+	}
+
+	u, err := url.Parse(name)
+	return u, err == nil
+}
+
+// openSource opens the input called name.
+func openSource(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+
+	u, ok := parseURL(name)
+	if !ok {
+		return openWait(name, os.Open)
+	}
+	open, ok := sources[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("%s: cannot read from %s URLs", name, u.Scheme)
+	}
+
+	return open(u)
+}
+
+// openSink creates the output called name.
+func openSink(name string) (sink, error) {
+	if name == "-" {
+		return stdoutSink{os.Stdout}, nil
+	}
+
+	u, ok := parseURL(name)
+	if !ok {
+		return createOutput(name)
+	}
+	create, ok := sinks[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("%s: cannot write to %s URLs", name, u.Scheme)
+	}
+
+	return create(u)
+}
+
+// stdoutSink cannot take back what it has written.
+type stdoutSink struct {
+	io.Writer
+}
+
+func (stdoutSink) commit() error {
+	return nil
+}
+
+func (stdoutSink) discard() {
+}
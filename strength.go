@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Below this many bits, an interactively entered encryption passphrase
+// draws a warning: symmetric OpenPGP is only as strong as it is.
+const weakPassphraseBits = 50
+
+// commonPassphrases are guessed first by any cracker, most common
+// first, lower case.
+var commonPassphrases = strings.Fields(`
+	password 123456 12345678 qwerty 123456789 12345 1234 111111 1234567
+	dragon 123123 baseball abc123 football monkey letmein shadow master
+	696969 mustang 666666 qwertyuiop 123321 1234567890 superman
+	654321 1qaz2wsx 7777777 121212 000000 qazwsx 123qwe killer trustno1
+	jordan jennifer zxcvbnm asdfgh hunter buster soccer harley batman
+	andrew tigger sunshine iloveyou 2000 charlie robert thomas
+	hockey ranger daniel starwars klaster 112233 george computer
+	michelle jessica pepper 1111 zxcvbn 555555 11111111 131313 freedom
+	777777 pass maggie 159753 aaaaaa ginger princess joshua cheese amanda
+	summer love ashley 6969 nicole chelsea matthew access yankees
+	987654321 dallas austin thunder taylor matrix secret admin welcome
+	login passw0rd abc letmein1 changeme default backup archive
+	correct horse battery staple hello world
+	god sex money life house family friend summer winter spring autumn
+	apple orange banana water fire earth sky blue red green black white
+	`)
+
+var commonRank = func() map[string]int {
+	m := make(map[string]int)
+	for i, w := range commonPassphrases {
+		if _, ok := m[w]; !ok {
+			m[w] = i + 1
+		}
+	}
+	return m
+}()
+
+// Rows of a QWERTY keyboard, for runs like "asdf"
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+// l33t substitutions undone before looking words up
+var unl33t = strings.NewReplacer("4", "a", "@", "a", "8", "b", "3", "e",
+	"6", "g", "1", "i", "!", "i", "0", "o", "5", "s", "$", "s", "7", "t",
+	"+", "t", "2", "z")
+
+// passphraseBits estimates how many bits of guessing it takes to find
+// p, in the manner of zxcvbn: p is split into the cheapest sequence of
+// guessable patterns, i.e. common words, keyboard and alphabet runs,
+// repeats and years, with anything left over brute forced over the
+// character classes that p uses.
+func passphraseBits(p string) float64 {
+	r := []rune(p)
+	if len(r) == 0 {
+		return 0
+	}
+	perChar := math.Log2(float64(charsetSize(r)))
+
+	// best[i] is the cheapest estimate for r[:i].
+	best := make([]float64, len(r)+1)
+	for i := 1; i <= len(r); i++ {
+		best[i] = best[i-1] + perChar
+		for j := 0; j < i; j++ {
+			if bits, ok := patternBits(r[j:i]); ok {
+				best[i] = math.Min(best[i], best[j]+bits)
+			}
+		}
+	}
+
+	return best[len(r)]
+}
+
+// charsetSize is the size of the alphabet a brute force search over
+// the character classes in r has to cover.
+func charsetSize(r []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range r {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < 0x80:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	for _, class := range []struct {
+		in   bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.in {
+			n += class.size
+		}
+	}
+
+	return n
+}
+
+// patternBits returns the cost of s if it is a single guessable
+// pattern.
+func patternBits(s []rune) (float64, bool) {
+	str := string(s)
+	lower := strings.ToLower(str)
+
+	// Words, l33t spelled or reversed. Capitals cost a bit.
+	if len(s) >= 3 {
+		caps := 0.0
+		if lower != str {
+			caps = 1
+		}
+		for _, w := range []struct {
+			word  string
+			extra float64
+		}{
+			{lower, 0},
+			{unl33t.Replace(lower), 1},
+			{reverse(lower), 1},
+		} {
+			if rank, ok := commonRank[w.word]; ok {
+				return math.Log2(float64(rank)) + 1 + caps + w.extra, true
+			}
+		}
+	}
+
+	if len(s) < 3 {
+		return 0, false
+	}
+
+	// One character, repeated
+	if strings.Count(str, string(s[0])) == len(s) {
+		return math.Log2(float64(charsetSize(s[:1]) * len(s))), true
+	}
+
+	// Runs through the alphabet, the digits or a keyboard row, either
+	// way
+	if isSequence(s) || isKeyboardRun(lower) {
+		return math.Log2(float64(26 * 2 * len(s))), true
+	}
+
+	// Years
+	if len(s) == 4 && (strings.HasPrefix(str, "19") || strings.HasPrefix(str, "20")) &&
+		unicode.IsDigit(s[2]) && unicode.IsDigit(s[3]) {
+		return math.Log2(200), true
+	}
+
+	return 0, false
+}
+
+func isSequence(s []rune) bool {
+	d := s[1] - s[0]
+	if d != 1 && d != -1 {
+		return false
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i]-s[i-1] != d {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isKeyboardRun(s string) bool {
+	for _, row := range keyboardRows {
+		if strings.Contains(row, s) || strings.Contains(reverse(row), s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+
+	return string(r)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// logSummary logs, at the end of a decryption, what gpg -d tells about
+// a message: how it was encrypted, whether it was integrity protected,
+// who signed it and what the file was called.
+func logSummary(res *symcrypt.Result) {
+	switch {
+	case !res.Encrypted:
+		log.Printf("Summary: not encrypted")
+	case res.DecryptedWith != nil:
+		log.Printf("Summary: %v encrypted, to secret key %X", res.Cipher,
+			res.DecryptedWith.PublicKey.Fingerprint)
+	default:
+		log.Printf("Summary: %v encrypted, with a passphrase (%v)", res.Cipher, res.S2K)
+	}
+
+	if res.Integrity {
+		log.Printf("Summary: integrity protected, MDC verified")
+	} else {
+		log.Printf("Summary: NOT integrity protected")
+	}
+
+	switch {
+	case !res.Signed:
+		log.Printf("Summary: not signed")
+	case res.SignedBy != nil:
+		log.Printf("Summary: good signature from key %X%s",
+			res.SignedBy.PublicKey.Fingerprint, summarySignedAt(res))
+		if res.SignerLookedUp {
+			log.Printf("Summary:     (key fetched from %s, NOT trusted)", signerSource)
+		}
+		if e := res.SignedBy.Entity; e != nil {
+			for name := range e.Identities {
+				log.Printf("Summary:     %q", name)
+			}
+		}
+	default:
+		log.Printf("Summary: signed by key ID %016X%s, NOT verified", res.SignerKeyID,
+			summarySignedAt(res))
+	}
+
+	if res.FileName != "" {
+		modified := ""
+		if !res.ModTime.IsZero() {
+			modified = ", modified " + res.ModTime.UTC().Format(time.RFC3339)
+		}
+		log.Printf("Summary: original file name %q%s", res.FileName, modified)
+	}
+}
+
+func summarySignedAt(res *symcrypt.Result) string {
+	if res.SignatureTime.IsZero() {
+		return ""
+	}
+
+	return fmt.Sprintf(", made %v", res.SignatureTime.UTC().Format(time.RFC3339))
+}
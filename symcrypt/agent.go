@@ -0,0 +1,400 @@
+package symcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// AgentPassphrase asks gpg-agent for the passphrase, which lets the
+// agent show its pinentry and cache the passphrase under cacheID, as
+// gpg does for symmetric encryption. desc is shown in the pinentry.
+// When the passphrase is forgotten, it is cleared from the agent's
+// cache.
+//
+// The agent is found, and started if need be, with gpgconf, and reached
+// over its Unix domain socket with the Assuan protocol.
+func AgentPassphrase(cacheID, desc string) PassphraseProvider {
+	return &agent{cacheID: cacheID, desc: desc}
+}
+
+// AgentNewPassphrase asks gpg-agent for a passphrase that is being
+// chosen, e.g. to encrypt with. The pinentry asks for it twice, and it
+// is not cached.
+func AgentNewPassphrase(desc string) PassphraseProvider {
+	return &agent{desc: desc, repeat: true}
+}
+
+// AgentCachedPassphrase is like AgentPassphrase, but only returns a
+// passphrase the agent has cached under cacheID: it never shows the
+// pinentry, and fails if there is no such passphrase, so that it cannot
+// block waiting for a user who is not there.
+func AgentCachedPassphrase(cacheID string) PassphraseProvider {
+	return &agent{cacheID: cacheID, noAsk: true}
+}
+
+// AgentVersion returns the version of the gpg-agent that AgentPassphrase
+// asks, starting it if need be, or why it cannot be reached.
+func AgentVersion() (string, error) {
+	conn, err := dialAgent()
+	if err != nil {
+		return "", err
+	}
+	defer conn.close()
+
+	data, err := conn.command("GETINFO version")
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+type agent struct {
+	cacheID string
+	desc    string
+	repeat  bool
+	noAsk   bool
+}
+
+func (a *agent) Passphrase() ([]byte, error) {
+	conn, err := dialAgent()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+	if err := conn.setTTY(); err != nil {
+		return nil, err
+	}
+
+	opts := "--data"
+	if a.repeat {
+		opts += " --repeat=1"
+	}
+	if a.noAsk {
+		opts += " --no-ask"
+	}
+	data, err := conn.command(fmt.Sprintf("GET_PASSPHRASE %s %s X Passphrase: %s",
+		opts, assuanEscape(a.cacheID), assuanEscape(a.desc)))
+	if err != nil && a.noAsk {
+		return nil, fmt.Errorf("symcrypt: gpg-agent has no passphrase cached: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (a *agent) Forget() {
+	if a.cacheID == "" {
+		return
+	}
+	conn, err := dialAgent()
+	if err != nil {
+		return
+	}
+	defer conn.close()
+
+	conn.command("CLEAR_PASSPHRASE " + assuanEscape(a.cacheID))
+}
+
+type assuanConn struct {
+	c net.Conn
+	r *bufio.Reader
+	// What to answer INQUIREs with, by keyword
+	inquiries map[string][]byte
+	// The status lines of the last response, by keyword
+	status map[string]string
+}
+
+func dialAgent() (*assuanConn, error) {
+	out, err := exec.Command("gpgconf", "--list-dirs", "agent-socket").Output()
+	if err != nil {
+		return nil, fmt.Errorf("symcrypt: gpg-agent: gpgconf: %w", err)
+	}
+
+	socket := strings.TrimSpace(string(out))
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		// Started on demand, as gpg does
+		if exec.Command("gpgconf", "--launch", "gpg-agent").Run() == nil {
+			c, err = net.Dial("unix", socket)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("symcrypt: gpg-agent: %w", err)
+		}
+	}
+
+	conn := &assuanConn{c: c, r: bufio.NewReader(c)}
+	// The agent greets us with OK
+	if _, err := conn.response(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// setTTY tells the agent our terminal, so that a curses pinentry can
+// find it, as gpg does.
+func (conn *assuanConn) setTTY() error {
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		if _, err := conn.command("OPTION ttyname=" + tty); err != nil {
+			return err
+		}
+	}
+	if t := os.Getenv("TERM"); t != "" {
+		if _, err := conn.command("OPTION ttytype=" + t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (conn *assuanConn) close() {
+	fmt.Fprint(conn.c, "BYE\n")
+	conn.c.Close()
+}
+
+// command sends cmd and returns the data of the response.
+func (conn *assuanConn) command(cmd string) ([]byte, error) {
+	if _, err := fmt.Fprintf(conn.c, "%s\n", cmd); err != nil {
+		return nil, fmt.Errorf("symcrypt: gpg-agent: %w", err)
+	}
+
+	return conn.response()
+}
+
+// response reads lines up to the OK or ERR that ends a response,
+// collecting the data lines.
+func (conn *assuanConn) response() ([]byte, error) {
+	var data []byte
+	conn.status = make(map[string]string)
+	for {
+		line, err := conn.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("symcrypt: gpg-agent: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, nil
+		case strings.HasPrefix(line, "ERR "):
+			return nil, fmt.Errorf("symcrypt: gpg-agent: %s", line[4:])
+		case strings.HasPrefix(line, "D "):
+			d, err := url.PathUnescape(line[2:])
+			if err != nil {
+				return nil, fmt.Errorf("symcrypt: gpg-agent: %w", err)
+			}
+			data = append(data, d...)
+		case strings.HasPrefix(line, "INQUIRE "):
+			keyword, _, _ := strings.Cut(line[8:], " ")
+			if d, ok := conn.inquiries[keyword]; ok {
+				conn.send(d)
+			} else {
+				fmt.Fprint(conn.c, "CAN\n")
+			}
+		case strings.HasPrefix(line, "S "):
+			keyword, args, _ := strings.Cut(line[2:], " ")
+			conn.status[keyword] = args
+		}
+		// Comment (#) lines are ignored
+	}
+}
+
+// send sends d as the data of an inquiry, in D lines short enough for
+// Assuan's 1000 byte limit.
+func (conn *assuanConn) send(d []byte) {
+	w := bufio.NewWriter(conn.c)
+	for len(d) > 0 {
+		n := min(len(d), 256)
+		w.WriteString("D ")
+		for _, c := range d[:n] {
+			if c == '%' || c == '\r' || c == '\n' {
+				fmt.Fprintf(w, "%%%02X", c)
+			} else {
+				w.WriteByte(c)
+			}
+		}
+		w.WriteByte('\n')
+		d = d[n:]
+	}
+	w.WriteString("END\n")
+	w.Flush()
+}
+
+// assuanEscape escapes s as an argument of a gpg-agent command, where
+// spaces are written as +.
+func assuanEscape(s string) string {
+	if s == "" {
+		return "X"
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			b.WriteByte('+')
+		case c < ' ' || c == '+' || c == '%' || c == 0x7f:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// agentPrivateKey returns the secret key of pub that gpg-agent holds,
+// in its own store or, through scdaemon, on a smartcard such as an
+// OpenPGP card or YubiKey, or nil if it holds none. The secret key
+// never leaves the agent or the card, which ask for its passphrase or
+// PIN with their pinentry: the session key is decrypted there. Only
+// RSA keys are supported, as they are by this package's OpenPGP.
+func agentPrivateKey(pub *packet.PublicKey, desc string) *packet.PrivateKey {
+	rsaPub, ok := pub.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil
+	}
+	// The keygrip by which the agent knows a key is, for RSA, the
+	// SHA-1 of the modulus as libgcrypt stores it
+	grip := sha1.Sum(sexpMPI(rsaPub.N.Bytes()))
+	k := &agentKey{pub: rsaPub, grip: fmt.Sprintf("%X", grip), desc: desc}
+
+	conn, err := dialAgent()
+	if err != nil {
+		return nil
+	}
+	defer conn.close()
+	if _, err := conn.command("HAVEKEY " + k.grip); err != nil {
+		return nil
+	}
+
+	return &packet.PrivateKey{PublicKey: *pub, PrivateKey: k}
+}
+
+// agentKey is an RSA secret key held by gpg-agent, as a crypto.Decrypter
+// of PKCS #1 v1.5 encrypted session keys.
+type agentKey struct {
+	pub  *rsa.PublicKey
+	grip string
+	desc string
+}
+
+// An agentError is an error from decrypting with an agentKey, rather
+// than a key that does not fit.
+type agentError struct {
+	err error
+}
+
+func (e agentError) Error() string { return e.err.Error() }
+func (e agentError) Unwrap() error { return e.err }
+
+func (k *agentKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+func (k *agentKey) Decrypt(_ io.Reader, ciphertext []byte, _ crypto.DecrypterOpts) ([]byte, error) {
+	frame, padded, err := k.pkdecrypt(ciphertext)
+	if err != nil {
+		return nil, agentError{err}
+	}
+
+	// The agent's own keys leave the PKCS #1 v1.5 padding, 0 2 PS 0,
+	// with the leading zero dropped; a card removes it.
+	if padded {
+		frame = bytes.TrimLeft(frame, "\x00")
+		i := bytes.IndexByte(frame, 0)
+		if len(frame) == 0 || frame[0] != 2 || i < 0 {
+			return nil, errors.New("symcrypt: gpg-agent: bad padding of the session key")
+		}
+		frame = frame[i+1:]
+	}
+	if len(frame) < 3 {
+		return nil, errors.New("symcrypt: gpg-agent: session key too short")
+	}
+
+	return frame, nil
+}
+
+// pkdecrypt has the agent decrypt ciphertext, and tells whether the
+// result is still padded.
+func (k *agentKey) pkdecrypt(ciphertext []byte) ([]byte, bool, error) {
+	conn, err := dialAgent()
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.close()
+	if err := conn.setTTY(); err != nil {
+		return nil, false, err
+	}
+
+	if _, err := conn.command("SETKEY " + k.grip); err != nil {
+		return nil, false, err
+	}
+	if k.desc != "" {
+		if _, err := conn.command("SETKEYDESC " + assuanEscape(k.desc)); err != nil {
+			return nil, false, err
+		}
+	}
+	a := sexpMPI(ciphertext)
+	conn.inquiries = map[string][]byte{
+		"CIPHERTEXT": fmt.Appendf(nil, "(7:enc-val(3:rsa(1:a%d:%s)))", len(a), a),
+	}
+	out, err := conn.command("PKDECRYPT")
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, err := sexpValue(out)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, conn.status["PADDING"] != "0", nil
+}
+
+// sexpMPI returns the big-endian unsigned integer b as libgcrypt writes
+// it in an S-expression: in two's complement, with a leading zero if
+// its top bit is set.
+func sexpMPI(b []byte) []byte {
+	b = bytes.TrimLeft(b, "\x00")
+	if len(b) != 0 && b[0]&0x80 != 0 {
+		return append([]byte{0}, b...)
+	}
+
+	return b
+}
+
+// sexpValue returns the value in (5:valueN:...), the canonical
+// S-expression PKDECRYPT answers with.
+func sexpValue(b []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(b, []byte("(5:value"))
+	colon := bytes.IndexByte(rest, ':')
+	if !ok || colon < 0 {
+		return nil, errors.New("symcrypt: gpg-agent: bad PKDECRYPT result")
+	}
+	n, err := strconv.Atoi(string(rest[:colon]))
+	rest = rest[colon+1:]
+	if err != nil || n < 0 || n >= len(rest) || rest[n] != ')' {
+		return nil, errors.New("symcrypt: gpg-agent: bad PKDECRYPT result")
+	}
+
+	return rest[:n], nil
+}
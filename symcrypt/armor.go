@@ -0,0 +1,392 @@
+package symcrypt
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var armorStart = []byte("-----BEGIN PGP ")
+
+const armorMessage = "PGP MESSAGE"
+
+// Lines named in an armor checksum error, at most
+const maxSuspectLines = 20
+
+// How much of the rest of an armored message is read, when its
+// decryption has failed, to see whether its checksum matches
+const maxArmorDrain = 64 << 20
+
+// armorBlock is an ASCII armored block, RFC 4880 section 6.2.
+type armorBlock struct {
+	Type   string
+	Header map[string]string
+	Body   io.Reader
+}
+
+// armorCheck is what the decoding of an armor block found wrong with
+// it, once its body has been read to the end.
+type armorCheck struct {
+	// Whether the checksum did not match, and which lines look like
+	// the cause, by their length or characters
+	crcMismatch bool
+	suspect     []int
+}
+
+// dearmor returns a reader for the binary message in br, which may be
+// ASCII armored, in which case the armor block is returned too. With
+// ignoreCRC, a checksum that does not match is recorded in check, if it
+// is not nil, rather than failing the read.
+func dearmor(br *bufio.Reader, ignoreCRC bool, check *armorCheck) (*bufio.Reader, *armorBlock, error) {
+	if head, _ := br.Peek(len(armorStart)); !Armored(head) {
+		return br, nil, nil
+	}
+
+	block, err := decodeArmor(br, ignoreCRC, check)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block.Type != armorMessage {
+		return nil, nil, fmt.Errorf("%w: armor: expected %q, got %q",
+			ErrInvalidMessage, armorMessage, block.Type)
+	}
+
+	return bufio.NewReader(block.Body), block, nil
+}
+
+// decodeArmor reads the armor header lines from br, which starts with
+// the BEGIN line, and returns the block, whose Body decodes the rest.
+func decodeArmor(br *bufio.Reader, ignoreCRC bool, check *armorCheck) (*armorBlock, error) {
+	ar := &armorReader{br: br, ignoreCRC: ignoreCRC, check: check, crc: crc24Init}
+	line, err := ar.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "-----BEGIN ") || !strings.HasSuffix(line, "-----") {
+		return nil, fmt.Errorf("%w: armor: bad BEGIN line", ErrInvalidMessage)
+	}
+	block := &armorBlock{
+		Type:   line[len("-----BEGIN ") : len(line)-len("-----")],
+		Header: make(map[string]string),
+		Body:   ar,
+	}
+	ar.end = "-----END " + block.Type + "-----"
+
+	// Headers, up to a blank line. Some encoders leave it out, and go
+	// straight on to the radix-64, which never has ": " in it.
+	for {
+		line, err := ar.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return block, nil
+		}
+		k, v, ok := strings.Cut(line, ": ")
+		if !ok {
+			ar.err = ar.decodeLine(line)
+			return block, nil
+		}
+		block.Header[k] = v
+	}
+}
+
+// armorReader decodes the radix-64 body of an armor block, checking
+// its CRC-24 at the end.
+type armorReader struct {
+	br        *bufio.Reader
+	ignoreCRC bool
+	check     *armorCheck
+	end       string
+
+	// Input line number, and the length of the first data line, which
+	// all but the last are expected to have
+	line    int
+	lineLen int
+	// The last data line that had another length, which is only
+	// suspect if another data line follows it
+	odd     int
+	suspect []int
+
+	quad   []byte
+	padded bool
+	buf    []byte
+	crc    uint32
+	err    error
+}
+
+func (ar *armorReader) Read(p []byte) (int, error) {
+	for len(ar.buf) == 0 && ar.err == nil {
+		ar.err = ar.next()
+	}
+	if len(ar.buf) != 0 {
+		n := copy(p, ar.buf)
+		ar.buf = ar.buf[n:]
+		return n, nil
+	}
+
+	return 0, ar.err
+}
+
+// readLine returns the next line, without its line ending or trailing
+// blanks.
+func (ar *armorReader) readLine() (string, error) {
+	line, err := ar.br.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: armor: no END line", ErrInvalidMessage)
+	}
+	ar.line++
+
+	return strings.TrimRight(line, " \t\r\n"), nil
+}
+
+// next decodes the next line of the body into buf.
+func (ar *armorReader) next() error {
+	line, err := ar.readLine()
+	if err != nil {
+		return err
+	}
+
+	return ar.decodeLine(line)
+}
+
+// decodeLine decodes a line of the body into buf, or checks the
+// checksum and returns io.EOF at the END line.
+func (ar *armorReader) decodeLine(line string) error {
+	switch {
+	case line == "":
+		return nil
+	case line == ar.end:
+		return ar.finish(nil)
+	case strings.HasPrefix(line, "=") && len(line) == 5:
+		crc, err := base64.StdEncoding.DecodeString(line[1:])
+		if err != nil || len(crc) != 3 {
+			return fmt.Errorf("%w: armor: line %d: bad checksum line", ErrInvalidMessage, ar.line)
+		}
+		end, err := ar.readLine()
+		for err == nil && end == "" {
+			end, err = ar.readLine()
+		}
+		if err != nil {
+			return err
+		}
+		if end != ar.end {
+			return fmt.Errorf("%w: armor: line %d: expected %q after the checksum",
+				ErrInvalidMessage, ar.line, ar.end)
+		}
+		return ar.finish(crc)
+	case strings.HasPrefix(line, "-----END "):
+		return fmt.Errorf("%w: armor: line %d: expected %q", ErrInvalidMessage, ar.line, ar.end)
+	}
+
+	if ar.odd != 0 {
+		ar.addSuspect(ar.odd)
+		ar.odd = 0
+	}
+	switch {
+	case ar.lineLen == 0:
+		ar.lineLen = len(line)
+	case len(line) > ar.lineLen:
+		ar.addSuspect(ar.line)
+	case len(line) < ar.lineLen:
+		ar.odd = ar.line
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if !isRadix64(c) {
+			if !ar.ignoreCRC {
+				return fmt.Errorf("%w: armor: line %d looks corrupted: bad character %q at column %d",
+					ErrInvalidMessage, ar.line, c, i+1)
+			}
+			// Dropped, for the MDC to judge what is left
+			ar.addSuspect(ar.line)
+			continue
+		}
+		if ar.padded {
+			return fmt.Errorf("%w: armor: line %d: radix-64 after the padding", ErrInvalidMessage, ar.line)
+		}
+		ar.quad = append(ar.quad, c)
+		if len(ar.quad) < 4 {
+			continue
+		}
+
+		var b [3]byte
+		n, err := base64.StdEncoding.Decode(b[:], ar.quad)
+		if err != nil {
+			return fmt.Errorf("%w: armor: line %d looks corrupted: %v", ErrInvalidMessage, ar.line, err)
+		}
+		ar.padded = n < 3
+		ar.crc = crc24(ar.crc, b[:n])
+		ar.buf = append(ar.buf, b[:n]...)
+		ar.quad = ar.quad[:0]
+	}
+
+	return nil
+}
+
+func (ar *armorReader) addSuspect(line int) {
+	if n := len(ar.suspect); n < maxSuspectLines && (n == 0 || ar.suspect[n-1] != line) {
+		ar.suspect = append(ar.suspect, line)
+	}
+}
+
+// finish checks the checksum, if there is one, at the end of the body.
+func (ar *armorReader) finish(crc []byte) error {
+	if len(ar.quad) != 0 {
+		return fmt.Errorf("%w: armor: line %d: truncated radix-64", ErrInvalidMessage, ar.line)
+	}
+	if crc == nil {
+		// It is optional, RFC 9580 section 6.1
+		return io.EOF
+	}
+
+	sum := uint32(crc[0])<<16 | uint32(crc[1])<<8 | uint32(crc[2])
+	if sum == ar.crc&0xffffff {
+		return io.EOF
+	}
+	if ar.ignoreCRC {
+		if ar.check != nil {
+			ar.check.crcMismatch = true
+			ar.check.suspect = ar.suspect
+		}
+		return io.EOF
+	}
+
+	if len(ar.suspect) == 0 {
+		return fmt.Errorf("%w: no line looks corrupted by its length or characters", ErrArmorChecksum)
+	}
+	lines := make([]string, len(ar.suspect))
+	for i, l := range ar.suspect {
+		lines[i] = strconv.Itoa(l)
+	}
+	if len(lines) == 1 {
+		return fmt.Errorf("%w: line %s looks corrupted", ErrArmorChecksum, lines[0])
+	}
+	return fmt.Errorf("%w: lines %s look corrupted", ErrArmorChecksum, strings.Join(lines, ", "))
+}
+
+func isRadix64(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+		c == '+' || c == '/' || c == '='
+}
+
+// CRC-24, RFC 4880 section 6.1
+const (
+	crc24Init = 0xb704ce
+	crc24Poly = 0x1864cfb
+)
+
+func crc24(crc uint32, b []byte) uint32 {
+	for _, c := range b {
+		crc ^= uint32(c) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+
+	return crc
+}
+
+// ArmorHeader is a header line of ASCII armor, e.g. Comment.
+type ArmorHeader struct {
+	Key, Value string
+}
+
+// NewArmorWriter returns a writer that ASCII armors what is written to
+// it as a PGP MESSAGE block, with the headers in the order given, and
+// writes it to w. Close must be called to write the checksum and the
+// END line; it does not close w.
+func NewArmorWriter(w io.Writer, headers ...ArmorHeader) (io.WriteCloser, error) {
+	var b strings.Builder
+	b.WriteString("-----BEGIN " + armorMessage + "-----\n")
+	for _, h := range headers {
+		if strings.ContainsAny(h.Key, ":\r\n") || h.Key == "" || strings.ContainsAny(h.Value, "\r\n") {
+			return nil, fmt.Errorf("symcrypt: bad armor header %q: %q", h.Key, h.Value)
+		}
+		b.WriteString(h.Key + ": " + h.Value + "\n")
+	}
+	b.WriteString("\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return nil, err
+	}
+
+	aw := &armorWriter{w: w, crc: crc24Init}
+	aw.lines = &lineBreaker{w: w}
+	aw.b64 = base64.NewEncoder(base64.StdEncoding, aw.lines)
+
+	return aw, nil
+}
+
+type armorWriter struct {
+	w     io.Writer
+	lines *lineBreaker
+	b64   io.WriteCloser
+	crc   uint32
+}
+
+func (aw *armorWriter) Write(p []byte) (int, error) {
+	aw.crc = crc24(aw.crc, p)
+	return aw.b64.Write(p)
+}
+
+func (aw *armorWriter) Close() error {
+	if err := aw.b64.Close(); err != nil {
+		return err
+	}
+	if err := aw.lines.Close(); err != nil {
+		return err
+	}
+
+	crc := []byte{byte(aw.crc >> 16), byte(aw.crc >> 8), byte(aw.crc)}
+	_, err := io.WriteString(aw.w, "="+base64.StdEncoding.EncodeToString(crc)+
+		"\n-----END "+armorMessage+"-----\n")
+	return err
+}
+
+// Radix-64 line length, as gpg writes it
+const armorLineLength = 64
+
+// lineBreaker writes radix-64 to w in lines of armorLineLength.
+type lineBreaker struct {
+	w   io.Writer
+	col int
+	buf []byte
+}
+
+func (lb *lineBreaker) Write(p []byte) (int, error) {
+	lb.buf = lb.buf[:0]
+	for rest := p; len(rest) > 0; {
+		k := min(armorLineLength-lb.col, len(rest))
+		lb.buf = append(lb.buf, rest[:k]...)
+		rest = rest[k:]
+		lb.col += k
+		if lb.col == armorLineLength {
+			lb.buf = append(lb.buf, '\n')
+			lb.col = 0
+		}
+	}
+	if _, err := lb.w.Write(lb.buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close ends the last line.
+func (lb *lineBreaker) Close() error {
+	if lb.col == 0 {
+		return nil
+	}
+	_, err := lb.w.Write([]byte{'\n'})
+	return err
+}
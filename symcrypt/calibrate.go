@@ -0,0 +1,120 @@
+package symcrypt
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+// CalibrateS2K times the iterated and salted S2K with SHA-256, as
+// NewEncryptWriter uses it, on this machine, and returns the one with
+// the smallest count that takes at least target to derive a key, or
+// the largest count if none takes that long. Its Salt is empty, as
+// each message gets its own; pass its Count to WithS2KCount. This lets
+// a service pick the count for the machine it is deployed on rather
+// than hard-coding one.
+func CalibrateS2K(target time.Duration) (S2K, error) {
+	if target <= 0 {
+		return S2K{}, errors.New("symcrypt: S2K calibration target must be positive")
+	}
+
+	// Derivation time is linear in the count, so the rate taken from
+	// the largest count gives the rest.
+	counts := S2KCounts()
+	max := counts[len(counts)-1]
+	perByte := float64(TimeS2K(max)) / float64(max)
+	best := max
+	for _, c := range counts {
+		if time.Duration(float64(c)*perByte) >= target {
+			best = c
+			break
+		}
+	}
+
+	return S2K{Mode: S2KIterated, Hash: crypto.SHA256, Count: best}, nil
+}
+
+// S2KCounts returns the byte counts that the iterated S2K can encode,
+// RFC 4880 section 3.7.1.3, in increasing order.
+func S2KCounts() []int {
+	counts := make([]int, 256)
+	for c := range counts {
+		counts[c] = (16 + c&15) << (uint(c>>4) + 6)
+	}
+
+	return counts
+}
+
+// TimeS2K times deriving an AES-256 key with the iterated and salted
+// S2K, with SHA-256, over count bytes.
+func TimeS2K(count int) time.Duration {
+	key := make([]byte, 32)
+	salt := make([]byte, 8)
+	start := time.Now()
+	s2k.Iterated(key, crypto.SHA256.New(), []byte("calibrate"), salt, count)
+
+	return time.Since(start)
+}
+
+// Argon2Params are the parameters of Argon2id, RFC 9106: passes over
+// Memory KiB, in Threads lanes. This package reads and writes no
+// message that uses Argon2, which needs the RFC 9580 format; these are
+// for containers of the embedding service's own, such as the CLI's
+// secretbox format, to derive keys with argon2.IDKey.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// The most passes and memory, in KiB, that CalibrateArgon2 goes up to
+const (
+	MaxArgon2Time   = 64
+	MaxArgon2Memory = 1 << 20
+)
+
+// CalibrateArgon2 times Argon2id on this machine, and returns the
+// parameters, with the Threads of start and at least its Memory, that
+// take about target to derive a key: the fewest passes that take at
+// least that long, over as much more memory, doubled up to
+// MaxArgon2Memory, as it takes for MaxArgon2Time passes to, as RFC 9106
+// prefers memory to passes. Where even one pass is slower, it returns
+// one; where MaxArgon2Time passes over MaxArgon2Memory are faster,
+// those. The Time of start is ignored.
+func CalibrateArgon2(target time.Duration, start Argon2Params) (Argon2Params, error) {
+	switch {
+	case target <= 0:
+		return Argon2Params{}, errors.New("symcrypt: Argon2 calibration target must be positive")
+	case start.Threads == 0 || start.Memory < 8*uint32(start.Threads) || start.Memory > MaxArgon2Memory:
+		return Argon2Params{}, fmt.Errorf("symcrypt: bad Argon2id memory of %d KiB over %d lanes, at most %d KiB",
+			start.Memory, start.Threads, MaxArgon2Memory)
+	}
+
+	p := start
+	p.Time = 1
+	// Derivation time is about linear in passes and memory
+	pass := TimeArgon2(p)
+	for pass*MaxArgon2Time < target && p.Memory*2 <= MaxArgon2Memory {
+		p.Memory *= 2
+		pass = TimeArgon2(p)
+	}
+	p.Time = uint32(min(max((target+pass-1)/pass, 1), MaxArgon2Time))
+	// The first pass also fills the memory, so the estimate falls short
+	for p.Time < MaxArgon2Time && TimeArgon2(p) < target {
+		p.Time++
+	}
+
+	return p, nil
+}
+
+// TimeArgon2 times deriving a 32-byte key with Argon2id with p.
+func TimeArgon2(p Argon2Params) time.Duration {
+	start := time.Now()
+	argon2.IDKey([]byte("calibrate"), make([]byte, 16), p.Time, p.Memory, p.Threads, 32)
+
+	return time.Since(start)
+}
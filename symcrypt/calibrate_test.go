@@ -0,0 +1,43 @@
+package symcrypt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalibrateArgon2Refuses(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		target time.Duration
+		start  Argon2Params
+	}{
+		{"no target", 0, Argon2Params{Memory: 64, Threads: 1}},
+		{"no lanes", time.Millisecond, Argon2Params{Memory: 64}},
+		{"too little memory for the lanes", time.Millisecond, Argon2Params{Memory: 8*4 - 1, Threads: 4}},
+		{"too much memory", time.Millisecond, Argon2Params{Memory: MaxArgon2Memory + 1, Threads: 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, err := CalibrateArgon2(tt.target, tt.start); err == nil {
+				t.Errorf("got %+v, want an error", p)
+			}
+		})
+	}
+}
+
+func TestCalibrateArgon2(t *testing.T) {
+	start := Argon2Params{Time: 7, Memory: 64, Threads: 2}
+	target := 5 * time.Millisecond
+	p, err := CalibrateArgon2(target, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Threads != start.Threads || p.Memory < start.Memory || p.Memory > MaxArgon2Memory {
+		t.Errorf("got %+v, from %+v", p, start)
+	}
+	if p.Time < 1 || p.Time > MaxArgon2Time {
+		t.Errorf("got %d passes", p.Time)
+	}
+	if took := TimeArgon2(p); p.Time > 1 && took < target/4 {
+		t.Errorf("got %+v, which takes %v, for a target of %v", p, took, target)
+	}
+}
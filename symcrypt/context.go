@@ -0,0 +1,36 @@
+package symcrypt
+
+import (
+	"context"
+	"io"
+)
+
+// ctxReader stops reading from r once ctx is done, so that a cancelled
+// operation stops at the next chunk boundary.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+	if cr.ctx.Err() != nil {
+		return 0, context.Cause(cr.ctx)
+	}
+
+	return cr.r.Read(p)
+}
+
+// ctxWriter likewise stops writing to w, e.g. while a small amount of
+// cipher text decompresses to a large amount of plain text.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw ctxWriter) Write(p []byte) (int, error) {
+	if cw.ctx.Err() != nil {
+		return 0, context.Cause(cw.ctx)
+	}
+
+	return cw.w.Write(p)
+}
@@ -0,0 +1,937 @@
+package symcrypt
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/zlib"
+	"context"
+	"crypto"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+// A Decryptor decrypts messages according to its options. It may be
+// used for any number of messages, but not concurrently unless its
+// passphrase and phase functions allow that.
+type Decryptor struct {
+	config
+}
+
+// NewDecryptor returns a Decryptor configured by opts.
+func NewDecryptor(opts ...Option) *Decryptor {
+	d := &Decryptor{}
+	for _, opt := range opts {
+		opt(&d.config)
+	}
+	d.config.applyFIPS()
+
+	return d
+}
+
+// Result describes a decrypted message.
+type Result struct {
+	// Whether the input was ASCII armored, and its armor headers
+	Armored      bool
+	ArmorHeaders map[string]string
+	// With WithIgnoreArmorChecksum, whether the armor checksum did not
+	// match, and the input lines that look corrupted, by their length
+	// or characters
+	ArmorChecksumMismatch bool
+	ArmorSuspectLines     []int
+
+	// Whether the message was encrypted at all
+	Encrypted bool
+	// The cipher of the session key that decrypted the message
+	Cipher Cipher
+	// The S2K of the matching symmetric-key encrypted session key
+	// packet, of which there were SKESKCount, and its index, or -1 if
+	// a key from the key ring decrypted the message
+	S2K        S2K
+	SKESKIndex int
+	SKESKCount int
+	// Key IDs of public-key encrypted session keys, and the key ring
+	// key that decrypted one of them, if any, and whether gpg-agent
+	// held its secret key, with WithAgentKeys
+	PublicKeyIDs     []uint64
+	DecryptedWith    *openpgp.Key
+	DecryptedByAgent bool
+
+	// Whether the plain text was integrity protected by an MDC, which
+	// matched
+	Integrity bool
+	// Compression algorithm (0 for none), and whether the compressed
+	// data was written as it is, with WithNoDecompress
+	Compression     packet.CompressionAlgo
+	NotDecompressed bool
+
+	// Literal data metadata
+	FileName string
+	ModTime  time.Time
+	Format   byte
+
+	// Whether the message was signed, and by whom. The signature is
+	// only verified if the signer's key is in the key ring, when it
+	// must match. The signer's fingerprint is only known if the
+	// signature has an issuer fingerprint subpacket.
+	Signed            bool
+	SignerKeyID       uint64
+	SignerFingerprint []byte
+	SignerAlgo        PublicKeyAlgo
+	SignerHash        crypto.Hash
+	// From the signature's hashed subpackets, if it has them. A zero
+	// SignatureLifetime is one that does not expire.
+	SignatureTime     time.Time
+	SignatureLifetime time.Duration
+	SignerUserID      string
+	Notations         []Notation
+	SignatureVerified bool
+	SignedBy          *openpgp.Key
+	// Whether SignedBy came from the WithSignerLookup function, rather
+	// than the key ring
+	SignerLookedUp bool
+
+	// Bytes of input read and of plain text written
+	BytesIn  int64
+	BytesOut int64
+	// Time spent in each phase: reading the input, decoding its armor,
+	// waiting for the passphrase, deriving keys from it, and so on
+	PhaseTimes map[Phase]time.Duration
+}
+
+// Decrypt decrypts the message in src, which may be binary or ASCII
+// armored, and writes its plain text to dst. The plain text is
+// written as it is decrypted, so if Decrypt fails, whatever has been
+// written to dst must be discarded. When it succeeds, the integrity
+// of the plain text has been checked according to the policy.
+func (d *Decryptor) Decrypt(dst io.Writer, src io.Reader) (Result, error) {
+	return d.DecryptContext(context.Background(), dst, src)
+}
+
+// DecryptContext is like Decrypt, but stops between chunks of input
+// and output once ctx is done, returning its cause. It does not
+// interrupt a passphrase provider, nor a read or write in progress.
+func (d *Decryptor) DecryptContext(ctx context.Context, dst io.Writer, src io.Reader) (Result, error) {
+	m := &message{config: &d.config}
+	err := m.decrypt(ctxWriter{ctx, dst}, ctxReader{ctx, src})
+
+	return m.res, err
+}
+
+// DecryptEach decrypts the messages in src, which may be several back
+// to back, binary or armored, as some appenders write them, until src
+// ends. Each is written to the writer that next returns for it, which
+// is passed the Results of those before it, and its Result is returned,
+// in order, up to the first that fails. It reads them with Messages,
+// whose passphrase and size limits apply.
+func (d *Decryptor) DecryptEach(ctx context.Context, src io.Reader, next func(prev []Result) (io.Writer, error)) ([]Result, error) {
+	mr := d.Messages(ctx, src)
+	defer mr.Close()
+
+	var results []Result
+	for {
+		res, plain, err := mr.NextMessage()
+		if err == io.EOF {
+			return results, nil
+		} else if err != nil {
+			return results, err
+		}
+		dst, err := next(results)
+		if err != nil {
+			return results, err
+		}
+		_, err = io.Copy(dst, plain)
+		if err != nil {
+			// For the decryption to have stopped, when dst failed
+			mr.Close()
+		}
+		results = append(results, *res)
+		if err != nil {
+			return results, err
+		}
+	}
+}
+
+// skipSpace reads past the white space at the start of br.
+func skipSpace(br *bufio.Reader) error {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return br.UnreadByte()
+		}
+	}
+}
+
+// message is the state of a single Decrypt call.
+type message struct {
+	*config
+	res      Result
+	curPhase Phase
+	// When the current phase was entered
+	phaseStart time.Time
+	// The one-pass signature being verified, with a key ring
+	sig *sigState
+	// Whether the signature that the Result describes has been seen
+	sigNoted bool
+	// The fingerprint of a key ring key that fit a session key but
+	// could not be unlocked
+	locked []byte
+}
+
+func (m *message) setPhase(p Phase) {
+	if p == m.curPhase {
+		return
+	}
+	m.endPhase()
+	if m.phase != nil {
+		m.phase(p)
+	}
+	m.curPhase = p
+}
+
+// endPhase adds the time spent in the current phase to the Result.
+func (m *message) endPhase() {
+	now := time.Now()
+	if m.curPhase != "" {
+		if m.res.PhaseTimes == nil {
+			m.res.PhaseTimes = make(map[Phase]time.Duration)
+		}
+		m.res.PhaseTimes[m.curPhase] += now.Sub(m.phaseStart)
+	}
+	m.phaseStart = now
+}
+
+func (m *message) decrypt(dst io.Writer, src io.Reader) error {
+	m.setPhase(PhaseParse)
+	defer m.endPhase()
+
+	in := &countReader{r: spanReader{src, m, PhaseRead}, max: m.maxCiphertext}
+	out := &countWriter{w: phaseWriter{dst, m}, max: m.maxPlaintext}
+	pr := newProgressReporter(m.config, func() Progress {
+		return Progress{BytesIn: in.n, BytesOut: out.n, Phase: m.curPhase}
+	})
+	in.pr, out.pr = pr, pr
+	defer func() {
+		m.res.BytesIn = in.n
+		m.res.BytesOut = out.n
+		pr.done()
+	}()
+
+	return m.decryptMessage(out, bufio.NewReader(in), false)
+}
+
+// decryptMessage decrypts the message at the start of r to out. With
+// next, the rest of an armored message is read too, checking its
+// checksum, for r to be left at what follows it.
+func (m *message) decryptMessage(out io.Writer, r *bufio.Reader, next bool) error {
+	var check armorCheck
+	br, block, err := dearmor(r, m.ignoreArmorCRC, &check)
+	if err != nil {
+		return err
+	}
+	if block != nil {
+		m.res.Armored = true
+		m.res.ArmorHeaders = block.Header
+		br = bufio.NewReader(spanReader{br, m, PhaseArmor})
+	} else if head, _ := br.Peek(16); len(head) != 0 && head[0]&0x80 == 0 {
+		// Not a packet tag byte, which has its high bit set
+		return fmt.Errorf("%w: it starts with %q", ErrNotOpenPGP, head)
+	}
+
+	plain, err := m.decryptPackets(br)
+	if err != nil {
+		return err
+	}
+
+	err = m.readPlaintext(out, plain, 0)
+	if err != nil && block != nil && !m.ignoreArmorCRC {
+		// Corrupt armor tends to show up as corrupt packets first,
+		// when the checksum at the end would say what is wrong.
+		if _, crcErr := io.Copy(io.Discard, io.LimitReader(br, maxArmorDrain)); errors.Is(crcErr, ErrArmorChecksum) {
+			err = fmt.Errorf("%w, which explains: %v", crcErr, err)
+		}
+	}
+	if err == nil && block != nil && next {
+		_, err = io.Copy(io.Discard, block.Body)
+	}
+	m.res.ArmorChecksumMismatch = check.crcMismatch
+	m.res.ArmorSuspectLines = check.suspect
+
+	return err
+}
+
+// skesk is a symmetric-key encrypted session key packet.
+type skesk struct {
+	cipher Cipher
+	s2k    S2K
+	// The encrypted session key, if not simply the S2K output
+	encryptedKey []byte
+}
+
+func parseSKESK(b []byte) (*skesk, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("%w: short SKESK packet", ErrInvalidMessage)
+	}
+	if b[0] != 4 {
+		if b[0] == 5 || b[0] == 6 {
+			return nil, fmt.Errorf("%w: SKESK version %d", ErrAEAD, b[0])
+		}
+		return nil, fmt.Errorf("%w SKESK version %d", ErrUnsupported, b[0])
+	}
+
+	s := &skesk{cipher: Cipher(b[1])}
+	var err error
+	s.s2k, b, err = parseS2K(b[2:])
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 0 {
+		s.encryptedKey = b
+	}
+
+	return s, nil
+}
+
+// sessionKey derives the session key from passphrase, with the
+// legacy ciphers usable or not.
+func (s *skesk) sessionKey(passphrase []byte, legacy bool) (Cipher, []byte, error) {
+	if !s.cipher.usable(legacy) {
+		return 0, nil, s.cipher.unsupported()
+	}
+
+	key := make([]byte, s.cipher.KeySize())
+	s.s2k.derive(key, passphrase)
+	if s.encryptedKey == nil {
+		return s.cipher, key, nil
+	}
+
+	// RFC 4880 section 5.3: the session key is encrypted in CFB
+	// mode with a zero IV, prefixed by its cipher.
+	block, err := s.cipher.newBlock(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	plain := make([]byte, len(s.encryptedKey))
+	iv := make([]byte, block.BlockSize())
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(plain, s.encryptedKey)
+
+	c := Cipher(plain[0])
+	if c.legacy() && !legacy && len(plain)-1 == c.KeySize() {
+		return 0, nil, c.unsupported()
+	}
+	if !c.usable(legacy) || len(plain)-1 != c.KeySize() {
+		// Almost certainly the wrong passphrase
+		return 0, nil, ErrWrongPassphrase
+	}
+
+	return c, plain[1:], nil
+}
+
+// decryptPackets reads the session key packets and decrypts the
+// encrypted data packet that follows them, returning its contents.
+func (m *message) decryptPackets(r *bufio.Reader) (io.Reader, error) {
+	var skesks []*skesk
+	var pkesks [][]byte
+	stream := m.packetStream(r, "message")
+
+	for {
+		tag, err := peekTag(r)
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no encrypted data", ErrInvalidMessage)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case tagCompressed, tagLiteral, tagOnePassSig, tagSignature:
+			if len(skesks) != 0 || len(m.res.PublicKeyIDs) != 0 {
+				return nil, fmt.Errorf("%w: session keys not followed by encrypted data",
+					ErrInvalidMessage)
+			}
+			if m.integrity == IntegrityRequired {
+				return nil, ErrNotEncrypted
+			}
+			return r, nil
+		}
+
+		_, body, err := m.readPacket(r, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case tagSKESK:
+			b, err := readBody(body, 1024)
+			if err != nil {
+				return nil, err
+			}
+			s, err := parseSKESK(b)
+			if err != nil {
+				return nil, err
+			}
+			skesks = append(skesks, s)
+
+		case tagPKESK:
+			b, err := readBody(body, 8192)
+			if err != nil {
+				return nil, err
+			}
+			if len(b) < 9 {
+				return nil, fmt.Errorf("%w: short PKESK packet", ErrInvalidMessage)
+			}
+			m.res.PublicKeyIDs = append(m.res.PublicKeyIDs,
+				binary.BigEndian.Uint64(b[1:9]))
+			pkesks = append(pkesks, b)
+
+		case tagSEIPD:
+			var version [1]byte
+			if _, err := io.ReadFull(body, version[:]); err != nil {
+				return nil, unexpected(err)
+			}
+			if version[0] == 2 {
+				return nil, fmt.Errorf("%w: encrypted data version 2", ErrAEAD)
+			}
+			if version[0] != 1 {
+				return nil, fmt.Errorf("%w encrypted data version %d",
+					ErrUnsupported, version[0])
+			}
+			return m.decryptData(body, skesks, pkesks, true)
+
+		case tagSE:
+			if m.integrity == IntegrityRequired {
+				return nil, ErrNoIntegrityProtection
+			}
+			return m.decryptData(body, skesks, pkesks, false)
+
+		case tagAEAD:
+			return nil, fmt.Errorf("%w: AEAD encrypted data packet", ErrAEAD)
+
+		default:
+			// Marker and unknown packets are ignored
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// decryptData decrypts body with a session key from the key ring, if
+// there is one, or else asks for the passphrase, as many times as it
+// is allowed to, until it decrypts body.
+func (m *message) decryptData(body io.Reader, skesks []*skesk, pkesks [][]byte, mdc bool) (io.Reader, error) {
+	if len(skesks) == 0 && len(pkesks) == 0 && !mdc {
+		// Encrypted data with no session key packet at all is PGP 2's
+		if !m.legacyCiphers {
+			return nil, fmt.Errorf("%w: no session key packet, as made by PGP 2 with IDEA", ErrLegacyCipher)
+		}
+		skesks = []*skesk{pgp2SKESK}
+	}
+
+	m.res.Encrypted = true
+	m.res.SKESKCount = len(skesks)
+	m.res.SKESKIndex = -1
+
+	br := bufio.NewReader(body)
+	if m.keyring != nil && len(pkesks) != 0 {
+		plain, err := m.tryKeyRing(br, pkesks, mdc)
+		if plain != nil || err != nil {
+			return plain, err
+		}
+	}
+
+	if len(skesks) == 0 && m.locked != nil {
+		return nil, fmt.Errorf("%w: key %X", ErrKeyLocked, m.locked)
+	}
+	if len(skesks) == 0 {
+		return nil, ErrNotPassphraseEncrypted
+	}
+	if m.passphrase == nil {
+		return nil, ErrNoPassphrase
+	}
+
+	for attempt := 0; ; attempt++ {
+		m.setPhase(PhasePassphrase)
+		passphrase, err := m.passphrase.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		m.setPhase(PhaseS2K)
+		plain, err := m.tryPassphrase(br, skesks, passphrase, mdc)
+		if err != ErrWrongPassphrase {
+			return plain, err
+		}
+		if f, ok := m.passphrase.(Forgetter); ok {
+			f.Forget()
+		}
+		if attempt >= m.retries {
+			return nil, err
+		}
+		m.setPhase(PhaseParse)
+	}
+}
+
+// tryPassphrase tries passphrase on each SKESK in turn, until one of
+// them yields a session key that decrypts the data in br.
+func (m *message) tryPassphrase(br *bufio.Reader, skesks []*skesk, passphrase []byte, mdc bool) (io.Reader, error) {
+	lastErr := ErrWrongPassphrase
+	for i, s := range skesks {
+		if err := m.checkFIPS(s.cipher, s.s2k.Hash); err != nil {
+			lastErr = err
+			continue
+		}
+		c, key, err := s.sessionKey(passphrase, m.legacyCiphers)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if m.ciphers != nil && !m.ciphers[c] {
+			lastErr = fmt.Errorf("%w: %v", ErrCipherNotAllowed, c)
+			continue
+		}
+		if err := m.checkFIPS(c, 0); err != nil {
+			lastErr = err
+			continue
+		}
+
+		plain, err := m.trySessionKey(br, c, key, mdc)
+		if err != nil {
+			return nil, err
+		}
+		if plain != nil {
+			m.res.S2K = s.s2k
+			m.res.SKESKIndex = i
+			return plain, nil
+		}
+	}
+
+	return nil, lastErr
+}
+
+// trySessionKey decrypts the data in br with the session key, if its
+// "quick check" bytes say it is the right one. Otherwise, it returns a
+// nil reader.
+func (m *message) trySessionKey(br *bufio.Reader, c Cipher, key []byte, mdc bool) (io.Reader, error) {
+	block, err := c.newBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	peeked, err := br.Peek(block.BlockSize() + 2)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+
+	resync := packet.OCFBResync
+	if mdc {
+		resync = packet.OCFBNoResync
+	}
+	// The decrypter checks the quick check bytes of the prefix, and
+	// decrypts it in place if they match.
+	prefix := append([]byte(nil), peeked...)
+	stream := packet.NewOCFBDecrypter(block, prefix, resync)
+	if stream == nil {
+		return nil, nil
+	}
+	br.Discard(len(prefix))
+
+	m.res.Cipher = c
+	m.setPhase(PhaseDecrypt)
+
+	plain := io.Reader(cipher.StreamReader{S: stream, R: br})
+	if mdc {
+		h := sha1.New()
+		h.Write(prefix)
+		plain = &mdcReader{r: plain, h: h, res: &m.res}
+	}
+
+	return plain, nil
+}
+
+// mdcTrailerSize is the size of the modification detection code
+// packet at the end of integrity protected data: tag, length and a
+// SHA-1 hash.
+const mdcTrailerSize = 2 + sha1.Size
+
+// mdcReader returns all but the MDC packet at the end of r, and checks
+// the MDC at EOF.
+type mdcReader struct {
+	r   io.Reader
+	h   hash.Hash
+	res *Result
+
+	buf  []byte
+	held int
+	err  error
+}
+
+func (mr *mdcReader) Read(p []byte) (int, error) {
+	if mr.err != nil {
+		return 0, mr.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if cap(mr.buf) < len(p)+mdcTrailerSize {
+		buf := make([]byte, len(p)+mdcTrailerSize)
+		copy(buf, mr.buf[:mr.held])
+		mr.buf = buf
+	}
+	mr.buf = mr.buf[:cap(mr.buf)]
+
+	n, err := mr.r.Read(mr.buf[mr.held : mr.held+len(p)])
+	mr.held += n
+
+	out := 0
+	if mr.held > mdcTrailerSize {
+		out = copy(p, mr.buf[:mr.held-mdcTrailerSize])
+		mr.h.Write(p[:out])
+		mr.held = copy(mr.buf, mr.buf[out:mr.held])
+	}
+
+	if err == io.EOF {
+		mr.err = mr.check()
+		return out, mr.err
+	}
+
+	return out, err
+}
+
+func (mr *mdcReader) check() error {
+	trailer := mr.buf[:mr.held]
+	if len(trailer) != mdcTrailerSize || trailer[0] != 0xd3 ||
+		trailer[1] != sha1.Size {
+		return fmt.Errorf("%w: missing MDC packet", ErrIntegrityFailed)
+	}
+
+	mr.h.Write(trailer[:2])
+	if subtle.ConstantTimeCompare(mr.h.Sum(nil), trailer[2:]) != 1 {
+		return ErrIntegrityFailed
+	}
+
+	mr.res.Integrity = true
+	return io.EOF
+}
+
+// defaultMaxNesting bounds how deeply compressed packets may be nested,
+// unless WithMaxNesting says otherwise.
+const defaultMaxNesting = 8
+
+// readPlaintext reads the packets inside the encrypted data, or inside
+// a compressed packet, and writes the contents of the literal data
+// packet to w.
+func (m *message) readPlaintext(w io.Writer, r io.Reader, depth int) error {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	name := "compressed data"
+	switch {
+	case depth == 0 && m.res.Encrypted:
+		name = "decrypted data"
+	case depth == 0:
+		name = "message"
+	}
+	stream := m.packetStream(br, name)
+
+	literal := false
+	for {
+		tag, body, err := m.readPacket(br, stream)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch tag {
+		case tagCompressed:
+			if limit := m.nestingLimit(); depth >= limit {
+				return fmt.Errorf("%w: compressed packets nested more than %d deep",
+					ErrInvalidMessage, limit)
+			}
+			if err := m.decompress(w, body, depth); err != nil {
+				return err
+			}
+			literal = true
+
+		case tagOnePassSig:
+			b, err := readBody(body, 64)
+			if err != nil {
+				return err
+			}
+			if len(b) != 13 {
+				return fmt.Errorf("%w: bad one-pass signature packet", ErrInvalidMessage)
+			}
+			m.res.Signed = true
+			m.res.SignerKeyID = binary.BigEndian.Uint64(b[4:12])
+			m.res.SignerAlgo = PublicKeyAlgo(b[3])
+			if h, ok := s2k.HashIdToHash(b[2]); ok {
+				m.res.SignerHash = h
+			}
+			if (m.keyring != nil || m.signerLookup != nil) && m.sig == nil {
+				m.sig = newSigState(b, m.fips)
+			}
+
+		case tagLiteral:
+			if literal {
+				return fmt.Errorf("%w: more than one literal data packet",
+					ErrInvalidMessage)
+			}
+			literal = true
+			if err := m.readLiteral(w, body); err != nil {
+				return err
+			}
+
+		case tagSignature:
+			b, err := readBody(body, 1<<16)
+			if err != nil {
+				return err
+			}
+			if m.res.Signed && !m.sigNoted {
+				m.sigNoted = true
+				m.noteSignature(b)
+			}
+			if m.sig != nil && !m.sig.done {
+				if err := m.verifySignature(b); err != nil {
+					return err
+				}
+			}
+
+		default:
+			// Markers
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !literal {
+		return fmt.Errorf("%w: no literal data", ErrInvalidMessage)
+	}
+
+	return nil
+}
+
+// nestingLimit is how deeply compressed packets may be nested.
+func (m *message) nestingLimit() int {
+	if m.maxNesting > 0 {
+		return m.maxNesting
+	}
+
+	return defaultMaxNesting
+}
+
+func (m *message) decompress(w io.Writer, body io.Reader, depth int) error {
+	var algo [1]byte
+	if _, err := io.ReadFull(body, algo[:]); err != nil {
+		return unexpected(err)
+	}
+	m.res.Compression = packet.CompressionAlgo(algo[0])
+	if m.noDecompress && m.res.Compression != packet.CompressionNone {
+		m.res.NotDecompressed = true
+		_, err := copyBuffer(w, body)
+		return err
+	}
+
+	in := phaseReader{body, m}
+	var r io.Reader
+	switch m.res.Compression {
+	case packet.CompressionNone:
+		r = body
+	case packet.CompressionZIP:
+		fr := newFlateReader(in)
+		defer putFlateReader(fr)
+		r = fr
+	case packet.CompressionZLIB:
+		zr, err := newZlibReader(in)
+		if err != nil {
+			return corrupt(err)
+		}
+		defer putZlibReader(zr)
+		r = zr
+	case 3:
+		r = bzip2.NewReader(in)
+	default:
+		return fmt.Errorf("%w compression algorithm %d", ErrUnsupported, algo[0])
+	}
+
+	if m.res.Compression != packet.CompressionNone {
+		m.setPhase(PhaseDecompress)
+	}
+
+	if m.res.Compression != packet.CompressionNone {
+		r = corruptReader{r}
+	}
+	if err := m.readPlaintext(w, r, depth+1); err != nil {
+		return err
+	}
+
+	// The decompressor may stop short of the end of the packet body,
+	// which must be consumed before the next packet can be read
+	_, err := io.Copy(io.Discard, body)
+	return err
+}
+
+// corruptReader reads from a decompressor, and wraps the errors that
+// say its input is corrupt in ErrInvalidMessage: it was decrypted with
+// a session key that was accepted, so it is the message that is
+// corrupt. Those of the packet body it reads, such as a cancelled
+// context, are left alone.
+type corruptReader struct {
+	r io.Reader
+}
+
+func (cr corruptReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	return n, corrupt(err)
+}
+
+// corrupt wraps err in ErrInvalidMessage if it is a decompressor's
+// error about its input, and not already wrapped by that of an outer
+// compressed packet.
+func corrupt(err error) error {
+	var flateErr flate.CorruptInputError
+	var bzip2Err bzip2.StructuralError
+	if errors.Is(err, ErrInvalidMessage) {
+		return err
+	}
+	if errors.As(err, &flateErr) || errors.As(err, &bzip2Err) || errors.Is(err, zlib.ErrHeader) ||
+		errors.Is(err, zlib.ErrChecksum) || errors.Is(err, zlib.ErrDictionary) {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	return err
+}
+
+func (m *message) readLiteral(w io.Writer, body io.Reader) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(body, hdr[:]); err != nil {
+		return unexpected(err)
+	}
+	name := make([]byte, hdr[1])
+	var date [4]byte
+	if _, err := io.ReadFull(body, name); err != nil {
+		return unexpected(err)
+	}
+	if _, err := io.ReadFull(body, date[:]); err != nil {
+		return unexpected(err)
+	}
+
+	m.res.Format = hdr[0]
+	m.res.FileName = string(name)
+	if t := binary.BigEndian.Uint32(date[:]); t != 0 {
+		m.res.ModTime = time.Unix(int64(t), 0)
+	}
+
+	if m.sig != nil && m.sig.h != nil {
+		w = io.MultiWriter(w, m.sig.writer())
+	}
+
+	_, err := copyBuffer(w, body)
+	return err
+}
+
+// phaseWriter attributes writes to PhaseWrite.
+type phaseWriter struct {
+	w io.Writer
+	m *message
+}
+
+func (pw phaseWriter) Write(p []byte) (int, error) {
+	prev := pw.m.curPhase
+	pw.m.setPhase(PhaseWrite)
+	defer pw.m.setPhase(prev)
+
+	return pw.w.Write(p)
+}
+
+// phaseReader attributes reads of decrypted, but still compressed,
+// data to PhaseDecrypt and the rest of the time to PhaseDecompress.
+type phaseReader struct {
+	r io.Reader
+	m *message
+}
+
+func (pr phaseReader) Read(p []byte) (int, error) {
+	pr.m.setPhase(PhaseDecrypt)
+	defer pr.m.setPhase(PhaseDecompress)
+
+	return pr.r.Read(p)
+}
+
+// spanReader attributes reads of r to the phase p, going back to the
+// phase the read was made in afterwards.
+type spanReader struct {
+	r io.Reader
+	m *message
+	p Phase
+}
+
+func (sr spanReader) Read(b []byte) (int, error) {
+	prev := sr.m.curPhase
+	sr.m.setPhase(sr.p)
+	defer sr.m.setPhase(prev)
+
+	return sr.r.Read(b)
+}
+
+// countReader counts the bytes read from r, failing beyond max if set.
+type countReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+	// Progress reporting, if any
+	pr *progressReporter
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	cr.pr.tick()
+	if cr.max > 0 && cr.n > cr.max {
+		return n, fmt.Errorf("%w: more than %d bytes of cipher text",
+			ErrTooLarge, cr.max)
+	}
+
+	return n, err
+}
+
+// countWriter counts the bytes written to w, failing beyond max if
+// set.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	max int64
+	pr  *progressReporter
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.max > 0 && cw.n+int64(len(p)) > cw.max {
+		return 0, fmt.Errorf("%w: more than %d bytes of plain text",
+			ErrTooLarge, cw.max)
+	}
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.pr.tick()
+	return n, err
+}
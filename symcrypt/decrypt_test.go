@@ -0,0 +1,220 @@
+package symcrypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// The passphrase and plain text of the selftest vectors
+const (
+	vectorPassphrase = "selftest"
+	vectorPlaintext  = "The quick brown fox jumps over the lazy dog.\n"
+)
+
+var decryptVectors = []struct {
+	file      string
+	cipher    Cipher
+	integrity bool
+}{
+	{"aes128-simple.gpg", CipherAES128, true},
+	{"aes192-salted.gpg", CipherAES192, true},
+	{"aes256-iterated.gpg", CipherAES256, true},
+	{"aes256-nomdc.gpg", CipherAES256, false},
+	{"cast5-iterated.asc", CipherCAST5, true},
+	{"3des-nomdc.asc", Cipher3DES, false},
+}
+
+func decryptFile(t *testing.T, name string, opts ...Option) (Result, []byte, error) {
+	t.Helper()
+	fd, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	var out bytes.Buffer
+	res, err := NewDecryptor(opts...).Decrypt(&out, fd)
+
+	return res, out.Bytes(), err
+}
+
+func TestDecryptVectors(t *testing.T) {
+	for _, v := range decryptVectors {
+		t.Run(v.file, func(t *testing.T) {
+			res, out, err := decryptFile(t, "../vectors/"+v.file,
+				WithPassphrase([]byte(vectorPassphrase)), WithIntegrityPolicy(IntegrityOptional))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != vectorPlaintext {
+				t.Errorf("plain text %q, want %q", out, vectorPlaintext)
+			}
+			if res.Cipher != v.cipher {
+				t.Errorf("cipher %v, want %v", res.Cipher, v.cipher)
+			}
+			if res.Integrity != v.integrity {
+				t.Errorf("integrity %v, want %v", res.Integrity, v.integrity)
+			}
+			if armored := strings.HasSuffix(v.file, ".asc"); res.Armored != armored {
+				t.Errorf("armored %v, want %v", res.Armored, armored)
+			}
+		})
+	}
+}
+
+func TestDecryptRequiresIntegrity(t *testing.T) {
+	for _, v := range decryptVectors {
+		if v.integrity {
+			continue
+		}
+		t.Run(v.file, func(t *testing.T) {
+			_, out, err := decryptFile(t, "../vectors/"+v.file, WithPassphrase([]byte(vectorPassphrase)))
+			if !errors.Is(err, ErrNoIntegrityProtection) {
+				t.Fatalf("got %v, want ErrNoIntegrityProtection", err)
+			}
+			if len(out) != 0 {
+				t.Errorf("wrote %d bytes of plain text without an MDC", len(out))
+			}
+		})
+	}
+}
+
+func TestDecryptWrongPassphrase(t *testing.T) {
+	for _, v := range decryptVectors {
+		t.Run(v.file, func(t *testing.T) {
+			_, _, err := decryptFile(t, "../vectors/"+v.file,
+				WithPassphrase([]byte("wrong")), WithIntegrityPolicy(IntegrityOptional))
+			if !errors.Is(err, ErrWrongPassphrase) {
+				t.Fatalf("got %v, want ErrWrongPassphrase", err)
+			}
+		})
+	}
+}
+
+func TestDecryptTamperedMDC(t *testing.T) {
+	_, _, err := decryptFile(t, "../vectors/tampered-mdc.gpg", WithPassphrase([]byte(vectorPassphrase)))
+	if !errors.Is(err, ErrIntegrityFailed) {
+		t.Fatalf("got %v, want ErrIntegrityFailed", err)
+	}
+}
+
+// testdata/partial-lengths.gpg was made with GnuPG 2.2 from a pipe, so
+// that both its encrypted and literal data packets have partial body
+// lengths:
+//
+//	python3 -c "import sys; sys.stdout.write('0123456789abcdef'*4375)" |
+//		gpg --passphrase selftest --cipher-algo AES256 --compress-algo none --set-filename '' -c
+func TestDecryptPartialLengths(t *testing.T) {
+	res, out, err := decryptFile(t, "testdata/partial-lengths.gpg", WithPassphrase([]byte(vectorPassphrase)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("0123456789abcdef", 4375); string(out) != want {
+		t.Errorf("plain text of %d bytes, want %d", len(out), len(want))
+	}
+	if res.Cipher != CipherAES256 || !res.Integrity {
+		t.Errorf("cipher %v, integrity %v, want AES-256 with an MDC", res.Cipher, res.Integrity)
+	}
+}
+
+// nestedMessage returns a message encrypted with passphrase whose
+// literal data is compressed with each of algos in turn, the first
+// outermost, as golang.org/x/crypto writes one.
+func nestedMessage(t *testing.T, passphrase, plaintext []byte, algos ...packet.CompressionAlgo) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	config := &packet.Config{DefaultCipher: packet.CipherAES256}
+	key, err := packet.SerializeSymmetricKeyEncrypted(&buf, passphrase, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := packet.SerializeSymmetricallyEncrypted(&buf, config.Cipher(), key, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algo := range algos {
+		if w, err = packet.SerializeCompressed(w, algo, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lit, err := packet.SerializeLiteral(w, true, "nested", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lit.Write(plaintext)
+	// Which closes each packet it is in
+	if err := lit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecryptNestedCompression(t *testing.T) {
+	plaintext := []byte(strings.Repeat(vectorPlaintext, 100))
+	msg := nestedMessage(t, []byte(vectorPassphrase), plaintext, packet.CompressionZLIB, packet.CompressionZIP)
+
+	var out bytes.Buffer
+	res, err := NewDecryptor(WithPassphrase([]byte(vectorPassphrase))).Decrypt(&out, bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("plain text of %d bytes, want %d", out.Len(), len(plaintext))
+	}
+	if res.FileName != "nested" {
+		t.Errorf("file name %q, want nested", res.FileName)
+	}
+
+	out.Reset()
+	_, err = NewDecryptor(WithPassphrase([]byte(vectorPassphrase)), WithMaxNesting(1)).Decrypt(&out, bytes.NewReader(msg))
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("nested deeper than WithMaxNesting: got %v, want ErrInvalidMessage", err)
+	}
+}
+
+// Corrupt compressed data, in a message whose MDC is right for it, is
+// told from a wrong passphrase and from a missing MDC.
+func TestDecryptCorruptCompression(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		body []byte
+	}{
+		// A deflate block of the reserved type
+		{"zip", []byte{1, 0xff, 0xff, 0xff, 0xff}},
+		{"zlib header", []byte{2, 0x78, 0x00, 0xff, 0xff}},
+		{"bzip2", append([]byte{3}, "BZh9not a block"...)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			config := &packet.Config{DefaultCipher: packet.CipherAES256}
+			key, err := packet.SerializeSymmetricKeyEncrypted(&buf, []byte(vectorPassphrase), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := packet.SerializeSymmetricallyEncrypted(&buf, config.Cipher(), key, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// An old format compressed data packet of indeterminate length
+			w.Write(append([]byte{0x80 | 8<<2 | 3}, tt.body...))
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			res, err := NewDecryptor(WithPassphrase([]byte(vectorPassphrase))).Decrypt(&out, &buf)
+			if !errors.Is(err, ErrInvalidMessage) {
+				t.Fatalf("got %v, want ErrInvalidMessage", err)
+			}
+			if res.Cipher != CipherAES256 {
+				t.Errorf("cipher %v, want the session key's AES-256", res.Cipher)
+			}
+		})
+	}
+}
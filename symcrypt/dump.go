@@ -0,0 +1,151 @@
+package symcrypt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// packetStream is a sequence of packets being parsed, the message or
+// the contents of its encrypted or compressed data, with
+// WithPacketDump. It counts the bytes read from it, for the offsets of
+// the packets, and records the bytes of each packet header and partial
+// body length as they are read, to dump them.
+type packetStream struct {
+	r    io.Reader
+	w    io.Writer
+	name string
+	n    int64
+
+	rec   bool
+	start int64
+	hdr   []byte
+}
+
+// packetStream returns the stream of packets called name read from r,
+// or nil without WithPacketDump.
+func (m *message) packetStream(r io.Reader, name string) *packetStream {
+	if m.packetDump == nil {
+		return nil
+	}
+
+	return &packetStream{r: r, w: m.packetDump, name: name}
+}
+
+func (s *packetStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if s.rec {
+		s.hdr = append(s.hdr, p[:n]...)
+	}
+	s.n += int64(n)
+
+	return n, err
+}
+
+// begin starts recording a header.
+func (s *packetStream) begin() {
+	s.rec, s.start, s.hdr = true, s.n, s.hdr[:0]
+}
+
+// readPacket is readPacket, from the stream s if it is not nil, when
+// the header is dumped.
+func (m *message) readPacket(r io.Reader, s *packetStream) (int, io.Reader, error) {
+	if s == nil {
+		return readPacket(r)
+	}
+
+	s.begin()
+	tag, body, err := readPacket(s)
+	s.rec = false
+	if err == io.EOF && len(s.hdr) == 0 {
+		return 0, nil, err
+	}
+
+	fmt.Fprintf(s.w, "%s, offset %d: ", s.name, s.start)
+	if err != nil {
+		fmt.Fprintf(s.w, "bad packet header: %v\n", err)
+	} else {
+		fmt.Fprintf(s.w, "%s packet (tag %d)\n", tagName(tag), tag)
+	}
+	s.dumpHeader(body)
+	if pr, ok := body.(*partialReader); ok {
+		pr.stream = s
+	}
+
+	return tag, body, err
+}
+
+// dumpHeader writes the packet header just read, a line for the tag
+// byte and one for the length, annotated. The body, if the header was
+// read in full, tells how long the packet is.
+func (s *packetStream) dumpHeader(body io.Reader) {
+	if len(s.hdr) == 0 {
+		return
+	}
+
+	b := s.hdr[0]
+	tag, err := headerTag(b)
+	switch {
+	case err != nil:
+		s.dumpLine(s.start, s.hdr[:1], "not a tag byte: the high bit is clear")
+	case b&0x40 != 0:
+		s.dumpLine(s.start, s.hdr[:1], fmt.Sprintf("new format tag byte, tag %d", tag))
+	default:
+		s.dumpLine(s.start, s.hdr[:1], fmt.Sprintf("old format tag byte, tag %d, length type %d", tag, b&3))
+	}
+
+	length := s.hdr[1:]
+	var note string
+	switch r := body.(type) {
+	case *exactReader:
+		note = fmt.Sprintf("body length %d", r.remaining)
+	case *partialReader:
+		note = fmt.Sprintf("partial body length %d, more chunks follow", r.remaining)
+	case nil:
+		note = "truncated length"
+	default:
+		s.dumpLine(s.start+1, nil, "indeterminate length: the body runs to the end of the "+s.name)
+		return
+	}
+	if len(length) != 0 || body != nil {
+		s.dumpLine(s.start+1, length, fmt.Sprintf("%s (%s)", note, lengthForm(length)))
+	}
+}
+
+// dumpChunk writes the partial body length just read, in the stream
+// from begin, which says the next chunk of a packet body is length
+// bytes and whether it is the last.
+func (s *packetStream) dumpChunk(length int64, last bool) {
+	note := fmt.Sprintf("partial body length %d, more chunks follow", length)
+	if last {
+		note = fmt.Sprintf("last chunk, body length %d", length)
+	}
+	fmt.Fprintf(s.w, "%s, offset %d: partial body chunk\n", s.name, s.start)
+	s.dumpLine(s.start, s.hdr, fmt.Sprintf("%s (%s)", note, lengthForm(s.hdr)))
+}
+
+// dumpLine writes the bytes b, read at offset, in hex, with a note on
+// what they are.
+func (s *packetStream) dumpLine(offset int64, b []byte, note string) {
+	hex := strings.TrimSpace(fmt.Sprintf("% x", b))
+	fmt.Fprintf(s.w, "  %08x  %-14s  %s\n", offset, hex, note)
+}
+
+// lengthForm names the encoding of a packet length of the bytes b, by
+// its size, RFC 4880 section 4.2.
+func lengthForm(b []byte) string {
+	switch len(b) {
+	case 0:
+		return "no length octets"
+	case 1:
+		return "one octet"
+	case 2:
+		return "two octets"
+	case 4:
+		return "four octets"
+	case 5:
+		return "five octets"
+	}
+
+	return fmt.Sprintf("%d octets", len(b))
+}
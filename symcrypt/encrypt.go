@@ -0,0 +1,261 @@
+package symcrypt
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Encryption defaults, stronger than those of openpgp.SymmetricallyEncrypt
+const (
+	defaultCipher   = CipherAES256
+	defaultS2KCount = 65011712
+)
+
+// NewEncryptWriter returns a writer that encrypts what is written to it
+// with passphrase, as an OpenPGP message written to w. The message
+// always has an MDC, and its key is derived with the iterated and
+// salted S2K using SHA-256. Close must be called to finish the
+// message; it does not close w.
+func NewEncryptWriter(w io.Writer, passphrase []byte, opts ...Option) (io.WriteCloser, error) {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.applyFIPS()
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%w: empty passphrase", ErrNoPassphrase)
+	}
+
+	ci := c.cipher
+	if ci == 0 {
+		ci = defaultCipher
+	}
+	if !ci.supported() {
+		return nil, fmt.Errorf("%w %v", ErrUnsupportedCipher, ci)
+	}
+	if c.ciphers != nil && !c.ciphers[ci] {
+		return nil, fmt.Errorf("%w: %v", ErrCipherNotAllowed, ci)
+	}
+	if err := c.checkFIPS(ci, 0); err != nil {
+		return nil, err
+	}
+
+	switch c.compression {
+	case packet.CompressionNone, packet.CompressionZIP, packet.CompressionZLIB:
+	default:
+		return nil, fmt.Errorf("%w compression algorithm %d",
+			ErrUnsupported, c.compression)
+	}
+
+	count := c.s2kCount
+	if count == 0 {
+		count = defaultS2KCount
+	}
+
+	var signer *packet.PrivateKey
+	if c.signer != nil {
+		var err error
+		if signer, err = signingKey(c.signer); err != nil {
+			return nil, err
+		}
+	}
+
+	var to []*packet.PublicKey
+	for _, e := range c.recipients {
+		pub, err := encryptionKey(e)
+		if err != nil {
+			return nil, err
+		}
+		to = append(to, pub)
+	}
+
+	pc := &packet.Config{
+		DefaultCipher:          packet.CipherFunction(ci),
+		DefaultCompressionAlgo: c.compression,
+		S2KCount:               count,
+		Rand:                   c.rand,
+	}
+	hints := &openpgp.FileHints{
+		IsBinary: true,
+		FileName: c.fileName,
+		ModTime:  c.modTime,
+	}
+
+	if c.progress == nil {
+		return symmetricallyEncrypt(w, passphrase, to, hints, pc, signer, c.sessionKey)
+	}
+
+	out := &countWriter{w: w}
+	ew := &encryptWriter{}
+	pr := newProgressReporter(&c, func() Progress {
+		return Progress{BytesIn: ew.n, BytesOut: out.n}
+	})
+	ew.pr, out.pr = pr, pr
+	wc, err := symmetricallyEncrypt(out, passphrase, to, hints, pc, signer, c.sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	ew.wc = wc
+
+	return ew, nil
+}
+
+// symmetricallyEncrypt is openpgp.SymmetricallyEncrypt, which also
+// encrypts the session key to the public keys in to, and signs the
+// plain text with signer, if it is not nil, as openpgp.Encrypt does.
+// The session key is passed to sessionKey, if it is not nil.
+func symmetricallyEncrypt(w io.Writer, passphrase []byte, to []*packet.PublicKey, hints *openpgp.FileHints, pc *packet.Config, signer *packet.PrivateKey, sessionKey func(Cipher, []byte)) (io.WriteCloser, error) {
+	// The passphrase encrypts a random session key, which the public
+	// keys then encrypt too. Their packets go first, as gpg puts them,
+	// or gpg only tries a passphrase. The packets are written here, not
+	// by x/crypto, which only knows some of the ciphers.
+	c := Cipher(pc.Cipher())
+	key := make([]byte, c.KeySize())
+	if _, err := io.ReadFull(pc.Random(), key); err != nil {
+		return nil, err
+	}
+	skesk, err := wrapSessionKey(c, key, passphrase, c, pc.S2KCount, pc.Random())
+	if err != nil {
+		return nil, err
+	}
+	if sessionKey != nil {
+		sessionKey(c, key)
+	}
+	for _, pub := range to {
+		if err := packet.SerializeEncryptedKey(w, pub, pc.Cipher(), key, pc); err != nil {
+			return nil, fmt.Errorf("symcrypt: encrypt to %X: %w", pub.Fingerprint, err)
+		}
+	}
+	if _, err := w.Write(skesk); err != nil {
+		return nil, err
+	}
+	encrypted, err := serializeSEIPD(w, c, key, pc.Random())
+	if err != nil {
+		return nil, err
+	}
+
+	payload := encrypted
+	if algo := pc.Compression(); algo != packet.CompressionNone {
+		payload, err = packet.SerializeCompressed(encrypted, algo, pc.CompressionConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if signer != nil {
+		ops := &packet.OnePassSignature{
+			SigType:    packet.SigTypeBinary,
+			Hash:       pc.Hash(),
+			PubKeyAlgo: signer.PubKeyAlgo,
+			KeyId:      signer.KeyId,
+			IsLast:     true,
+		}
+		if err := ops.Serialize(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	var epochSeconds uint32
+	if !hints.ModTime.IsZero() {
+		epochSeconds = uint32(hints.ModTime.Unix())
+	}
+	// The signature follows the literal data, so closing that must not
+	// close the payload.
+	literal, err := packet.SerializeLiteral(nopWriteCloser{payload}, hints.IsBinary,
+		hints.FileName, epochSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingWriter{literal: literal, payload: payload, signer: signer, pc: pc,
+		h: pc.Hash().New()}, nil
+}
+
+// signingWriter writes the literal data, hashing it for the signature
+// that it writes after it if there is a signer, and then finishes the
+// payload.
+type signingWriter struct {
+	literal io.WriteCloser
+	payload io.WriteCloser
+	signer  *packet.PrivateKey
+	pc      *packet.Config
+	h       hash.Hash
+}
+
+func (sw *signingWriter) Write(p []byte) (int, error) {
+	if sw.signer != nil {
+		sw.h.Write(p)
+	}
+	return sw.literal.Write(p)
+}
+
+func (sw *signingWriter) Close() error {
+	if err := sw.literal.Close(); err != nil {
+		return err
+	}
+	if sw.signer != nil {
+		sig := &packet.Signature{
+			SigType:      packet.SigTypeBinary,
+			PubKeyAlgo:   sw.signer.PubKeyAlgo,
+			Hash:         sw.pc.Hash(),
+			CreationTime: sw.pc.Now(),
+			IssuerKeyId:  &sw.signer.KeyId,
+		}
+		if err := sig.Sign(sw.h, sw.signer, sw.pc); err != nil {
+			return fmt.Errorf("symcrypt: sign: %w", err)
+		}
+		if err := sig.Serialize(sw.payload); err != nil {
+			return err
+		}
+	}
+
+	return sw.payload.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// encryptWriter counts the plain text for progress reports.
+type encryptWriter struct {
+	wc io.WriteCloser
+	n  int64
+	pr *progressReporter
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	n, err := ew.wc.Write(p)
+	ew.n += int64(n)
+	ew.pr.tick()
+	return n, err
+}
+
+func (ew *encryptWriter) Close() error {
+	defer ew.pr.done()
+	return ew.wc.Close()
+}
+
+// EncryptContext encrypts all of src with passphrase, as NewEncryptWriter
+// does, writing the message to dst. It stops between chunks once ctx is
+// done, returning its cause, and leaving an unfinished message in dst.
+func EncryptContext(ctx context.Context, dst io.Writer, src io.Reader, passphrase []byte, opts ...Option) error {
+	w, err := NewEncryptWriter(dst, passphrase, opts...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := copyBuffer(w, ctxReader{ctx, src}); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,115 @@
+package symcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// encryptMessage encrypts plaintext with passphrase, with the cheapest
+// S2K, so that many messages can be.
+func encryptMessage(t *testing.T, passphrase, plaintext []byte, opts ...Option) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, passphrase, append([]Option{WithS2KCount(1024)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Sizes around the partial body lengths that the encrypted data is
+// written in
+var roundTripSizes = []int{0, 1, partialChunk - 1, partialChunk, partialChunk + 1, 3*partialChunk + 17}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	pass := []byte(vectorPassphrase)
+	for _, ci := range Ciphers() {
+		for _, algo := range []packet.CompressionAlgo{packet.CompressionNone, packet.CompressionZIP, packet.CompressionZLIB} {
+			for _, size := range roundTripSizes {
+				t.Run(fmt.Sprintf("%v/%d/%d", ci, algo, size), func(t *testing.T) {
+					plaintext := make([]byte, size)
+					for i := range plaintext {
+						plaintext[i] = byte(i * 7)
+					}
+					msg := encryptMessage(t, pass, plaintext,
+						WithCipher(ci), WithCompression(algo), WithFileName("round-trip"))
+
+					var out bytes.Buffer
+					res, err := NewDecryptor(WithPassphrase(pass)).Decrypt(&out, bytes.NewReader(msg))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(out.Bytes(), plaintext) {
+						t.Errorf("plain text of %d bytes, want %d", out.Len(), size)
+					}
+					if res.Cipher != ci || !res.Integrity {
+						t.Errorf("cipher %v, integrity %v, want %v with an MDC", res.Cipher, res.Integrity, ci)
+					}
+					if res.Compression != algo {
+						t.Errorf("compression %d, want %d", res.Compression, algo)
+					}
+					if res.FileName != "round-trip" {
+						t.Errorf("file name %q, want round-trip", res.FileName)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestEncryptPartialLengths(t *testing.T) {
+	msg := encryptMessage(t, []byte(vectorPassphrase), make([]byte, 3*partialChunk))
+
+	tag, _, rest, err := SplitPacket(msg)
+	if err != nil || tag != 3 {
+		t.Fatalf("first packet: tag %d, %v; want a SKESK", tag, err)
+	}
+	// A new format SEIPD header, and a first length octet that is a
+	// partial body length
+	if len(rest) < 2 || rest[0] != 0xc0|18 || rest[1] < 224 || rest[1] == 255 {
+		t.Fatalf("encrypted data header % x, want a SEIPD packet in partial body lengths", rest[:min(len(rest), 2)])
+	}
+	tag, body, rest, err := SplitPacket(rest)
+	if err != nil || tag != 18 || len(rest) != 0 {
+		t.Fatalf("encrypted data: tag %d, %d bytes after it, %v", tag, len(rest), err)
+	}
+	if len(body) <= 3*partialChunk {
+		t.Errorf("encrypted data of %d bytes, for %d of plain text", len(body), 3*partialChunk)
+	}
+}
+
+func TestEncryptWrongPassphrase(t *testing.T) {
+	msg := encryptMessage(t, []byte(vectorPassphrase), []byte(vectorPlaintext))
+
+	var out bytes.Buffer
+	_, err := NewDecryptor(WithPassphrase([]byte("wrong"))).Decrypt(&out, bytes.NewReader(msg))
+	if !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("got %v, want ErrWrongPassphrase", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote %d bytes with the wrong passphrase", out.Len())
+	}
+}
+
+func TestEncryptTamperedMDC(t *testing.T) {
+	msg := encryptMessage(t, []byte(vectorPassphrase), []byte(vectorPlaintext))
+	// The last byte is of the SHA-1 of the MDC packet
+	msg[len(msg)-1] ^= 1
+
+	var out bytes.Buffer
+	_, err := NewDecryptor(WithPassphrase([]byte(vectorPassphrase))).Decrypt(&out, bytes.NewReader(msg))
+	if !errors.Is(err, ErrIntegrityFailed) {
+		t.Fatalf("got %v, want ErrIntegrityFailed", err)
+	}
+}
@@ -0,0 +1,55 @@
+package symcrypt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned, possibly wrapped with more detail, by this package.
+// Test for them with errors.Is.
+var (
+	// The passphrase did not decrypt any session key
+	ErrWrongPassphrase = errors.New("symcrypt: wrong passphrase")
+	// The MDC did not match: the plain text that was output is corrupt
+	// or has been tampered with
+	ErrIntegrityFailed = errors.New("symcrypt: integrity check failed: MDC mismatch")
+	// The message has no MDC and the policy requires one
+	ErrNoIntegrityProtection = errors.New("symcrypt: message has no integrity protection (MDC)")
+	// A one-pass signature by a key in the key ring did not verify
+	ErrBadSignature = errors.New("symcrypt: signature verification failed")
+
+	// The message is not encrypted, or not with a passphrase
+	ErrNotEncrypted           = errors.New("symcrypt: message is not encrypted")
+	ErrNotPassphraseEncrypted = errors.New("symcrypt: message is not passphrase encrypted")
+	// A secret key in the key ring that a session key is encrypted to
+	// is passphrase protected, and the passphrase for it was wrong or
+	// could not be had
+	ErrKeyLocked = errors.New("symcrypt: secret key is locked: wrong or no passphrase for it")
+	// No passphrase provider was configured
+	ErrNoPassphrase = errors.New("symcrypt: no passphrase")
+	// A new passphrase was not entered the same way twice
+	ErrPassphraseMismatch = errors.New("symcrypt: the passphrases do not match")
+
+	ErrUnsupportedCipher = errors.New("symcrypt: unsupported cipher")
+	ErrCipherNotAllowed  = errors.New("symcrypt: cipher not allowed")
+	// The message is encrypted with a legacy cipher, e.g. IDEA, which
+	// is only decrypted with WithLegacyCiphers
+	ErrLegacyCipher = fmt.Errorf("%w: legacy cipher", ErrUnsupportedCipher)
+	// An algorithm used by the message is not approved in FIPS mode
+	ErrNotApproved = errors.New("symcrypt: algorithm not FIPS approved")
+	// Some other feature of the message, e.g. AEAD, is not supported
+	ErrUnsupported = errors.New("symcrypt: unsupported")
+	// The message is AEAD encrypted, as RFC 9580 and GnuPG's OCB mode
+	// have it
+	ErrAEAD = fmt.Errorf("%w AEAD encryption", ErrUnsupported)
+
+	// The CRC-24 of ASCII armored input did not match
+	ErrArmorChecksum = errors.New("symcrypt: armor checksum mismatch")
+	// The input is not a valid OpenPGP message
+	ErrInvalidMessage = errors.New("symcrypt: invalid OpenPGP data")
+	// The input is neither ASCII armor nor starts with an OpenPGP
+	// packet, so it is no OpenPGP message at all
+	ErrNotOpenPGP = fmt.Errorf("%w: neither ASCII armor nor OpenPGP packets", ErrInvalidMessage)
+	// A size limit set with an option was exceeded
+	ErrTooLarge = errors.New("symcrypt: size limit exceeded")
+)
@@ -0,0 +1,75 @@
+package symcrypt
+
+import (
+	"crypto"
+	"crypto/fips140"
+	"fmt"
+)
+
+// WithFIPSMode restricts decryption and encryption to algorithms
+// approved for FIPS 140-3: AES session keys, SHA-2 for the S2K and for
+// signatures, and integrity protected data only. Messages using
+// anything else fail with ErrNotApproved. It is implied when the Go
+// cryptography is in FIPS 140-3 mode, e.g. with GODEBUG=fips140=on, or
+// built with GOEXPERIMENT=boringcrypto.
+//
+// The MDC of integrity protected data is a SHA-1 hash, which remains
+// approved outside of digital signatures, and OpenPGP's CFB mode is
+// outside the Go module, so GODEBUG=fips140=only cannot decrypt at all.
+func WithFIPSMode() Option {
+	return func(c *config) {
+		c.fips = true
+	}
+}
+
+// FIPSMode tells whether the Go cryptography itself is in FIPS mode, so
+// that WithFIPSMode is implied.
+func FIPSMode() bool {
+	return fips140.Enabled() || boringEnabled()
+}
+
+// applyFIPS enforces what FIPS mode implies for the rest of the
+// configuration.
+func (c *config) applyFIPS() {
+	if FIPSMode() {
+		c.fips = true
+	}
+	if c.fips {
+		c.integrity = IntegrityRequired
+	}
+}
+
+func fipsCipher(c Cipher) bool {
+	switch c {
+	case CipherAES128, CipherAES192, CipherAES256:
+		return true
+	}
+
+	return false
+}
+
+func fipsHash(h crypto.Hash) bool {
+	switch h {
+	case crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512:
+		return true
+	}
+
+	return false
+}
+
+// checkFIPS returns an ErrNotApproved error if FIPS mode is on and the
+// cipher c or the S2K hash h is not approved. h is 0 when no S2K is
+// involved.
+func (c *config) checkFIPS(ci Cipher, h crypto.Hash) error {
+	if !c.fips {
+		return nil
+	}
+	if !fipsCipher(ci) {
+		return fmt.Errorf("%w: cipher %v", ErrNotApproved, ci)
+	}
+	if h != 0 && !fipsHash(h) {
+		return fmt.Errorf("%w: S2K hash %v", ErrNotApproved, h)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build boringcrypto
+
+package symcrypt
+
+import "crypto/boring"
+
+func boringEnabled() bool {
+	return boring.Enabled()
+}
@@ -0,0 +1,7 @@
+//go:build !boringcrypto
+
+package symcrypt
+
+func boringEnabled() bool {
+	return false
+}
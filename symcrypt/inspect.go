@@ -0,0 +1,145 @@
+package symcrypt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MessageInfo describes the packet structure of a message, as far as
+// it can be seen without decrypting it.
+type MessageInfo struct {
+	Armored      bool
+	ArmorHeaders map[string]string
+
+	Packets []PacketInfo
+	SKESKs  []SKESKInfo
+	// Key IDs of public-key encrypted session keys
+	PublicKeyIDs []uint64
+
+	// Whether the encrypted data has an MDC
+	Integrity bool
+}
+
+// Tags of the packets that Inspect reports on in detail
+const (
+	TagPKESK = tagPKESK
+	TagSKESK = tagSKESK
+	TagSEIPD = tagSEIPD
+	TagAEAD  = tagAEAD
+)
+
+// PacketInfo describes one top-level packet. Offsets are into the
+// binary message, after removing any ASCII armor.
+type PacketInfo struct {
+	Tag    int
+	Name   string
+	Offset int64
+	// Sizes of the header and the body, in bytes
+	HeaderLength int64
+	Length       int64
+	// Whether the body was sent in partial body length chunks, or
+	// extends to the end of the message
+	Partial bool
+	// For encrypted data packets that have one
+	Version int
+}
+
+// SKESKInfo describes a symmetric-key encrypted session key packet.
+type SKESKInfo struct {
+	Version int
+	Cipher  Cipher
+	S2K     S2K
+	// Whether the session key is encrypted with the S2K output, rather
+	// than being the S2K output itself
+	EncryptedKey bool
+}
+
+// Inspect reads the message in r, which may be binary or ASCII
+// armored, to its end and reports on its packets.
+func Inspect(r io.Reader) (*MessageInfo, error) {
+	br, block, err := dearmor(bufio.NewReader(r), false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &MessageInfo{}
+	if block != nil {
+		info.Armored = true
+		info.ArmorHeaders = block.Header
+	}
+
+	in := &countReader{r: br}
+	for {
+		offset := in.n
+		tag, body, err := readPacket(in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, err
+		}
+
+		p := PacketInfo{
+			Tag:          tag,
+			Name:         tagName(tag),
+			Offset:       offset,
+			HeaderLength: in.n - offset,
+		}
+		switch body.(type) {
+		case *exactReader:
+		default:
+			p.Partial = true
+		}
+
+		var n int64
+		switch tag {
+		case tagSKESK:
+			b, err := readBody(body, 1024)
+			if err != nil {
+				return info, err
+			}
+			n = int64(len(b))
+			s, err := ParseSKESK(b)
+			if err != nil {
+				return info, err
+			}
+			info.SKESKs = append(info.SKESKs, s)
+
+		case tagPKESK:
+			b, err := readBody(body, 8192)
+			if err != nil {
+				return info, err
+			}
+			n = int64(len(b))
+			if len(b) < 9 {
+				return info, fmt.Errorf("%w: short PKESK packet", ErrInvalidMessage)
+			}
+			info.PublicKeyIDs = append(info.PublicKeyIDs,
+				binary.BigEndian.Uint64(b[1:9]))
+
+		case tagSEIPD, tagAEAD:
+			var version [1]byte
+			if _, err := io.ReadFull(body, version[:]); err != nil {
+				return info, unexpected(err)
+			}
+			p.Version = int(version[0])
+			info.Integrity = true
+			n = 1
+			fallthrough
+
+		default:
+			m, err := io.Copy(io.Discard, body)
+			if err != nil {
+				return info, err
+			}
+			n += m
+		}
+
+		p.Length = n
+		info.Packets = append(info.Packets, p)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,312 @@
+package symcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+)
+
+// WithKeyRing makes the Decryptor also try the secret keys in kr on
+// public-key encrypted session keys, before falling back to the
+// passphrase, and verify one-pass signatures made by keys in kr.
+// Encrypted secret keys are decrypted with the passphrase, or the one
+// that WithKeyPassphrase gives for them. Without a key ring, only
+// passphrases are used and signatures are not verified.
+func WithKeyRing(kr openpgp.KeyRing) Option {
+	return func(c *config) {
+		c.keyring = kr
+	}
+}
+
+// WithSignerLookup makes the Decryptor call f for the keys of a signer
+// that is not in the key ring, by its key ID and, if the signature
+// names one, user ID, e.g. to fetch them from a keyserver. Keys that f
+// returns verify the signature as key ring keys do, and
+// Result.SignerLookedUp is set, as they are not vouched for by being
+// in the key ring. f returns nil if it finds none.
+func WithSignerLookup(f func(keyID uint64, userID string) []openpgp.Key) Option {
+	return func(c *config) {
+		c.signerLookup = f
+	}
+}
+
+// WithAgentKeys makes the Decryptor also try the public keys in the key
+// ring, on public-key encrypted session keys, with the secret keys that
+// gpg-agent holds for them, in its store or on a smartcard, such as an
+// OpenPGP card or YubiKey. The agent asks for their passphrase or PIN.
+// Only RSA keys are supported.
+func WithAgentKeys() Option {
+	return func(c *config) {
+		c.agentKeys = true
+	}
+}
+
+// packetBytes returns body with a new format packet header, so that it
+// can be handed to packet.Read.
+func packetBytes(tag int, body []byte) []byte {
+	b := []byte{0xc0 | byte(tag), 0xff, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[2:], uint32(len(body)))
+	return append(b, body...)
+}
+
+// tryKeyRing tries the secret keys in the key ring on each PKESK in
+// turn. It returns a nil reader if none of them decrypts the data.
+func (m *message) tryKeyRing(br *bufio.Reader, pkesks [][]byte, mdc bool) (io.Reader, error) {
+	for _, b := range pkesks {
+		p, err := packet.Read(bytes.NewReader(packetBytes(tagPKESK, b)))
+		if err != nil {
+			continue
+		}
+		ek, ok := p.(*packet.EncryptedKey)
+		if !ok {
+			continue
+		}
+
+		keys := m.keyring.KeysById(ek.KeyId)
+		if ek.KeyId == 0 {
+			// A hidden recipient
+			keys = m.keyring.DecryptionKeys()
+		}
+		for _, k := range keys {
+			priv, byAgent := k.PrivateKey, false
+			if priv == nil && m.agentKeys && k.PublicKey != nil {
+				priv = agentPrivateKey(k.PublicKey,
+					fmt.Sprintf("Decrypt the message encrypted to key %X", k.PublicKey.Fingerprint))
+				byAgent = true
+			}
+			if priv == nil {
+				continue
+			}
+			if !m.unlock(priv) {
+				m.locked = priv.PublicKey.Fingerprint[:]
+				continue
+			}
+			if err := ek.Decrypt(priv, nil); err != nil {
+				// The PIN was not given, say, rather than a key not
+				// fitting
+				if errors.As(err, new(agentError)) {
+					return nil, err
+				}
+				continue
+			}
+
+			c := Cipher(ek.CipherFunc)
+			if !c.usable(m.legacyCiphers) {
+				return nil, c.unsupported()
+			}
+			if m.ciphers != nil && !m.ciphers[c] {
+				return nil, fmt.Errorf("%w: %v", ErrCipherNotAllowed, c)
+			}
+			if err := m.checkFIPS(c, 0); err != nil {
+				return nil, err
+			}
+			plain, err := m.trySessionKey(br, c, ek.Key, mdc)
+			if err != nil {
+				return nil, err
+			}
+			if plain != nil {
+				key := k
+				m.res.DecryptedWith = &key
+				m.res.DecryptedByAgent = byAgent
+				return plain, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// unlock decrypts pk, if it is encrypted, with the key passphrase, or
+// else the message's.
+func (m *message) unlock(pk *packet.PrivateKey) bool {
+	if !pk.Encrypted {
+		return true
+	}
+	p, own := m.keyPassphrase, true
+	if p == nil {
+		p, own = m.passphrase, false
+	}
+	if p == nil {
+		return false
+	}
+
+	pass, err := p.Passphrase()
+	if err != nil {
+		return false
+	}
+	if err := pk.Decrypt(pass); err != nil {
+		if f, ok := p.(Forgetter); ok && own {
+			f.Forget()
+		}
+		return false
+	}
+
+	return true
+}
+
+// sigState is the verification of a one-pass signature in progress.
+type sigState struct {
+	sigType packet.SignatureType
+	keyID   uint64
+	// nil if the hash is unsupported
+	h    hash.Hash
+	done bool
+}
+
+// newSigState starts verifying the one-pass signature b. In FIPS mode,
+// signatures over hashes other than SHA-2 are left unverified.
+func newSigState(b []byte, fips bool) *sigState {
+	s := &sigState{
+		sigType: packet.SignatureType(b[1]),
+		keyID:   binary.BigEndian.Uint64(b[4:12]),
+	}
+	if h, ok := s2k.HashIdToHash(b[2]); ok && h.Available() && (!fips || fipsHash(h)) {
+		s.h = h.New()
+	}
+
+	return s
+}
+
+// writer returns a writer that hashes the literal data as the
+// signature type requires.
+func (s *sigState) writer() io.Writer {
+	if s.sigType == packet.SigTypeText {
+		return &canonicalText{w: s.h}
+	}
+
+	return s.h
+}
+
+// verifySignature checks the signature packet b against the hash of
+// the literal data.
+func (m *message) verifySignature(b []byte) error {
+	m.sig.done = true
+	if m.sig.h == nil {
+		return nil
+	}
+
+	p, err := packet.Read(bytes.NewReader(packetBytes(tagSignature, b)))
+	if err != nil {
+		return nil
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.IssuerKeyId == nil || *sig.IssuerKeyId != m.sig.keyID {
+		return nil
+	}
+
+	var keys []openpgp.Key
+	if m.keyring != nil {
+		keys = m.keyring.KeysByIdUsage(m.sig.keyID, packet.KeyFlagSign)
+	}
+	lookedUp := false
+	if len(keys) == 0 && m.signerLookup != nil {
+		keys, lookedUp = m.signerLookup(m.sig.keyID, m.res.SignerUserID), true
+	}
+	for _, k := range keys {
+		if k.PublicKey == nil || k.PublicKey.KeyId != m.sig.keyID {
+			continue
+		}
+		if err := k.PublicKey.VerifySignature(m.sig.h, sig); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadSignature, err)
+		}
+		key := k
+		m.res.SignatureVerified = true
+		m.res.SignedBy = &key
+		m.res.SignerLookedUp = lookedUp
+		return nil
+	}
+
+	// Signed by someone we do not know
+	return nil
+}
+
+// canonicalText converts line endings to CRLF, as text signatures are
+// made over, RFC 4880 section 5.2.1.
+type canonicalText struct {
+	w  io.Writer
+	cr bool
+}
+
+func (ct *canonicalText) Write(p []byte) (int, error) {
+	start := 0
+	for i, c := range p {
+		switch c {
+		case '\r':
+			ct.cr = true
+		case '\n':
+			if !ct.cr {
+				ct.w.Write(p[start:i])
+				ct.w.Write([]byte("\r\n"))
+				start = i + 1
+			}
+			ct.cr = false
+		default:
+			ct.cr = false
+		}
+	}
+	ct.w.Write(p[start:])
+
+	return len(p), nil
+}
+
+// signingKey returns the private key of e to sign with: its newest
+// subkey that may sign, or else its primary key.
+func signingKey(e *openpgp.Entity) (*packet.PrivateKey, error) {
+	var key *packet.PrivateKey
+	var created time.Time
+	for _, sub := range e.Subkeys {
+		if sub.PrivateKey == nil || sub.Sig == nil || !sub.Sig.FlagsValid || !sub.Sig.FlagSign ||
+			!sub.PublicKey.PubKeyAlgo.CanSign() || sub.Sig.KeyExpired(time.Now()) {
+			continue
+		}
+		if key == nil || sub.Sig.CreationTime.After(created) {
+			key, created = sub.PrivateKey, sub.Sig.CreationTime
+		}
+	}
+	if key == nil && e.PrivateKey != nil && e.PrimaryKey.PubKeyAlgo.CanSign() {
+		key = e.PrivateKey
+	}
+
+	switch {
+	case key == nil:
+		return nil, fmt.Errorf("symcrypt: key %X has no secret signing key", e.PrimaryKey.Fingerprint)
+	case key.Encrypted:
+		return nil, fmt.Errorf("symcrypt: signing key %X is locked", key.PublicKey.Fingerprint)
+	}
+
+	return key, nil
+}
+
+// encryptionKey returns the public key of e to encrypt to: its newest
+// subkey that may encrypt, or else its primary key.
+func encryptionKey(e *openpgp.Entity) (*packet.PublicKey, error) {
+	var key *packet.PublicKey
+	var created time.Time
+	for _, sub := range e.Subkeys {
+		if sub.Sig == nil || !sub.Sig.FlagsValid ||
+			!(sub.Sig.FlagEncryptCommunications || sub.Sig.FlagEncryptStorage) ||
+			!sub.PublicKey.PubKeyAlgo.CanEncrypt() || sub.Sig.KeyExpired(time.Now()) {
+			continue
+		}
+		if key == nil || sub.Sig.CreationTime.After(created) {
+			key, created = sub.PublicKey, sub.Sig.CreationTime
+		}
+	}
+	if key == nil && e.PrimaryKey.PubKeyAlgo.CanEncrypt() {
+		key = e.PrimaryKey
+	}
+	if key == nil {
+		return nil, fmt.Errorf("symcrypt: key %X has no encryption key", e.PrimaryKey.Fingerprint)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,208 @@
+package symcrypt
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/blowfish"
+)
+
+// Legacy ciphers, which old implementations, PGP 2 and early GnuPG
+// among them, encrypted with. They are only decrypted with
+// WithLegacyCiphers, and never encrypted with.
+const (
+	CipherIDEA     Cipher = 1
+	CipherBlowfish Cipher = 4
+)
+
+var legacyCiphers = map[Cipher]cipherInfo{
+	CipherIDEA:     {"IDEA", 16, newIDEA},
+	CipherBlowfish: {"Blowfish", 16, newBlowfish},
+}
+
+// LegacyCiphers returns the ciphers that this package decrypts with
+// WithLegacyCiphers.
+func LegacyCiphers() []Cipher {
+	return []Cipher{CipherIDEA, CipherBlowfish}
+}
+
+func newBlowfish(key []byte) (cipher.Block, error) {
+	return blowfish.NewCipher(key)
+}
+
+func (c Cipher) legacy() bool {
+	_, ok := legacyCiphers[c]
+	return ok
+}
+
+// usable tells whether c is decrypted, with legacy ciphers allowed or
+// not.
+func (c Cipher) usable(legacy bool) bool {
+	return c.supported() || legacy && c.legacy()
+}
+
+// unsupported returns the error for decrypting with c when it is not
+// usable.
+func (c Cipher) unsupported() error {
+	if c.legacy() {
+		return fmt.Errorf("%w %v", ErrLegacyCipher, c)
+	}
+
+	return fmt.Errorf("%w %v", ErrUnsupportedCipher, c)
+}
+
+// pgp2SKESK stands for the session key packet that a PGP 2 message
+// does without: its data is encrypted with IDEA, keyed with the MD5 of
+// the passphrase.
+var pgp2SKESK = &skesk{cipher: CipherIDEA, s2k: S2K{Mode: S2KSimple, Hash: crypto.MD5}}
+
+// ideaBlockSize is the block size of IDEA in bytes.
+const ideaBlockSize = 8
+
+// ideaCipher is IDEA, as PGP 2 encrypts with it, with its encryption
+// and decryption subkeys.
+type ideaCipher struct {
+	ek, dk [52]uint16
+}
+
+func newIDEA(key []byte) (cipher.Block, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("symcrypt: invalid IDEA key size %d", len(key))
+	}
+
+	c := new(ideaCipher)
+	// The subkeys are the key, taken 16 bits at a time, rotated left
+	// by 25 bits after each eight of them.
+	hi, lo := binary.BigEndian.Uint64(key), binary.BigEndian.Uint64(key[8:])
+	for i := range c.ek {
+		if i != 0 && i%8 == 0 {
+			hi, lo = hi<<25|lo>>39, lo<<25|hi>>39
+		}
+		w := uint(i % 8)
+		if w < 4 {
+			c.ek[i] = uint16(hi >> (48 - 16*w))
+		} else {
+			c.ek[i] = uint16(lo >> (48 - 16*(w-4)))
+		}
+	}
+
+	// Decryption runs the rounds backwards, with the inverses of the
+	// subkeys, the additive ones of the inner rounds swapped.
+	ek := &c.ek
+	for r := 0; r <= 8; r++ {
+		e := 48 - 6*r
+		d := c.dk[6*r:]
+		d[0] = ideaMulInv(ek[e])
+		if r == 0 || r == 8 {
+			d[1], d[2] = -ek[e+1], -ek[e+2]
+		} else {
+			d[1], d[2] = -ek[e+2], -ek[e+1]
+		}
+		d[3] = ideaMulInv(ek[e+3])
+		if r != 8 {
+			d[4], d[5] = ek[e-2], ek[e-1]
+		}
+	}
+
+	return c, nil
+}
+
+func (c *ideaCipher) BlockSize() int {
+	return ideaBlockSize
+}
+
+func (c *ideaCipher) Encrypt(dst, src []byte) {
+	ideaCrypt(&c.ek, dst, src)
+}
+
+func (c *ideaCipher) Decrypt(dst, src []byte) {
+	ideaCrypt(&c.dk, dst, src)
+}
+
+// ideaCrypt runs the eight and a half rounds of IDEA over the block
+// src, with the subkeys k, into dst.
+func ideaCrypt(k *[52]uint16, dst, src []byte) {
+	if len(src) < ideaBlockSize || len(dst) < ideaBlockSize {
+		panic("symcrypt: IDEA block too short")
+	}
+
+	x1 := binary.BigEndian.Uint16(src[0:])
+	x2 := binary.BigEndian.Uint16(src[2:])
+	x3 := binary.BigEndian.Uint16(src[4:])
+	x4 := binary.BigEndian.Uint16(src[6:])
+	for r := 0; r < 8; r++ {
+		k := k[6*r:]
+		x1 = ideaMul(x1, k[0])
+		x2 += k[1]
+		x3 += k[2]
+		x4 = ideaMul(x4, k[3])
+
+		s3 := x3
+		x3 = ideaMul(x3^x1, k[4])
+		s2 := x2
+		x2 = ideaMul((x2^x4)+x3, k[5])
+		x3 += x2
+
+		x1 ^= x2
+		x4 ^= x3
+		x2 ^= s3
+		x3 ^= s2
+	}
+
+	binary.BigEndian.PutUint16(dst[0:], ideaMul(x1, k[48]))
+	binary.BigEndian.PutUint16(dst[2:], x3+k[49])
+	binary.BigEndian.PutUint16(dst[4:], x2+k[50])
+	binary.BigEndian.PutUint16(dst[6:], ideaMul(x4, k[51]))
+}
+
+// ideaMul multiplies a and b modulo 2^16+1, where 0 stands for 2^16.
+func ideaMul(a, b uint16) uint16 {
+	switch {
+	case a == 0:
+		return 1 - b
+	case b == 0:
+		return 1 - a
+	}
+
+	p := uint32(a) * uint32(b)
+	lo, hi := uint16(p), uint16(p>>16)
+	if lo < hi {
+		return lo - hi + 1
+	}
+
+	return lo - hi
+}
+
+// ideaMulInv returns the inverse of x under ideaMul.
+func ideaMulInv(x uint16) uint16 {
+	if x <= 1 {
+		// 0, standing for 2^16, is its own inverse, as 1 is
+		return x
+	}
+
+	t0, t1 := int32(0), int32(1)
+	r0, r1 := int32(0x10001), int32(x)
+	for r1 != 0 {
+		q := r0 / r1
+		r0, r1 = r1, r0-q*r1
+		t0, t1 = t1, t0-q*t1
+	}
+	if t0 < 0 {
+		t0 += 0x10001
+	}
+
+	return uint16(t0)
+}
+
+// info returns what there is to know of c, a supported or a legacy
+// cipher.
+func (c Cipher) info() (cipherInfo, bool) {
+	if info, ok := ciphers[c]; ok {
+		return info, true
+	}
+	info, ok := legacyCiphers[c]
+
+	return info, ok
+}
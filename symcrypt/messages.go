@@ -0,0 +1,102 @@
+package symcrypt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errMessagesClosed = errors.New("symcrypt: MessageReader closed")
+
+// A MessageReader decrypts the messages in a stream of several back to
+// back, binary or armored, as some appenders write them, e.g. to an
+// encrypted log, one at a time as they are asked for. It is not safe
+// for concurrent use.
+type MessageReader struct {
+	d   *Decryptor
+	ctx context.Context
+	in  *countReader
+	br  *bufio.Reader
+	n   int
+	// The plain text of the message being decrypted, and the error
+	// decrypting it ended with, once it has
+	pr   *io.PipeReader
+	done chan error
+	err  error
+}
+
+// Messages returns a MessageReader of the messages in src. Like
+// Decrypt, it asks for the passphrase for each message, unless the
+// provider remembers it. The maximum cipher text size applies to all
+// of src; progress is not reported.
+func (d *Decryptor) Messages(ctx context.Context, src io.Reader) *MessageReader {
+	in := &countReader{r: ctxReader{ctx, src}, max: d.maxCiphertext}
+
+	return &MessageReader{d: d, ctx: ctx, in: in, br: bufio.NewReader(in)}
+}
+
+// NextMessage starts decrypting the next message, and returns its
+// Result and a reader of its plain text, which, as with Decrypt, must
+// be discarded if reading it fails. The Result is only complete once
+// the plain text has been read to the end. Whatever of the plain text
+// before has not been read is skipped, still checking it. io.EOF is
+// returned at the end of the stream, and the error of the message
+// before once one fails, as where the next one starts is then unknown.
+func (mr *MessageReader) NextMessage() (*Result, io.Reader, error) {
+	if mr.pr != nil {
+		io.Copy(io.Discard, mr.pr)
+		if err := <-mr.done; err != nil {
+			mr.err = err
+		}
+		mr.pr = nil
+	}
+	if mr.err != nil {
+		return nil, nil, mr.err
+	}
+	if mr.n > 0 {
+		// Armored messages are separated by blank lines
+		if err := skipSpace(mr.br); err != nil {
+			mr.err = err
+			return nil, nil, err
+		}
+	}
+	mr.n++
+
+	m := &message{config: &mr.d.config}
+	pr, pw := io.Pipe()
+	mr.pr, mr.done = pr, make(chan error, 1)
+	go func(n int) {
+		m.setPhase(PhaseParse)
+		start := mr.in.n - int64(mr.br.Buffered())
+		out := &countWriter{w: phaseWriter{ctxWriter{mr.ctx, pw}, m}, max: mr.d.maxPlaintext}
+		err := m.decryptMessage(out, mr.br, true)
+		m.endPhase()
+		m.res.BytesIn = mr.in.n - int64(mr.br.Buffered()) - start
+		m.res.BytesOut = out.n
+		if err != nil {
+			err = fmt.Errorf("message %d: %w", n, err)
+		}
+		pw.CloseWithError(err)
+		mr.done <- err
+	}(mr.n)
+
+	return &m.res, pr, nil
+}
+
+// Close stops decrypting the message whose plain text has not been
+// read to the end, if any, after which NextMessage fails. It does not
+// close the stream. A MessageReader must be read to io.EOF, or closed.
+func (mr *MessageReader) Close() error {
+	if mr.pr != nil {
+		mr.pr.CloseWithError(errMessagesClosed)
+		<-mr.done
+		mr.pr = nil
+	}
+	if mr.err == nil {
+		mr.err = errMessagesClosed
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package symcrypt
+
+import (
+	"crypto/rand"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// IntegrityPolicy says what to do with messages that carry no
+// modification detection code.
+type IntegrityPolicy int
+
+const (
+	// IntegrityRequired rejects messages without an MDC before any
+	// plain text is output. This is the default.
+	IntegrityRequired IntegrityPolicy = iota
+	// IntegrityOptional decrypts them; Result.Integrity reports
+	// whether the plain text was authenticated.
+	IntegrityOptional
+)
+
+// Phase is a step of decrypting a message.
+type Phase string
+
+// Phases, in the order they are first entered
+const (
+	PhaseParse      Phase = "parse"
+	PhaseRead       Phase = "read"
+	PhaseArmor      Phase = "armor"
+	PhasePassphrase Phase = "passphrase"
+	PhaseS2K        Phase = "s2k"
+	PhaseDecrypt    Phase = "decrypt"
+	PhaseDecompress Phase = "decompress"
+	PhaseWrite      Phase = "write"
+)
+
+// An Option configures a Decryptor or an encrypting writer. Options
+// that do not apply to one of them are ignored by it.
+type Option func(*config)
+
+type config struct {
+	passphrase    PassphraseProvider
+	retries       int
+	ciphers       map[Cipher]bool
+	integrity     IntegrityPolicy
+	maxPlaintext  int64
+	maxCiphertext int64
+	maxNesting    int
+	phase         func(Phase)
+	packetDump    io.Writer
+	keyring       openpgp.KeyRing
+	keyPassphrase PassphraseProvider
+	signerLookup  func(keyID uint64, userID string) []openpgp.Key
+	agentKeys     bool
+	fips          bool
+	// Decryption only
+	ignoreArmorCRC bool
+	noDecompress   bool
+	legacyCiphers  bool
+
+	progress         func(Progress)
+	progressInterval time.Duration
+
+	// Encryption only
+	cipher      Cipher
+	compression packet.CompressionAlgo
+	s2kCount    int
+	fileName    string
+	modTime     time.Time
+	signer      *openpgp.Entity
+	recipients  []*openpgp.Entity
+	sessionKey  func(Cipher, []byte)
+	rand        io.Reader
+}
+
+// WithPassphrase sets the passphrase to decrypt with.
+func WithPassphrase(passphrase []byte) Option {
+	return WithPassphraseProvider(StaticPassphrase(passphrase))
+}
+
+// WithPassphraseFunc makes the Decryptor call f for the passphrase,
+// once per message, when it finds that one is needed.
+func WithPassphraseFunc(f func() ([]byte, error)) Option {
+	return WithPassphraseProvider(PassphraseFunc(f))
+}
+
+// WithPassphraseProvider makes the Decryptor get the passphrase from p.
+func WithPassphraseProvider(p PassphraseProvider) Option {
+	return func(c *config) {
+		c.passphrase = p
+	}
+}
+
+// WithKeyPassphrase makes the Decryptor unlock passphrase protected
+// secret keys in the key ring with the passphrase from p, rather than
+// with the one for the message, as exported keys usually have one of
+// their own. A wrong one is forgotten, if p is a Forgetter, and the key
+// is passed over.
+func WithKeyPassphrase(p PassphraseProvider) Option {
+	return func(c *config) {
+		c.keyPassphrase = p
+	}
+}
+
+// WithPassphraseRetries lets the Decryptor ask for the passphrase up to
+// n more times if it is wrong, telling the provider to forget the wrong
+// one if it is a Forgetter.
+func WithPassphraseRetries(n int) Option {
+	return func(c *config) {
+		c.retries = n
+	}
+}
+
+// WithAllowedCiphers restricts the session ciphers that messages may
+// use. By default, every supported cipher is allowed.
+func WithAllowedCiphers(ciphers ...Cipher) Option {
+	return func(c *config) {
+		c.ciphers = make(map[Cipher]bool)
+		for _, ci := range ciphers {
+			c.ciphers[ci] = true
+		}
+	}
+}
+
+// WithIntegrityPolicy sets the policy for messages without an MDC.
+func WithIntegrityPolicy(p IntegrityPolicy) Option {
+	return func(c *config) {
+		c.integrity = p
+	}
+}
+
+// WithMaxPlaintextSize makes decryption fail once more than n bytes of
+// plain text have been produced, e.g. to stop decompression bombs.
+func WithMaxPlaintextSize(n int64) Option {
+	return func(c *config) {
+		c.maxPlaintext = n
+	}
+}
+
+// WithMaxCiphertextSize makes decryption fail once more than n bytes
+// of input have been read.
+func WithMaxCiphertextSize(n int64) Option {
+	return func(c *config) {
+		c.maxCiphertext = n
+	}
+}
+
+// WithMaxNesting makes decryption fail on compressed data packets
+// nested more than n deep, the first being at depth 1, as only a
+// malicious message nests them, e.g. a quine that decompresses to
+// itself. The default is 8.
+func WithMaxNesting(n int) Option {
+	return func(c *config) {
+		c.maxNesting = n
+	}
+}
+
+// WithPhaseFunc makes the Decryptor call f, on the decrypting
+// goroutine, whenever it moves from one phase to another. This is
+// meant for profiler labels, so f should be cheap.
+func WithPhaseFunc(f func(Phase)) Option {
+	return func(c *config) {
+		c.phase = f
+	}
+}
+
+// WithPacketDump makes the Decryptor write each packet header to w as
+// it is parsed, with its offset, in the message with the armor removed
+// or in the decrypted or decompressed data it is in, and an annotated
+// hexdump of its tag and length octets, and of the length of each
+// partial body chunk: a way to tell what is wrong with a malformed
+// message from another implementation.
+func WithPacketDump(w io.Writer) Option {
+	return func(c *config) {
+		c.packetDump = w
+	}
+}
+
+// WithIgnoreArmorChecksum makes a Decryptor go on when the CRC-24 of
+// ASCII armored input does not match, e.g. because the armor was
+// mangled in transit, and report it in the Result instead. The MDC
+// still protects the plain text. By default, it is an
+// ErrArmorChecksum error.
+func WithIgnoreArmorChecksum() Option {
+	return func(c *config) {
+		c.ignoreArmorCRC = true
+	}
+}
+
+// WithNoDecompress makes a Decryptor write the contents of a
+// compressed data packet as they are, without decompressing them, e.g.
+// for forensics or to keep the data compressed in storage. The
+// algorithm is reported in the Result. The MDC is still checked, but
+// nothing inside the compressed data, such as the literal data
+// metadata or a signature, is read. By default, it is decompressed.
+func WithNoDecompress() Option {
+	return func(c *config) {
+		c.noDecompress = true
+	}
+}
+
+// WithLegacyCiphers makes a Decryptor decrypt messages encrypted with
+// the legacy ciphers, IDEA and Blowfish, among them PGP 2
+// messages, which have no session key packet and are encrypted with
+// IDEA keyed with the MD5 of the passphrase. They are still subject to
+// WithAllowedCiphers and the integrity policy, and FIPS mode refuses
+// them. By default, they are ErrLegacyCipher errors.
+func WithLegacyCiphers() Option {
+	return func(c *config) {
+		c.legacyCiphers = true
+	}
+}
+
+// WithCipher sets the cipher to encrypt with. The default is AES-256.
+func WithCipher(ci Cipher) Option {
+	return func(c *config) {
+		c.cipher = ci
+	}
+}
+
+// WithCompression sets the compression algorithm to apply before
+// encrypting. The default is none, as what is encrypted is usually
+// compressed already.
+func WithCompression(algo packet.CompressionAlgo) Option {
+	return func(c *config) {
+		c.compression = algo
+	}
+}
+
+// WithS2KCount sets the number of bytes hashed by the iterated and
+// salted S2K when encrypting. It is rounded up to the next value that
+// can be encoded. The default is the maximum, 65011712.
+func WithS2KCount(n int) Option {
+	return func(c *config) {
+		c.s2kCount = n
+	}
+}
+
+// WithFileName sets the file name recorded in the literal data packet
+// when encrypting. By default there is none.
+func WithFileName(name string) Option {
+	return func(c *config) {
+		c.fileName = name
+	}
+}
+
+// WithSigner makes the encrypting writer sign the plain text with the
+// signing key of e, whose private key must have been decrypted, so
+// that the recipient can tell who made the message. By default, it is
+// not signed.
+func WithSigner(e *openpgp.Entity) Option {
+	return func(c *config) {
+		c.signer = e
+	}
+}
+
+// WithRecipients makes the encrypting writer also encrypt the session
+// key to the encryption keys of the recipients, so that the message can
+// be decrypted either with the passphrase or with one of their secret
+// keys, e.g. for key escrow. By default, only the passphrase can.
+func WithRecipients(recipients ...*openpgp.Entity) Option {
+	return func(c *config) {
+		c.recipients = recipients
+	}
+}
+
+// WithSessionKeyFunc makes the encrypting writer pass the random
+// session key of the message, and its cipher, to f, before anything is
+// encrypted, e.g. to escrow it: the key decrypts the message whatever
+// the passphrase. f must not modify key.
+func WithSessionKeyFunc(f func(ci Cipher, key []byte)) Option {
+	return func(c *config) {
+		c.sessionKey = f
+	}
+}
+
+// WithModTime sets the modification time recorded in the literal data
+// packet when encrypting. By default there is none.
+func WithModTime(t time.Time) Option {
+	return func(c *config) {
+		c.modTime = t
+	}
+}
+
+// WithRand sets the source of the random session key, S2K salt and IV
+// when encrypting, and when wrapping a session key, e.g. a
+// deterministic one to reproduce test vectors, or a hardware
+// generator. The default is crypto/rand.Reader.
+func WithRand(r io.Reader) Option {
+	return func(c *config) {
+		c.rand = r
+	}
+}
+
+// random returns the source of randomness of WithRand.
+func (c *config) random() io.Reader {
+	if c.rand == nil {
+		return rand.Reader
+	}
+
+	return c.rand
+}
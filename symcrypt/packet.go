@@ -0,0 +1,237 @@
+package symcrypt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Packet tags, RFC 4880 section 4.3
+const (
+	tagPKESK      = 1
+	tagSignature  = 2
+	tagSKESK      = 3
+	tagOnePassSig = 4
+	tagCompressed = 8
+	tagSE         = 9
+	tagMarker     = 10
+	tagLiteral    = 11
+	tagSEIPD      = 18
+	tagAEAD       = 20
+)
+
+var tagNames = map[int]string{
+	tagPKESK:      "public-key encrypted session key",
+	tagSignature:  "signature",
+	tagSKESK:      "symmetric-key encrypted session key",
+	tagOnePassSig: "one-pass signature",
+	tagCompressed: "compressed data",
+	tagSE:         "symmetrically encrypted data",
+	tagMarker:     "marker",
+	tagLiteral:    "literal data",
+	tagSEIPD:      "symmetrically encrypted and integrity protected data",
+	tagAEAD:       "AEAD encrypted data",
+}
+
+func tagName(tag int) string {
+	if name, ok := tagNames[tag]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("packet type %d", tag)
+}
+
+// peekTag returns the tag of the next packet in r without consuming
+// anything, or io.EOF at the end of r.
+func peekTag(r *bufio.Reader) (int, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return headerTag(b[0])
+}
+
+func headerTag(b byte) (int, error) {
+	switch {
+	case b&0x80 == 0:
+		return 0, fmt.Errorf("%w: packet tag byte %#02x does not have its high bit set",
+			ErrInvalidMessage, b)
+	case b&0x40 != 0:
+		// New format
+		return int(b & 0x3f), nil
+	default:
+		return int(b&0x3f) >> 2, nil
+	}
+}
+
+// readPacket reads the header of the next packet from r and returns its
+// tag and a reader for its body, which must be consumed before the next
+// packet can be read. It returns io.EOF if r is at its end.
+func readPacket(r io.Reader) (int, io.Reader, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, nil, err
+	}
+
+	tag, err := headerTag(b[0])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if b[0]&0x40 != 0 {
+		length, partial, err := readNewLength(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if partial {
+			return tag, &partialReader{r: r, remaining: length}, nil
+		}
+		return tag, &exactReader{r: r, remaining: length}, nil
+	}
+
+	// Old format: the low two bits give the size of the length
+	var n int
+	switch b[0] & 3 {
+	case 0:
+		n = 1
+	case 1:
+		n = 2
+	case 2:
+		n = 4
+	case 3:
+		// Indeterminate: the packet extends to the end of r
+		return tag, r, nil
+	}
+
+	var lb [4]byte
+	if _, err := io.ReadFull(r, lb[:n]); err != nil {
+		return 0, nil, unexpected(err)
+	}
+	var length int64
+	for _, c := range lb[:n] {
+		length = length<<8 | int64(c)
+	}
+
+	return tag, &exactReader{r: r, remaining: length}, nil
+}
+
+// readNewLength reads a new format packet length, RFC 4880 section
+// 4.2.2, reporting whether it is a partial body length.
+func readNewLength(r io.Reader) (length int64, partial bool, err error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return 0, false, unexpected(err)
+	}
+
+	switch c := b[0]; {
+	case c < 192:
+		return int64(c), false, nil
+	case c < 224:
+		if _, err := io.ReadFull(r, b[1:2]); err != nil {
+			return 0, false, unexpected(err)
+		}
+		return int64(c-192)<<8 + int64(b[1]) + 192, false, nil
+	case c < 255:
+		return 1 << (c & 0x1f), true, nil
+	default:
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, false, unexpected(err)
+		}
+		return int64(b[0])<<24 | int64(b[1])<<16 | int64(b[2])<<8 |
+			int64(b[3]), false, nil
+	}
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// exactReader reads a packet body of known length, reporting a body
+// that ends early as io.ErrUnexpectedEOF.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (er *exactReader) Read(p []byte) (int, error) {
+	if er.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > er.remaining {
+		p = p[:er.remaining]
+	}
+
+	n, err := er.r.Read(p)
+	er.remaining -= int64(n)
+	if err == io.EOF && er.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+// partialReader reads a packet body made of partial body lengths,
+// RFC 4880 section 4.2.2.4.
+type partialReader struct {
+	r         io.Reader
+	remaining int64
+	last      bool
+	// The stream it is read from, with WithPacketDump
+	stream *packetStream
+}
+
+func (pr *partialReader) Read(p []byte) (int, error) {
+	for pr.remaining == 0 {
+		if pr.last {
+			return 0, io.EOF
+		}
+
+		if pr.stream != nil {
+			pr.stream.begin()
+		}
+		length, partial, err := readNewLength(pr.r)
+		if pr.stream != nil {
+			pr.stream.rec = false
+			if err == nil {
+				pr.stream.dumpChunk(length, !partial)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		pr.remaining, pr.last = length, !partial
+	}
+
+	if int64(len(p)) > pr.remaining {
+		p = p[:pr.remaining]
+	}
+
+	n, err := pr.r.Read(p)
+	pr.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// readBody reads a packet body that is expected to be small, such as
+// a session key packet, in full.
+func readBody(body io.Reader, max int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("%w: packet longer than %d bytes", ErrInvalidMessage, max)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,79 @@
+package symcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// The functions in this file are the parsers of untrusted input,
+// exposed one layer at a time over byte slices, so that each can be
+// fuzzed, or used, on its own: whether data is armored, the armor
+// itself, the framing of a packet, and a session key packet. They do
+// no I/O and hold no state. However malformed the input, they only
+// fail with an error: one wrapping ErrInvalidMessage, or another of
+// this package's errors where one applies, or io.ErrUnexpectedEOF for
+// data that ends inside a packet.
+
+// Armored tells whether data starts like an ASCII armored block, as
+// Decryptor.Decrypt and Inspect tell armored messages from binary ones.
+func Armored(data []byte) bool {
+	return bytes.HasPrefix(data, armorStart)
+}
+
+// DecodeArmor decodes the ASCII armored message at the start of data,
+// checking its CRC-24, and returns the binary message and the armor
+// headers.
+func DecodeArmor(data []byte) ([]byte, map[string]string, error) {
+	if !Armored(data) {
+		return nil, nil, fmt.Errorf("%w: armor: no BEGIN line", ErrInvalidMessage)
+	}
+
+	br, block, err := dearmor(bufio.NewReader(bytes.NewReader(data)), false, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return body, block.Header, nil
+}
+
+// SplitPacket parses the packet at the start of the binary message
+// data, in the old or the new format of RFC 4880 section 4.2, and
+// returns its tag, its body, with any partial body lengths joined, and
+// the rest of data after it. A packet of indeterminate length extends
+// to the end of data. At the end of data, it returns io.EOF.
+func SplitPacket(data []byte) (tag int, body, rest []byte, err error) {
+	r := bytes.NewReader(data)
+	tag, br, err := readPacket(r)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if body, err = io.ReadAll(br); err != nil {
+		return 0, nil, nil, err
+	}
+
+	return tag, body, data[len(data)-r.Len():], nil
+}
+
+// ParseSKESK parses the body of a symmetric-key encrypted session key
+// packet, RFC 4880 section 5.3, with its S2K specifier. Only version 4,
+// the one this package decrypts, is parsed; the AEAD versions 5 and 6
+// fail with ErrAEAD.
+func ParseSKESK(body []byte) (SKESKInfo, error) {
+	s, err := parseSKESK(body)
+	if err != nil {
+		return SKESKInfo{}, err
+	}
+
+	return SKESKInfo{
+		Version:      int(body[0]),
+		Cipher:       s.cipher,
+		S2K:          s.s2k,
+		EncryptedKey: s.encryptedKey != nil,
+	}, nil
+}
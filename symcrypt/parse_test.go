@@ -0,0 +1,142 @@
+package symcrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// vectorSeeds returns the selftest vectors, as the armored ones are
+// and as the binary messages that they and the others hold.
+func vectorSeeds(f *testing.F) (armored, binary [][]byte) {
+	names, err := filepath.Glob("../vectors/*")
+	if err != nil || len(names) == 0 {
+		f.Fatalf("no vectors: %v", err)
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if !Armored(data) {
+			binary = append(binary, data)
+			continue
+		}
+		armored = append(armored, data)
+		msg, _, err := DecodeArmor(data)
+		if err != nil {
+			f.Fatalf("%s: %v", name, err)
+		}
+		binary = append(binary, msg)
+	}
+
+	return armored, binary
+}
+
+// checkParseError fails t unless err is one that the parsers document.
+func checkParseError(t *testing.T, err error) {
+	for _, target := range []error{ErrInvalidMessage, ErrUnsupported, ErrUnsupportedCipher,
+		ErrArmorChecksum, ErrTooLarge, io.ErrUnexpectedEOF} {
+		if errors.Is(err, target) {
+			return
+		}
+	}
+	t.Errorf("undocumented error: %v", err)
+}
+
+func FuzzSplitPacket(f *testing.F) {
+	_, binary := vectorSeeds(f)
+	for _, msg := range binary {
+		for len(msg) > 0 {
+			f.Add(msg)
+			_, _, rest, err := SplitPacket(msg)
+			if err != nil {
+				f.Fatal(err)
+			}
+			msg = rest
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tag, body, rest, err := SplitPacket(data)
+		switch {
+		case len(data) == 0:
+			if err != io.EOF {
+				t.Errorf("empty data: got %v, want io.EOF", err)
+			}
+			return
+		case err != nil:
+			checkParseError(t, err)
+			return
+		}
+		if tag < 0 || tag > 63 {
+			t.Errorf("tag %d out of range", tag)
+		}
+		if len(rest) >= len(data) || !bytes.HasSuffix(data, rest) {
+			t.Errorf("rest of %d bytes is not what follows a packet in %d", len(rest), len(data))
+		}
+		if len(body) > len(data)-len(rest) {
+			t.Errorf("body of %d bytes from a packet of %d", len(body), len(data)-len(rest))
+		}
+	})
+}
+
+func FuzzParseSKESK(f *testing.F) {
+	_, binary := vectorSeeds(f)
+	for _, msg := range binary {
+		tag, body, _, err := SplitPacket(msg)
+		if err != nil {
+			f.Fatal(err)
+		}
+		if tag == 3 {
+			f.Add(body)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		info, err := ParseSKESK(body)
+		if err != nil {
+			checkParseError(t, err)
+			return
+		}
+		if info.Version != 4 {
+			t.Errorf("parsed a version %d SKESK", info.Version)
+		}
+	})
+}
+
+func FuzzDecodeArmor(f *testing.F) {
+	armored, _ := vectorSeeds(f)
+	for _, data := range armored {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, _, err := DecodeArmor(data)
+		if err != nil {
+			checkParseError(t, err)
+			return
+		}
+
+		// What was decoded must armor and decode again to itself
+		var buf bytes.Buffer
+		aw, err := NewArmorWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aw.Write(msg)
+		if err := aw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		again, _, err := DecodeArmor(buf.Bytes())
+		if err != nil {
+			t.Fatalf("armoring %d decoded bytes again: %v", len(msg), err)
+		}
+		if !bytes.Equal(again, msg) {
+			t.Errorf("armoring %d decoded bytes again decodes to %d others", len(msg), len(again))
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package symcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// A PassphraseProvider supplies the passphrase for a message. The
+// Decryptor asks for it once it has found that the message is
+// passphrase encrypted, and again for each retry.
+type PassphraseProvider interface {
+	Passphrase() ([]byte, error)
+}
+
+// A Forgetter is a PassphraseProvider that can be told that the
+// passphrase it supplied was wrong, so that it does not supply it
+// again, e.g. from a cache.
+type Forgetter interface {
+	Forget()
+}
+
+// PassphraseFunc adapts a function to a PassphraseProvider.
+type PassphraseFunc func() ([]byte, error)
+
+// Passphrase calls f.
+func (f PassphraseFunc) Passphrase() ([]byte, error) {
+	return f()
+}
+
+// StaticPassphrase always supplies passphrase.
+func StaticPassphrase(passphrase []byte) PassphraseProvider {
+	return PassphraseFunc(func() ([]byte, error) {
+		return passphrase, nil
+	})
+}
+
+// PromptPassphrase prints prompt on stderr and reads a passphrase from
+// the terminal attached to stdin without echoing it. x/term takes care
+// of the platform details: termios on Unix ttys and SetConsoleMode on
+// the Windows console. When stdin is not a terminal, e.g. as it carries
+// the cipher text, as with curl ... | decrypt-symmetric, it prompts at
+// the controlling terminal, /dev/tty, or the console, CONIN$ and
+// CONOUT$, instead.
+func PromptPassphrase(prompt string) PassphraseProvider {
+	return PassphraseFunc(func() ([]byte, error) {
+		in, out := os.Stdin, os.Stderr
+		if !term.IsTerminal(int(in.Fd())) {
+			var err error
+			if in, err = os.OpenFile(terminalIn, os.O_RDWR, 0); err != nil {
+				return nil, fmt.Errorf("symcrypt: no passphrase supplied, stdin is not a terminal, "+
+					"and there is no terminal to prompt at: %w", err)
+			}
+			defer in.Close()
+			if out, err = os.OpenFile(terminalOut, os.O_WRONLY, 0); err != nil {
+				return nil, fmt.Errorf("symcrypt: no passphrase supplied, stdin is not a terminal, "+
+					"and there is no terminal to prompt at: %w", err)
+			}
+			defer out.Close()
+		}
+
+		fmt.Fprint(out, prompt)
+		pass, err := term.ReadPassword(int(in.Fd()))
+		// The user's newline was swallowed along with the echo.
+		fmt.Fprintln(out)
+		if err != nil {
+			return nil, fmt.Errorf("symcrypt: reading passphrase: %w", err)
+		}
+
+		return pass, nil
+	})
+}
+
+// PromptNewPassphrase is like PromptPassphrase, but for a passphrase
+// that is being chosen, e.g. to encrypt with: it asks again with
+// repeat, and fails with ErrPassphraseMismatch if the two differ, so
+// that a typo does not lock the data away.
+func PromptNewPassphrase(prompt, repeat string) PassphraseProvider {
+	return PassphraseFunc(func() ([]byte, error) {
+		pass, err := PromptPassphrase(prompt).Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		again, err := PromptPassphrase(repeat).Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, ErrPassphraseMismatch
+		}
+
+		return pass, nil
+	})
+}
+
+// ReaderPassphrase supplies the first line of r, without its line
+// ending. r is read a byte at a time, so that nothing after the line is
+// consumed, and only read again once the passphrase is forgotten, when
+// the next line is supplied.
+func ReaderPassphrase(r io.Reader) PassphraseProvider {
+	return Cache(PassphraseFunc(func() ([]byte, error) {
+		line := []byte{}
+		var b [1]byte
+		for {
+			n, err := r.Read(b[:])
+			if n == 1 {
+				if b[0] == '\n' {
+					break
+				}
+				line = append(line, b[0])
+			}
+			if err == io.EOF {
+				if len(line) == 0 {
+					return nil, errors.New("symcrypt: no passphrase: end of input")
+				}
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("symcrypt: reading passphrase: %w", err)
+			}
+		}
+
+		return trimNewline(line), nil
+	}))
+}
+
+// FDPassphrase supplies the first line read from the file descriptor
+// fd, like gpg --passphrase-fd.
+func FDPassphrase(fd uintptr) PassphraseProvider {
+	return ReaderPassphrase(os.NewFile(fd, fmt.Sprintf("fd %d", fd)))
+}
+
+// FilePassphrase supplies the first line of the file named name.
+func FilePassphrase(name string) PassphraseProvider {
+	return PassphraseFunc(func() ([]byte, error) {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("symcrypt: reading passphrase: %w", err)
+		}
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[:i+1]
+		}
+
+		return trimNewline(b), nil
+	})
+}
+
+// EnvPassphrase supplies the value of the environment variable name,
+// which must be set.
+func EnvPassphrase(name string) PassphraseProvider {
+	return PassphraseFunc(func() ([]byte, error) {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("symcrypt: environment variable %s is not set", name)
+		}
+
+		return []byte(v), nil
+	})
+}
+
+func trimNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
+
+// Cache wraps p so that it is asked only once, and its passphrase is
+// reused until it is forgotten.
+func Cache(p PassphraseProvider) PassphraseProvider {
+	return &cache{p: p}
+}
+
+type cache struct {
+	p PassphraseProvider
+
+	mu         sync.Mutex
+	passphrase []byte
+}
+
+func (c *cache) Passphrase() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.passphrase != nil {
+		return c.passphrase, nil
+	}
+
+	pass, err := c.p.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	c.passphrase = pass
+
+	return pass, nil
+}
+
+func (c *cache) Forget() {
+	c.mu.Lock()
+	c.passphrase = nil
+	c.mu.Unlock()
+
+	if f, ok := c.p.(Forgetter); ok {
+		f.Forget()
+	}
+}
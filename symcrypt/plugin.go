@@ -0,0 +1,102 @@
+package symcrypt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PluginPrefix is the prefix of the names of passphrase plugin
+// executables, which are looked up on PATH.
+const PluginPrefix = "decsym-passphrase-"
+
+// PluginRequest is what a passphrase plugin reads, as one JSON object,
+// on its stdin. Action is "get" or "forget"; ID identifies the
+// passphrase, e.g. for the plugin's own cache or a vault item.
+type PluginRequest struct {
+	Version     int    `json:"version"`
+	Action      string `json:"action"`
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+}
+
+// PluginResponse is what a passphrase plugin writes, as one JSON object,
+// on its stdout, before exiting with status 0. Error is set instead of
+// Passphrase if it could not get one. A forget request needs no
+// response.
+type PluginResponse struct {
+	Passphrase string `json:"passphrase,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// pluginVersion is the version of the protocol in PluginRequest.
+const pluginVersion = 1
+
+// PluginPassphrase gets the passphrase from the executable named
+// PluginPrefix+name on PATH, which can fetch it from anywhere, e.g. a
+// password manager. Its stderr is passed through, and it may prompt on
+// the terminal itself.
+func PluginPassphrase(name, id, desc string) PassphraseProvider {
+	return &plugin{name: name, id: id, desc: desc}
+}
+
+type plugin struct {
+	name string
+	id   string
+	desc string
+}
+
+func (p *plugin) Passphrase() ([]byte, error) {
+	out, err := p.run("get")
+	if err != nil {
+		return nil, err
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("symcrypt: plugin %s: bad response: %w", p.name, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("symcrypt: plugin %s: %s", p.name, resp.Error)
+	}
+
+	return []byte(resp.Passphrase), nil
+}
+
+func (p *plugin) Forget() {
+	p.run("forget")
+}
+
+func (p *plugin) run(action string) ([]byte, error) {
+	if p.name == "" || strings.ContainsAny(p.name, `/\`) {
+		return nil, errors.New("symcrypt: bad plugin name " + p.name)
+	}
+	path, err := exec.LookPath(PluginPrefix + p.name)
+	if err != nil {
+		return nil, fmt.Errorf("symcrypt: plugin %s: %w", p.name, err)
+	}
+
+	req, err := json.Marshal(PluginRequest{
+		Version:     pluginVersion,
+		Action:      action,
+		ID:          p.id,
+		Description: p.desc,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(append(req, '\n'))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("symcrypt: plugin %s: %w", p.name, err)
+	}
+
+	return out, nil
+}
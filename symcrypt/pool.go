@@ -0,0 +1,64 @@
+package symcrypt
+
+import (
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+// Buffers and decompressors are reused across messages, so that
+// decrypting or encrypting many small ones is not dominated by
+// allocating them: a flate reader alone is some 40 KiB.
+
+const copyBufferSize = 32 << 10
+
+var copyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, copyBufferSize)
+		return &b
+	},
+}
+
+// copyBuffer is io.Copy with a pooled buffer.
+func copyBuffer(w io.Writer, r io.Reader) (int64, error) {
+	b := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(b)
+
+	return io.CopyBuffer(w, r, *b)
+}
+
+var flateReaders, zlibReaders sync.Pool
+
+// newFlateReader returns a flate reader of r, to be given back with
+// putFlateReader once it is no longer read.
+func newFlateReader(r io.Reader) io.ReadCloser {
+	if fr, ok := flateReaders.Get().(io.ReadCloser); ok {
+		fr.(flate.Resetter).Reset(r, nil)
+		return fr
+	}
+
+	return flate.NewReader(r)
+}
+
+func putFlateReader(fr io.ReadCloser) {
+	flateReaders.Put(fr)
+}
+
+// newZlibReader returns a zlib reader of r, to be given back with
+// putZlibReader once it is no longer read.
+func newZlibReader(r io.Reader) (io.ReadCloser, error) {
+	if zr, ok := zlibReaders.Get().(io.ReadCloser); ok {
+		if err := zr.(zlib.Resetter).Reset(r, nil); err != nil {
+			zlibReaders.Put(zr)
+			return nil, err
+		}
+		return zr, nil
+	}
+
+	return zlib.NewReader(r)
+}
+
+func putZlibReader(zr io.ReadCloser) {
+	zlibReaders.Put(zr)
+}
@@ -0,0 +1,76 @@
+package symcrypt
+
+import (
+	"time"
+)
+
+// Progress is reported to the function set with WithProgress.
+type Progress struct {
+	// Bytes of input read and of output written so far: cipher text
+	// and plain text respectively when decrypting, and the other way
+	// round when encrypting
+	BytesIn  int64
+	BytesOut int64
+	// The current phase, when decrypting
+	Phase Phase
+	// Set on the last report, once the operation has finished,
+	// successfully or not
+	Done bool
+}
+
+// WithProgress makes decryption and encryption call f with their
+// progress, at most once per interval, and once more when they are
+// done. f is called on the goroutine doing the work, which it holds
+// up, so it should only hand the report on, e.g. to a UI.
+func WithProgress(interval time.Duration, f func(Progress)) Option {
+	return func(c *config) {
+		c.progress = f
+		c.progressInterval = interval
+	}
+}
+
+// progressReporter rate limits progress reports.
+type progressReporter struct {
+	f        func(Progress)
+	interval time.Duration
+	next     time.Time
+	// Returns the progress so far
+	get func() Progress
+}
+
+func newProgressReporter(c *config, get func() Progress) *progressReporter {
+	if c.progress == nil {
+		return nil
+	}
+
+	return &progressReporter{
+		f:        c.progress,
+		interval: c.progressInterval,
+		next:     time.Now().Add(c.progressInterval),
+		get:      get,
+	}
+}
+
+// tick reports progress if the interval has passed since the last
+// report.
+func (pr *progressReporter) tick() {
+	if pr == nil {
+		return
+	}
+
+	if now := time.Now(); !now.Before(pr.next) {
+		pr.next = now.Add(pr.interval)
+		pr.f(pr.get())
+	}
+}
+
+// done makes the last report.
+func (pr *progressReporter) done() {
+	if pr == nil {
+		return
+	}
+
+	p := pr.get()
+	p.Done = true
+	pr.f(p)
+}
@@ -0,0 +1,85 @@
+package symcrypt
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Reencrypt decrypts the message in src with oldPass, as a Decryptor
+// configured by opts does, and encrypts its plain text with newPass,
+// as NewEncryptWriter does with opts, into dst as it is decrypted: the
+// plain text is neither held in memory nor written anywhere else, so
+// that passphrases can be rotated on messages of any size. The file
+// name and modification time of the literal data are kept, unless opts
+// set others.
+//
+// The Result is that of the decryption. The new message is only
+// finished once the old one has decrypted and its integrity has been
+// checked according to the policy; if Reencrypt fails, what has been
+// written to dst is an unfinished message that must be discarded.
+func Reencrypt(dst io.Writer, src io.Reader, oldPass, newPass []byte, opts ...Option) (Result, error) {
+	return ReencryptContext(context.Background(), dst, src, oldPass, newPass, opts...)
+}
+
+// ReencryptContext is like Reencrypt, but stops between chunks once ctx
+// is done, returning its cause.
+func ReencryptContext(ctx context.Context, dst io.Writer, src io.Reader, oldPass, newPass []byte, opts ...Option) (Result, error) {
+	if len(newPass) == 0 {
+		return Result{}, fmt.Errorf("%w: empty passphrase", ErrNoPassphrase)
+	}
+
+	d := NewDecryptor(append(opts[:len(opts):len(opts)], WithPassphrase(oldPass))...)
+	m := &message{config: &d.config}
+	rw := &reencryptWriter{m: m, dst: ctxWriter{ctx, dst}, pass: newPass, opts: opts}
+	err := m.decrypt(ctxWriter{ctx, rw}, ctxReader{ctx, src})
+	if err == nil {
+		err = rw.Close()
+	}
+
+	return m.res, err
+}
+
+// reencryptWriter encrypts the plain text written to it into dst,
+// starting the message at the first write, once the literal data
+// metadata of the message being decrypted is known.
+type reencryptWriter struct {
+	m    *message
+	dst  io.Writer
+	pass []byte
+	opts []Option
+	w    io.WriteCloser
+}
+
+func (rw *reencryptWriter) start() error {
+	if rw.w != nil {
+		return nil
+	}
+
+	opts := append([]Option{WithFileName(rw.m.res.FileName), WithModTime(rw.m.res.ModTime)}, rw.opts...)
+	w, err := NewEncryptWriter(rw.dst, rw.pass, opts...)
+	if err != nil {
+		return err
+	}
+	rw.w = w
+
+	return nil
+}
+
+func (rw *reencryptWriter) Write(p []byte) (int, error) {
+	if err := rw.start(); err != nil {
+		return 0, err
+	}
+
+	return rw.w.Write(p)
+}
+
+// Close finishes the new message, which is started first if the plain
+// text was empty.
+func (rw *reencryptWriter) Close() error {
+	if err := rw.start(); err != nil {
+		return err
+	}
+
+	return rw.w.Close()
+}
@@ -0,0 +1,118 @@
+package symcrypt
+
+import (
+	"crypto"
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+
+	"golang.org/x/crypto/openpgp/s2k"
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// S2KMode is an OpenPGP string-to-key specifier type, RFC 4880
+// section 3.7.1.
+type S2KMode uint8
+
+// S2K modes
+const (
+	S2KSimple   S2KMode = 0
+	S2KSalted   S2KMode = 1
+	S2KIterated S2KMode = 3
+)
+
+func (m S2KMode) String() string {
+	switch m {
+	case S2KSimple:
+		return "simple"
+	case S2KSalted:
+		return "salted"
+	case S2KIterated:
+		return "iterated+salted"
+	}
+
+	return fmt.Sprintf("s2k(%d)", uint8(m))
+}
+
+// S2K describes how a key is derived from a passphrase.
+type S2K struct {
+	Mode S2KMode
+	Hash crypto.Hash
+	// Empty in simple mode
+	Salt []byte
+	// The number of bytes hashed in iterated mode
+	Count int
+}
+
+func (s S2K) String() string {
+	switch s.Mode {
+	case S2KSimple:
+		return fmt.Sprintf("%v %v", s.Mode, s.Hash)
+	case S2KSalted:
+		return fmt.Sprintf("%v %v salt %x", s.Mode, s.Hash, s.Salt)
+	}
+
+	return fmt.Sprintf("%v %v salt %x count %d", s.Mode, s.Hash, s.Salt,
+		s.Count)
+}
+
+// parseS2K parses the S2K specifier at the start of b, returning the
+// rest of b.
+func parseS2K(b []byte) (S2K, []byte, error) {
+	if len(b) < 2 {
+		return S2K{}, nil, fmt.Errorf("%w: short S2K specifier", ErrInvalidMessage)
+	}
+
+	s := S2K{Mode: S2KMode(b[0])}
+	h, ok := s2k.HashIdToHash(b[1])
+	if !ok || !h.Available() {
+		return S2K{}, nil, fmt.Errorf("%w S2K hash %d", ErrUnsupported, b[1])
+	}
+	s.Hash = h
+	b = b[2:]
+
+	switch s.Mode {
+	case S2KSimple:
+		return s, b, nil
+	case S2KSalted, S2KIterated:
+	default:
+		return S2K{}, nil, fmt.Errorf("%w S2K mode %d", ErrUnsupported,
+			uint8(s.Mode))
+	}
+
+	if len(b) < 8 {
+		return S2K{}, nil, fmt.Errorf("%w: short S2K salt", ErrInvalidMessage)
+	}
+	s.Salt = append([]byte(nil), b[:8]...)
+	b = b[8:]
+	if s.Mode == S2KSalted {
+		return s, b, nil
+	}
+
+	if len(b) < 1 {
+		return S2K{}, nil, fmt.Errorf("%w: missing S2K count", ErrInvalidMessage)
+	}
+	s.Count = decodeCount(b[0])
+
+	return s, b[1:], nil
+}
+
+// decodeCount decodes an iterated S2K count, RFC 4880 section 3.7.1.3.
+func decodeCount(c byte) int {
+	return (16 + int(c&15)) << (uint(c>>4) + 6)
+}
+
+// derive fills key from passphrase.
+func (s S2K) derive(key, passphrase []byte) {
+	h := s.Hash.New()
+	switch s.Mode {
+	case S2KSimple:
+		s2k.Simple(key, h, passphrase)
+	case S2KSalted:
+		s2k.Salted(key, h, passphrase, s.Salt)
+	case S2KIterated:
+		s2k.Iterated(key, h, passphrase, s.Salt, s.Count)
+	}
+}
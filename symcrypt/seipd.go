@@ -0,0 +1,114 @@
+package symcrypt
+
+import (
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// partialChunk is the size of the partial body lengths that
+// serializeSEIPD writes, 2^16 bytes.
+const partialChunk = 1 << 16
+
+// serializeSEIPD writes the header of a symmetrically encrypted and
+// integrity protected data packet, RFC 4880 section 5.13, for data
+// encrypted with key for c, with an IV from rand, to w, and returns a
+// writer for the data, whose Close writes the MDC. It does not close w. It does what
+// packet.SerializeSymmetricallyEncrypted does, for every cipher of this
+// package.
+func serializeSEIPD(w io.Writer, c Cipher, key []byte, rand io.Reader) (io.WriteCloser, error) {
+	block, err := c.newBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte{0xc0 | tagSEIPD}); err != nil {
+		return nil, err
+	}
+	pw := &partialWriter{w: w}
+
+	iv := make([]byte, block.BlockSize())
+	if _, err := io.ReadFull(rand, iv); err != nil {
+		return nil, err
+	}
+	stream, prefix := packet.NewOCFBEncrypter(block, iv, packet.OCFBNoResync)
+	// Version 1, then the encrypted prefix
+	if _, err := pw.Write(append([]byte{1}, prefix...)); err != nil {
+		return nil, err
+	}
+
+	h := sha1.New()
+	h.Write(iv)
+	h.Write(iv[len(iv)-2:])
+
+	return &seipdWriter{w: cipher.StreamWriter{S: stream, W: pw}, h: h, pw: pw}, nil
+}
+
+// seipdWriter encrypts the data of a symmetrically encrypted and
+// integrity protected data packet, hashing it for the MDC.
+type seipdWriter struct {
+	w  cipher.StreamWriter
+	h  hash.Hash
+	pw *partialWriter
+}
+
+func (sw *seipdWriter) Write(p []byte) (int, error) {
+	sw.h.Write(p)
+	return sw.w.Write(p)
+}
+
+// Close writes the MDC packet and finishes the packet.
+func (sw *seipdWriter) Close() error {
+	// The MDC packet's header, which the hash covers too
+	mdc := []byte{0xd3, sha1.Size}
+	sw.h.Write(mdc)
+	if _, err := sw.w.Write(sw.h.Sum(mdc)); err != nil {
+		return err
+	}
+
+	return sw.pw.Close()
+}
+
+// partialWriter writes a packet body of a length not known in advance
+// to w, in partial body lengths of partialChunk bytes, and the rest
+// with a five-octet length, RFC 4880 section 4.2.2.
+type partialWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (pw *partialWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(pw.buf)+len(p) >= partialChunk {
+		fill := partialChunk - len(pw.buf)
+		pw.buf = append(pw.buf, p[:fill]...)
+		p = p[fill:]
+		// The length is 1 << (the octet & 0x1f)
+		if _, err := pw.w.Write([]byte{0xe0 | 16}); err != nil {
+			return 0, err
+		}
+		if _, err := pw.w.Write(pw.buf); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[:0]
+	}
+	pw.buf = append(pw.buf, p...)
+
+	return n, nil
+}
+
+// Close writes the last of the body.
+func (pw *partialWriter) Close() error {
+	var length [5]byte
+	length[0] = 0xff
+	binary.BigEndian.PutUint32(length[1:], uint32(len(pw.buf)))
+	if _, err := pw.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(pw.buf)
+
+	return err
+}
@@ -0,0 +1,190 @@
+package symcrypt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// PublicKeyAlgo is an OpenPGP public key algorithm, RFC 4880 section
+// 9.1.
+type PublicKeyAlgo uint8
+
+// Public key algorithms that sign
+const (
+	PubKeyRSA     PublicKeyAlgo = 1
+	PubKeyRSASign PublicKeyAlgo = 3
+	PubKeyDSA     PublicKeyAlgo = 17
+	PubKeyECDSA   PublicKeyAlgo = 19
+	PubKeyEdDSA   PublicKeyAlgo = 22
+	PubKeyEd25519 PublicKeyAlgo = 27
+	PubKeyEd448   PublicKeyAlgo = 28
+)
+
+var pubKeyNames = map[PublicKeyAlgo]string{
+	PubKeyRSA:     "RSA",
+	PubKeyRSASign: "RSA",
+	PubKeyDSA:     "DSA",
+	PubKeyECDSA:   "ECDSA",
+	PubKeyEdDSA:   "EdDSA",
+	PubKeyEd25519: "Ed25519",
+	PubKeyEd448:   "Ed448",
+}
+
+func (a PublicKeyAlgo) String() string {
+	if name, ok := pubKeyNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("pubkey(%d)", uint8(a))
+}
+
+// Signature subpacket types, RFC 4880 section 5.2.3.1
+const (
+	subCreationTime      = 2
+	subExpirationTime    = 3
+	subNotation          = 20
+	subSignerUserID      = 28
+	subIssuerFingerprint = 33
+)
+
+// Notation is a notation data subpacket of a signature: name=value
+// pairs that the signer chose to sign along with the data, RFC 4880
+// section 5.2.3.16.
+type Notation struct {
+	Name  string
+	Value []byte
+	// Whether the value is text, rather than binary
+	HumanReadable bool
+	Critical      bool
+}
+
+// subpacket is a signature subpacket.
+type subpacket struct {
+	typ      byte
+	critical bool
+	// Whether it is covered by the signature
+	hashed bool
+	data   []byte
+}
+
+// sigSubpackets returns the hashed and then the unhashed subpackets of
+// the version 4 signature packet body b, or nil if b is not one.
+func sigSubpackets(b []byte) []subpacket {
+	if len(b) < 6 || b[0] != 4 {
+		return nil
+	}
+
+	var subs []subpacket
+	b = b[4:]
+	for area := 0; area < 2; area++ {
+		if len(b) < 2 {
+			return subs
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+n {
+			return subs
+		}
+		for _, sub := range parseSubpackets(b[2 : 2+n]) {
+			sub.hashed = area == 0
+			subs = append(subs, sub)
+		}
+		b = b[2+n:]
+	}
+
+	return subs
+}
+
+// parseSubpackets parses a subpacket area, stopping at anything
+// malformed.
+func parseSubpackets(b []byte) []subpacket {
+	var subs []subpacket
+	for len(b) > 0 {
+		var n int
+		switch {
+		case b[0] < 192:
+			n, b = int(b[0]), b[1:]
+		case b[0] < 255:
+			if len(b) < 2 {
+				return subs
+			}
+			n, b = (int(b[0])-192)<<8+int(b[1])+192, b[2:]
+		default:
+			if len(b) < 5 {
+				return subs
+			}
+			n, b = int(binary.BigEndian.Uint32(b[1:])), b[5:]
+		}
+		if n < 1 || n > len(b) {
+			return subs
+		}
+
+		subs = append(subs, subpacket{
+			typ:      b[0] & 0x7f,
+			critical: b[0]&0x80 != 0,
+			data:     b[1:n],
+		})
+		b = b[n:]
+	}
+
+	return subs
+}
+
+// noteSignature records what the signature packet b says about its
+// signer, whether or not it can be verified. Only the issuer
+// fingerprint is taken from outside the hashed area: the rest is
+// taken on the signature's word.
+func (m *message) noteSignature(b []byte) {
+	for _, sub := range sigSubpackets(b) {
+		if !sub.hashed && sub.typ != subIssuerFingerprint {
+			continue
+		}
+
+		switch sub.typ {
+		case subCreationTime:
+			if len(sub.data) == 4 {
+				m.res.SignatureTime = time.Unix(int64(binary.BigEndian.Uint32(sub.data)), 0)
+			}
+		case subExpirationTime:
+			if len(sub.data) == 4 {
+				m.res.SignatureLifetime = time.Duration(binary.BigEndian.Uint32(sub.data)) * time.Second
+			}
+		case subSignerUserID:
+			m.res.SignerUserID = string(sub.data)
+		case subNotation:
+			if n, ok := parseNotation(sub); ok {
+				m.res.Notations = append(m.res.Notations, n)
+			}
+		case subIssuerFingerprint:
+			// A version byte, then the fingerprint, of which a v4
+			// key's ID is the last 8 bytes
+			fp := sub.data
+			if len(fp) < 9 || m.res.SignerFingerprint != nil ||
+				binary.BigEndian.Uint64(fp[len(fp)-8:]) != m.res.SignerKeyID {
+				continue
+			}
+			m.res.SignerFingerprint = append([]byte(nil), fp[1:]...)
+		}
+	}
+}
+
+// parseNotation parses a notation data subpacket: 4 bytes of flags,
+// the lengths of the name and the value, and then them.
+func parseNotation(sub subpacket) (Notation, bool) {
+	b := sub.data
+	if len(b) < 8 {
+		return Notation{}, false
+	}
+	nameLen := int(binary.BigEndian.Uint16(b[4:]))
+	valueLen := int(binary.BigEndian.Uint16(b[6:]))
+	if len(b) != 8+nameLen+valueLen {
+		return Notation{}, false
+	}
+
+	return Notation{
+		Name:          string(b[8 : 8+nameLen]),
+		Value:         append([]byte(nil), b[8+nameLen:]...),
+		HumanReadable: b[0]&0x80 != 0,
+		Critical:      sub.critical,
+	}, true
+}
@@ -0,0 +1,95 @@
+// Package symcrypt decrypts passphrase-protected (symmetrically
+// encrypted) OpenPGP messages, RFC 4880, and reports how they were
+// protected.
+//
+// It parses the message itself, rather than going through
+// openpgp.ReadMessage, so that the cipher, S2K parameters and
+// integrity protection that were actually used can be reported and
+// subjected to policy. The cryptographic primitives come from the
+// standard library and golang.org/x/crypto.
+package symcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"strconv"
+
+	"golang.org/x/crypto/cast5"
+	"golang.org/x/crypto/twofish"
+)
+
+// Cipher is an OpenPGP symmetric cipher algorithm ID, RFC 4880
+// section 9.2.
+type Cipher uint8
+
+// Supported ciphers
+const (
+	Cipher3DES        Cipher = 2
+	CipherCAST5       Cipher = 3
+	CipherAES128      Cipher = 7
+	CipherAES192      Cipher = 8
+	CipherAES256      Cipher = 9
+	CipherTwofish     Cipher = 10
+	CipherCamellia128 Cipher = 11
+	CipherCamellia192 Cipher = 12
+	CipherCamellia256 Cipher = 13
+)
+
+type cipherInfo struct {
+	name    string
+	keySize int
+	new     func(key []byte) (cipher.Block, error)
+}
+
+var ciphers = map[Cipher]cipherInfo{
+	Cipher3DES:        {"3DES", 24, des.NewTripleDESCipher},
+	CipherCAST5:       {"CAST5", cast5.KeySize, newCAST5},
+	CipherAES128:      {"AES-128", 16, aes.NewCipher},
+	CipherAES192:      {"AES-192", 24, aes.NewCipher},
+	CipherAES256:      {"AES-256", 32, aes.NewCipher},
+	CipherTwofish:     {"Twofish", 32, newTwofish},
+	CipherCamellia128: {"Camellia-128", 16, newCamellia},
+	CipherCamellia192: {"Camellia-192", 24, newCamellia},
+	CipherCamellia256: {"Camellia-256", 32, newCamellia},
+}
+
+func newCAST5(key []byte) (cipher.Block, error) {
+	return cast5.NewCipher(key)
+}
+
+func newTwofish(key []byte) (cipher.Block, error) {
+	return twofish.NewCipher(key)
+}
+
+// Ciphers returns the ciphers that this package supports.
+func Ciphers() []Cipher {
+	return []Cipher{CipherAES128, CipherAES192, CipherAES256, CipherCAST5,
+		Cipher3DES, CipherTwofish, CipherCamellia128, CipherCamellia192,
+		CipherCamellia256}
+}
+
+func (c Cipher) String() string {
+	if info, ok := c.info(); ok {
+		return info.name
+	}
+
+	return "cipher(" + strconv.Itoa(int(c)) + ")"
+}
+
+// KeySize returns the key size of c in bytes, or 0 if c is neither
+// supported nor a legacy cipher.
+func (c Cipher) KeySize() int {
+	info, _ := c.info()
+	return info.keySize
+}
+
+func (c Cipher) supported() bool {
+	_, ok := ciphers[c]
+	return ok
+}
+
+func (c Cipher) newBlock(key []byte) (cipher.Block, error) {
+	info, _ := c.info()
+	return info.new(key)
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package symcrypt
+
+// The controlling terminal, which is prompted at when stdin is not it
+const (
+	terminalIn  = "/dev/tty"
+	terminalOut = "/dev/tty"
+)
@@ -0,0 +1,7 @@
+package symcrypt
+
+// The console, which is prompted at when stdin is not it
+const (
+	terminalIn  = "CONIN$"
+	terminalOut = "CONOUT$"
+)
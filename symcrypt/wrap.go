@@ -0,0 +1,193 @@
+package symcrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// WrapSessionKey returns a symmetric-key encrypted session key packet,
+// RFC 4880 section 5.3, that encrypts key, a session key for cipher c,
+// with passphrase: what NewEncryptWriter writes for the passphrase,
+// but for a session key that is already in use. Added to a message
+// encrypted with that key, before its encrypted data, the packet lets
+// the passphrase decrypt it too, without encrypting the data again,
+// e.g. to add a passphrase to a message or to escrow its key.
+//
+// The key is encrypted with the cipher of WithCipher, AES-256 by
+// default, and the iterated and salted S2K with SHA-256 and the count
+// of WithS2KCount, salted from WithRand. Other options are ignored.
+func WrapSessionKey(c Cipher, key, passphrase []byte, opts ...Option) ([]byte, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.applyFIPS()
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%w: empty passphrase", ErrNoPassphrase)
+	}
+	if !c.supported() {
+		return nil, fmt.Errorf("%w %v", ErrUnsupportedCipher, c)
+	}
+	if len(key) != c.KeySize() {
+		return nil, fmt.Errorf("symcrypt: session key of %d bytes for %v, which takes %d",
+			len(key), c, c.KeySize())
+	}
+
+	kc := cfg.cipher
+	if kc == 0 {
+		kc = defaultCipher
+	}
+	if !kc.supported() {
+		return nil, fmt.Errorf("%w %v", ErrUnsupportedCipher, kc)
+	}
+	for _, ci := range []Cipher{c, kc} {
+		if cfg.ciphers != nil && !cfg.ciphers[ci] {
+			return nil, fmt.Errorf("%w: %v", ErrCipherNotAllowed, ci)
+		}
+		if err := cfg.checkFIPS(ci, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	count := cfg.s2kCount
+	if count == 0 {
+		count = defaultS2KCount
+	}
+
+	return wrapSessionKey(c, key, passphrase, kc, count, cfg.random())
+}
+
+// wrapSessionKey returns the packet of WrapSessionKey, encrypting key
+// with cipher kc and the iterated and salted S2K with SHA-256 and
+// count, salted from rand.
+func wrapSessionKey(c Cipher, key, passphrase []byte, kc Cipher, count int, rand io.Reader) ([]byte, error) {
+	s := S2K{Mode: S2KIterated, Hash: crypto.SHA256, Salt: make([]byte, 8)}
+	if _, err := io.ReadFull(rand, s.Salt); err != nil {
+		return nil, err
+	}
+	countByte := encodeCount(count)
+	s.Count = decodeCount(countByte)
+
+	kek := make([]byte, kc.KeySize())
+	s.derive(kek, passphrase)
+	block, err := kc.newBlock(kek)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := append([]byte{byte(c)}, key...)
+	iv := make([]byte, block.BlockSize())
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, encrypted)
+
+	// Version 4, the cipher, the S2K specifier (SHA-256 is hash 8),
+	// and the encrypted session key
+	body := []byte{4, byte(kc), byte(S2KIterated), 8}
+	body = append(body, s.Salt...)
+	body = append(body, countByte)
+	body = append(body, encrypted...)
+
+	// A new format header, with a one-octet length: the body is never
+	// longer than 191 bytes
+	return append([]byte{0xc0 | tagSKESK, byte(len(body))}, body...), nil
+}
+
+// EncryptSessionKey returns a public-key encrypted session key packet,
+// RFC 4880 section 5.1, for each of to, that encrypts key, a session
+// key for cipher c, to its encryption key, as WithRecipients has them
+// written. Added to a message encrypted with that key, before its
+// encrypted data, the packets let the secret keys of to decrypt it, as
+// with WrapSessionKey for a passphrase: kept apart, they escrow it.
+func EncryptSessionKey(c Cipher, key []byte, to ...*openpgp.Entity) ([]byte, error) {
+	if !c.supported() {
+		return nil, fmt.Errorf("%w %v", ErrUnsupportedCipher, c)
+	}
+	if len(key) != c.KeySize() {
+		return nil, fmt.Errorf("symcrypt: session key of %d bytes for %v, which takes %d",
+			len(key), c, c.KeySize())
+	}
+
+	var buf bytes.Buffer
+	for _, e := range to {
+		pub, err := encryptionKey(e)
+		if err != nil {
+			return nil, err
+		}
+		if err := packet.SerializeEncryptedKey(&buf, pub, packet.CipherFunction(c), key, nil); err != nil {
+			return nil, fmt.Errorf("symcrypt: encrypt to %X: %w", pub.Fingerprint, err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnwrapSessionKey returns the session key, and its cipher, that the
+// symmetric-key encrypted session key packet pkt, with its header,
+// holds for passphrase: the inverse of WrapSessionKey, for any version
+// 4 packet, e.g. one of a message that has lost its encrypted data.
+// Only WithAllowedCiphers and WithFIPSMode apply.
+//
+// A wrong passphrase is almost always told with ErrWrongPassphrase.
+// A packet with no encrypted session key, whose key is the S2K output
+// itself, as gpg writes one for a single passphrase, yields a key for
+// any passphrase, which only decrypting the data can check.
+func UnwrapSessionKey(pkt, passphrase []byte, opts ...Option) (Cipher, []byte, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.applyFIPS()
+
+	r := bytes.NewReader(pkt)
+	tag, body, err := readPacket(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidMessage, unexpected(err))
+	}
+	if tag != tagSKESK {
+		return 0, nil, fmt.Errorf("%w: %s packet, not a symmetric-key encrypted session key",
+			ErrInvalidMessage, tagName(tag))
+	}
+	b, err := readBody(body, 1024)
+	if err != nil {
+		return 0, nil, unexpected(err)
+	}
+	if r.Len() != 0 {
+		return 0, nil, fmt.Errorf("%w: %d bytes after the packet", ErrInvalidMessage, r.Len())
+	}
+
+	sk, err := parseSKESK(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := cfg.checkFIPS(sk.cipher, sk.s2k.Hash); err != nil {
+		return 0, nil, err
+	}
+	c, key, err := sk.sessionKey(passphrase, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if cfg.ciphers != nil && !cfg.ciphers[c] {
+		return 0, nil, fmt.Errorf("%w: %v", ErrCipherNotAllowed, c)
+	}
+	if err := cfg.checkFIPS(c, 0); err != nil {
+		return 0, nil, err
+	}
+
+	return c, key, nil
+}
+
+// encodeCount encodes the iterated S2K count n, rounded up to the next
+// one that can be encoded, or to the largest, RFC 4880 section 3.7.1.3.
+func encodeCount(n int) byte {
+	for c := 0; c < 255; c++ {
+		if decodeCount(byte(c)) >= n {
+			return byte(c)
+		}
+	}
+
+	return 255
+}
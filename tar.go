@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// errTarStopped is what the decryption is stopped with once reading the
+// tar archive has failed.
+var errTarStopped = errors.New("reading the tar archive stopped")
+
+// decryptTar decrypts the message in fd, passing each member of the
+// tar archive, possibly gzipped, that it decrypts to, to member, as it
+// is decrypted. What follows the archive is read too, for the integrity
+// of the whole message to be checked. A failure to decrypt exits; the
+// error returned is that of reading the archive, or of member.
+func decryptTar(fd io.Reader, member func(*tar.Header, io.Reader) error) (symcrypt.Result, error) {
+	pr, pw := io.Pipe()
+	read := make(chan error, 1)
+	go func() {
+		err := readTar(pr, member)
+		if err != nil {
+			pr.CloseWithError(errTarStopped)
+		} else {
+			io.Copy(io.Discard, pr)
+		}
+		read <- err
+	}()
+
+	d := newDecryptor(passphrase)
+	res, err := d.Decrypt(pw, fd)
+	pw.CloseWithError(err)
+	tarErr := <-read
+	if err != nil && !errors.Is(err, errTarStopped) {
+		decryptFailed(&res, err)
+		copyFailed(fd, err)
+	}
+
+	return res, tarErr
+}
+
+func readTar(r io.Reader, member func(*tar.Header, io.Reader) error) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("the plain text is not a gzipped tar archive: %w", err)
+		}
+		br = bufio.NewReader(zr)
+	}
+
+	tr := tar.NewReader(br)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("the plain text is not a tar archive: %w", err)
+		}
+		if err := member(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// memberFilter selects the members of a tar archive with the -include
+// and -exclude patterns, of path.Match, which are matched against the
+// name of a member and each of the directories it is in, so that
+// including or excluding a directory does so to all that is in it.
+type memberFilter struct {
+	include, exclude stringList
+}
+
+func (f *memberFilter) flags(fs *flag.FlagSet) {
+	fs.Var(&f.include, "include",
+		"Only the members whose name, or a directory they are in, matches this pattern, e.g. 'home/*/.ssh'. May be repeated")
+	fs.Var(&f.exclude, "exclude",
+		"Leave out the members whose name, or a directory they are in, matches this pattern. May be repeated")
+}
+
+// check exits if a pattern is malformed.
+func (f *memberFilter) check() {
+	for _, p := range append(f.include[:len(f.include):len(f.include)], f.exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			exitf(exitUsage, "Bad pattern %q: %v", p, err)
+		}
+	}
+}
+
+// match tells whether the member named name is selected.
+func (f *memberFilter) match(name string) bool {
+	name = memberName(name)
+
+	return (len(f.include) == 0 || matchMember(f.include, name)) && !matchMember(f.exclude, name)
+}
+
+// matchMember tells whether one of patterns matches name, or one of the
+// directories it is in.
+func matchMember(patterns []string, name string) bool {
+	for _, p := range patterns {
+		p = memberName(p)
+		for prefix := name; ; {
+			if ok, _ := path.Match(p, prefix); ok {
+				return true
+			}
+			i := strings.LastIndexByte(prefix, '/')
+			if i < 0 {
+				break
+			}
+			prefix = prefix[:i]
+		}
+	}
+
+	return false
+}
+
+// memberName returns name relative to the top of the archive, cleaned,
+// as tar writes it with a leading ./ or /, or a trailing / for a
+// directory.
+func memberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// How long exporting the trace may delay the exit
+const traceExportTimeout = 5 * time.Second
+
+// A W3C trace context traceparent, as TRACEPARENT passes one in
+var traceParentRe = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// tracer records the run as an OpenTelemetry trace, a span for the
+// whole decryption and one for each phase of it, exported over OTLP
+// with HTTP and JSON when the run ends.
+type tracer struct {
+	mu       sync.Mutex
+	endpoint string
+	traceID  string
+	parentID string
+	rootID   string
+	start    time.Time
+	attrs    []otlpAttr
+	// The phases in the order they were first entered, when each was
+	// first entered and last left, and how long was spent in it
+	phases []string
+	spans  map[string]*phaseSpan
+	cur    string
+	since  time.Time
+	once   sync.Once
+}
+
+type phaseSpan struct {
+	start, end time.Time
+	busy       time.Duration
+}
+
+// tracing is the tracer of the run, or nil without -otlp-endpoint.
+var tracing *tracer
+
+// traceEndpoint returns where traces go: -otlp-endpoint, or the
+// endpoint the standard OpenTelemetry variables give.
+func traceEndpoint() string {
+	if otlpEndpoint != "" {
+		return otlpEndpoint
+	}
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); e != "" {
+		return e
+	}
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); e != "" {
+		return strings.TrimSuffix(e, "/") + "/v1/traces"
+	}
+
+	return ""
+}
+
+// startTracing starts the trace of the run, if there is an endpoint to
+// export it to, as a child of the span in TRACEPARENT if there is one.
+func startTracing() {
+	endpoint := traceEndpoint()
+	if endpoint == "" {
+		return
+	}
+
+	t := &tracer{endpoint: endpoint, start: time.Now(), spans: make(map[string]*phaseSpan)}
+	if m := traceParentRe.FindStringSubmatch(os.Getenv("TRACEPARENT")); m != nil {
+		t.traceID, t.parentID = m[1], m[2]
+	} else {
+		t.traceID = randomID(16)
+	}
+	t.rootID = randomID(8)
+	tracing = t
+	atExit(func() { t.finish(exitMessage) })
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// traceParent returns the traceparent header for requests made on
+// behalf of the run, or "" when it is not traced.
+func traceParent() string {
+	if tracing == nil {
+		return ""
+	}
+
+	return "00-" + tracing.traceID + "-" + tracing.rootID + "-01"
+}
+
+// phase records that the run went into phase.
+func (t *tracer) phase(phase string) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endPhase(now)
+	s, ok := t.spans[phase]
+	if !ok {
+		s = &phaseSpan{start: now}
+		t.spans[phase] = s
+		t.phases = append(t.phases, phase)
+	}
+	t.cur, t.since = phase, now
+}
+
+func (t *tracer) endPhase(now time.Time) {
+	if s, ok := t.spans[t.cur]; ok {
+		s.busy += now.Sub(t.since)
+		s.end = now
+	}
+	t.cur = ""
+}
+
+// attr adds an attribute to the span of the whole run.
+func (t *tracer) attr(key string, value interface{}) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attrs = append(t.attrs, newOTLPAttr(key, value))
+}
+
+// result adds what decryption found out about the message to the trace.
+func (t *tracer) result(res *symcrypt.Result) {
+	if t == nil || !res.Encrypted {
+		return
+	}
+
+	t.attr("openpgp.cipher", res.Cipher.String())
+	t.attr("openpgp.s2k", res.S2K.String())
+	t.attr("openpgp.integrity", res.Integrity)
+	t.attr("openpgp.bytes_in", res.BytesIn)
+	t.attr("openpgp.bytes_out", res.BytesOut)
+}
+
+// finish ends the trace, failed with msg unless it is empty, and
+// exports it. Only the first call does anything.
+func (t *tracer) finish(msg string) {
+	if t == nil {
+		return
+	}
+
+	t.once.Do(func() {
+		if err := t.export(msg); err != nil {
+			warnf("exporting the trace to %s: %v", t.endpoint, err)
+		}
+	})
+}
+
+func (t *tracer) export(msg string) error {
+	now := time.Now()
+	t.mu.Lock()
+	t.endPhase(now)
+	root := otlpSpan{
+		TraceID:      t.traceID,
+		SpanID:       t.rootID,
+		ParentSpanID: t.parentID,
+		Name:         "decrypt-symmetric",
+		Kind:         otlpSpanInternal,
+		Start:        unixNano(t.start),
+		End:          unixNano(now),
+		Attributes:   t.attrs,
+	}
+	if msg != "" {
+		root.Status = &otlpStatus{Code: otlpStatusError, Message: msg}
+	}
+	spans := []otlpSpan{root}
+	for _, name := range t.phases {
+		s := t.spans[name]
+		spans = append(spans, otlpSpan{
+			TraceID:      t.traceID,
+			SpanID:       randomID(8),
+			ParentSpanID: t.rootID,
+			Name:         name,
+			Kind:         otlpSpanInternal,
+			Start:        unixNano(s.start),
+			End:          unixNano(s.end),
+			// From when the phase was first entered to when it was
+			// last left; it may have been left and entered again
+			// many times in between, e.g. decrypt and write.
+			Attributes: []otlpAttr{newOTLPAttr("phase.busy_ns", int64(s.busy))},
+		})
+	}
+	t.mu.Unlock()
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "decrypt-symmetric"
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{newOTLPAttr("service.name", service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "decrypt-symmetric", Version: buildVersion()},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, h := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(h, "="); ok {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	return nil
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// The OTLP/JSON encoding of traces, of which only what is written here
+// is declared
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Span kind and status code
+const (
+	otlpSpanInternal = 1
+	otlpStatusError  = 2
+)
+
+type otlpSpan struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr  `json:"attributes,omitempty"`
+	Status       *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string `json:"stringValue,omitempty"`
+	Bool   *bool   `json:"boolValue,omitempty"`
+	// A decimal string, as OTLP/JSON has 64-bit integers
+	Int *string `json:"intValue,omitempty"`
+}
+
+func newOTLPAttr(key string, value interface{}) otlpAttr {
+	a := otlpAttr{Key: key}
+	switch v := value.(type) {
+	case bool:
+		a.Value.Bool = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		a.Value.Int = &s
+	default:
+		s := fmt.Sprint(v)
+		a.Value.String = &s
+	}
+
+	return a
+}
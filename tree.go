@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// treeEncryption is encrypt run on a directory, which encrypts each
+// file in it separately into a mirrored tree.
+type treeEncryption struct {
+	src, dst string
+	pass     []byte
+	opts     []symcrypt.Option
+	armored  bool
+	headers  []symcrypt.ArmorHeader
+	hideName bool
+	audit    *auditLog
+	manifest *manifest
+	jobs     int
+	keys     *sessionKeyLog
+	escrow   *keyEscrow
+
+	encrypted, unchanged, skipped, failed int
+}
+
+// newTreeEncryption checks that the -output, or -outdir, is a directory
+// to mirror the tree at src into, outside of it.
+func newTreeEncryption(src string) *treeEncryption {
+	if outDir != "" {
+		if len(outputs) != 0 {
+			exitf(exitUsage, "-outdir cannot be combined with -output")
+		}
+		outputs = stringList{outDir}
+	}
+	if len(outputs) != 1 || outputs[0] == "-" {
+		exitf(exitUsage, "Encrypting the directory %s needs one -output, or -outdir, the directory to mirror it into", src)
+	}
+	dst := outputs[0]
+	if _, ok := parseURL(dst); ok {
+		exitf(exitUsage, "Cannot mirror %s into the URL %s", src, dst)
+	}
+
+	absSrc, err1 := filepath.Abs(src)
+	absDst, err2 := filepath.Abs(dst)
+	if err1 != nil || err2 != nil {
+		fatalf("Output: %v", errors.Join(err1, err2))
+	}
+	if rel, err := filepath.Rel(absSrc, absDst); err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		exitf(exitUsage, "The -output %s is inside %s, whose files would be encrypted again", dst, src)
+	}
+
+	return &treeEncryption{src: src, dst: dst}
+}
+
+// run walks the source tree, and encrypts its files with t.jobs
+// workers. A file whose encrypted copy has its modification time was
+// encrypted by an earlier run and has not changed since, so it is left
+// as it is: encrypting it again would change every byte, and have sync
+// tools transfer it for nothing. A file that fails to encrypt is
+// reported, and the others are still encrypted.
+func (t *treeEncryption) run() {
+	ext := ".gpg"
+	if t.armored {
+		ext = ".asc"
+	}
+
+	files := make(chan treeFile)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < t.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				err := t.encryptFile(f)
+				mu.Lock()
+				if err != nil {
+					log.Printf("Encrypt %s: %v", f.path, err)
+					t.failed++
+				} else {
+					t.encrypted++
+					verbosef("Encrypted %s, %d files so far", f.path, t.encrypted)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	err := filepath.WalkDir(t.src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(t.src, path)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(t.dst, rel)
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(out, fi.Mode().Perm()|0o700)
+		case !fi.Mode().IsRegular():
+			log.Printf("Skipping %s, which is not a regular file", path)
+			t.manifest.note(path, "", "skipped", 0)
+			t.skipped++
+			return nil
+		}
+
+		out += ext
+		if ofi, err := os.Stat(out); err == nil && ofi.ModTime().Equal(fi.ModTime()) {
+			t.manifest.note(path, out, "unchanged", fi.Size())
+			t.unchanged++
+			return nil
+		}
+		files <- treeFile{path: path, out: out, fi: fi}
+		return nil
+	})
+	close(files)
+	wg.Wait()
+	if err != nil {
+		fatalf("Encrypt: %v", err)
+	}
+
+	log.Printf("Encrypted %d files, %d unchanged, %d skipped", t.encrypted, t.unchanged, t.skipped)
+	if t.failed != 0 {
+		fatalf("Encrypt: %d files failed", t.failed)
+	}
+}
+
+// treeFile is a file of the tree to encrypt, at path, to out.
+type treeFile struct {
+	path, out string
+	fi        fs.FileInfo
+}
+
+// encryptFile encrypts f, giving its encrypted copy its modification
+// time, and records the result in the manifest.
+func (t *treeEncryption) encryptFile(f treeFile) error {
+	rec := t.manifest.start(f.path, f.out)
+	err := t.encrypt(f, rec)
+	rec.done(err)
+
+	return err
+}
+
+func (t *treeEncryption) encrypt(f treeFile, rec *manifestRecord) error {
+	path, out, fi := f.path, f.out, f.fi
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	o, err := createOutput(out)
+	if err != nil {
+		return err
+	}
+	w := io.Writer(o)
+	var aw io.WriteCloser
+	if t.armored {
+		if aw, err = symcrypt.NewArmorWriter(o, t.headers...); err != nil {
+			o.discard()
+			return err
+		}
+		w = aw
+	}
+
+	opts := t.opts
+	if !t.hideName {
+		opts = append(opts[:len(opts):len(opts)],
+			symcrypt.WithFileName(filepath.Base(path)), symcrypt.WithModTime(fi.ModTime()))
+	}
+	if t.keys != nil {
+		opts = append(opts[:len(opts):len(opts)], symcrypt.WithSessionKeyFunc(t.keys.record(out)))
+	}
+	var escrowed *escrowedKey
+	if t.escrow != nil {
+		var opt symcrypt.Option
+		escrowed, opt = t.escrow.capture()
+		opts = append(opts[:len(opts):len(opts)], opt)
+	}
+	var src io.Reader = t.audit.reader(in)
+	if rec != nil {
+		src = io.TeeReader(src, rec)
+	}
+	err = symcrypt.EncryptContext(context.Background(), w, src, t.pass, opts...)
+	if err == nil && aw != nil {
+		err = aw.Close()
+	}
+	if err == nil && t.keys != nil {
+		err = t.keys.check()
+	}
+	var eo *outputFile
+	if err == nil && escrowed != nil {
+		eo, err = escrowed.write(out)
+	}
+	if err == nil {
+		err = o.commit()
+	}
+	if err == nil && eo != nil {
+		err = eo.commit()
+	}
+	if err != nil {
+		o.discard()
+		if eo != nil {
+			eo.discard()
+		}
+		return err
+	}
+
+	return os.Chtimes(out, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// The controlling terminal
+const ttyPath = "/dev/tty"
@@ -0,0 +1,4 @@
+package main
+
+// The console
+const ttyPath = "CONOUT$"
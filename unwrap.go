@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// Enough of the plain text to tell whether it is itself an encrypted
+// message: the armor header line, or the first packet's tag.
+var armorMessageStart = []byte("-----BEGIN PGP MESSAGE-----")
+
+// unwrapWriter passes the plain text written to it through to w, unless
+// it is another encrypted message, which is decrypted with d, as it is
+// written, into w instead, up to depth layers down.
+type unwrapWriter struct {
+	ctx   context.Context
+	w     io.Writer
+	d     *symcrypt.Decryptor
+	depth int
+	layer int
+
+	head    []byte
+	decided bool
+	// While an inner message is decrypted
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newUnwrapWriter(ctx context.Context, w io.Writer, d *symcrypt.Decryptor, depth int) *unwrapWriter {
+	return &unwrapWriter{ctx: ctx, w: w, d: d, depth: depth, layer: 1}
+}
+
+func (u *unwrapWriter) Write(p []byte) (int, error) {
+	if !u.decided {
+		u.head = append(u.head, p...)
+		if len(u.head) < len(armorMessageStart) {
+			return len(p), nil
+		}
+		if err := u.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if u.pw != nil {
+		return u.pw.Write(p)
+	}
+	return u.w.Write(p)
+}
+
+// decide looks at the start of the plain text, and either starts
+// decrypting it as an inner message or passes it on as it is.
+func (u *unwrapWriter) decide() error {
+	u.decided = true
+	head := u.head
+	u.head = nil
+
+	if u.depth <= 0 || !isEncryptedMessage(head) {
+		_, err := u.w.Write(head)
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	u.pw = pw
+	u.done = make(chan error, 1)
+	inner := &unwrapWriter{ctx: u.ctx, w: u.w, d: u.d, depth: u.depth - 1, layer: u.layer + 1}
+	go func() {
+		res, err := u.d.DecryptContext(u.ctx, inner, pr)
+		if err == nil {
+			err = inner.Close()
+		}
+		if err == nil {
+			verbosef("Layer %d was another encrypted message", inner.layer)
+			reportDecryption(&res)
+			if !res.Integrity {
+				warnf("layer %d was not integrity protected", inner.layer)
+			}
+			// Anything after the inner message is not plain text.
+			_, err = io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		u.done <- err
+	}()
+
+	_, err := pw.Write(head)
+	return err
+}
+
+// Close finishes the plain text, and the decryption of any inner
+// message, returning its error.
+func (u *unwrapWriter) Close() error {
+	if !u.decided {
+		if err := u.decide(); err != nil {
+			return err
+		}
+	}
+	if u.pw == nil {
+		return nil
+	}
+
+	u.pw.Close()
+	return <-u.done
+}
+
+// isEncryptedMessage tells whether b starts an ASCII armored message,
+// or a binary one with a session key packet.
+func isEncryptedMessage(b []byte) bool {
+	if bytes.HasPrefix(b, armorMessageStart) {
+		return true
+	}
+	if len(b) == 0 || b[0]&0x80 == 0 {
+		return false
+	}
+
+	tag := b[0] & 0x3f
+	if b[0]&0x40 == 0 {
+		// Old format
+		tag = (b[0] >> 2) & 0x0f
+	}
+	return tag == symcrypt.TagPKESK || tag == symcrypt.TagSKESK
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+func init() {
+	subcommands["verify-manifest"] = verifyManifest
+}
+
+// How a manifest entry turned out when it was checked again
+const (
+	entryOK        = "ok"
+	entryMissing   = "missing"
+	entryDrift     = "drift"
+	entryCorrupt   = "corrupt"
+	entryUnchecked = "unchecked"
+)
+
+// manifestCheck is verify-manifest checking the entries of a manifest.
+type manifestCheck struct {
+	decrypt bool
+	d       *symcrypt.Decryptor
+	// The passphrase for the formats other than OpenPGP, once asked
+	// for
+	pass []byte
+}
+
+// verifyManifest checks each entry of the manifest named in args that
+// was processed successfully against the files it names: that the
+// plain text still has its SHA-256 and size, or, with -decrypt, that
+// the cipher text still decrypts to them, printing a line for each
+// entry that does not, and a count of each outcome.
+func verifyManifest(args []string) int {
+	fs := flag.NewFlagSet("verify-manifest", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: decrypt-symmetric [flags] verify-manifest [verify-manifest flags] MANIFEST")
+		fs.PrintDefaults()
+	}
+	var c manifestCheck
+	fs.BoolVar(&c.decrypt, "decrypt", false,
+		"Decrypt the cipher text of each entry, writing nothing, instead of hashing its plain text")
+	configureSubcommand(fs, "verify-manifest", args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	entries, err := readManifest(fs.Arg(0))
+	if err != nil {
+		fatalf("Manifest: %v", err)
+	}
+	if c.decrypt {
+		c.d = newDecryptor(passphrase)
+	}
+
+	counts := make(map[string]int)
+	for _, e := range entries {
+		status, detail := c.check(e)
+		counts[status]++
+		if status != entryOK && status != entryUnchecked {
+			fmt.Printf("%s\t%s\t%s\n", status, quoteName(e.Input), detail)
+		} else if status == entryUnchecked {
+			verbosef("Not checking %s: %s", e.Input, detail)
+		}
+	}
+	log.Printf("Checked %d entries: %d ok, %d drifted, %d missing, %d corrupt, %d unchecked", len(entries),
+		counts[entryOK], counts[entryDrift], counts[entryMissing], counts[entryCorrupt], counts[entryUnchecked])
+
+	switch {
+	case counts[entryDrift] != 0 || counts[entryCorrupt] != 0:
+		return exitCorrupt
+	case counts[entryMissing] != 0:
+		return exitFailure
+	}
+
+	return 0
+}
+
+// check checks the entry e, returning its status and what is wrong.
+func (c *manifestCheck) check(e manifestEntry) (string, string) {
+	if e.Status != "ok" && e.Status != "unchanged" && e.Status != "overwritten" &&
+		e.Status != "numbered" && e.Status != "backup" {
+		return entryUnchecked, "its status is " + e.Status
+	}
+	plain, cipher := entrySides(e)
+	name := plain
+	if c.decrypt {
+		name = cipher
+	}
+	if name == "-" || name == "" || strings.Contains(name, "://") {
+		return entryUnchecked, "it was not a local file"
+	}
+
+	fd, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entryMissing, name + " does not exist"
+	}
+	if err != nil {
+		return entryMissing, err.Error()
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	cw := &countingWriter{w: h}
+	if c.decrypt {
+		if err := c.decryptEntry(cw, fd); err != nil {
+			return entryCorrupt, fmt.Sprintf("%s: %v", name, err)
+		}
+	} else if _, err := io.Copy(cw, fd); err != nil {
+		return entryMissing, err.Error()
+	}
+
+	if e.PlaintextSHA256 == "" {
+		if c.decrypt {
+			// It decrypted and passed its integrity check
+			return entryOK, ""
+		}
+		return entryUnchecked, "it has no digest"
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != e.PlaintextSHA256 || cw.n != e.PlaintextSize {
+		return entryDrift, fmt.Sprintf("%s: the plain text is %d bytes with SHA-256 %s, not %d bytes with %s",
+			name, cw.n, sum, e.PlaintextSize, e.PlaintextSHA256)
+	}
+
+	return entryOK, ""
+}
+
+// decryptEntry decrypts the cipher text in r, in any of the formats, to
+// w.
+func (c *manifestCheck) decryptEntry(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	format, _ := inputFormat(br)
+	if format == formatOpenPGP {
+		_, err := c.d.Decrypt(w, br)
+		return err
+	}
+
+	if c.pass == nil {
+		provider, _ := passphraseProvider(passphrase, "decrypt")
+		pass, err := provider.Passphrase()
+		if err != nil {
+			fatalf("%v", err)
+		}
+		c.pass = pass
+	}
+	switch format {
+	case formatOpenSSL:
+		return decryptOpenSSL(w, br, c.pass)
+	case formatSecretbox:
+		return decryptSecretbox(w, br, c.pass)
+	case formatScrypt:
+		return decryptScrypt(w, br, c.pass)
+	default:
+		return decryptCMS(w, br, c.pass)
+	}
+}
+
+// entrySides returns which of the input and output of e is the plain
+// text, and which the cipher text: as its operation says, or, in a
+// manifest that has none, the output only being the cipher text if it
+// is named as such.
+func entrySides(e manifestEntry) (string, string) {
+	encrypted := e.Operation == "encrypt"
+	if e.Operation == "" {
+		for _, ext := range cipherTextExts {
+			if strings.HasSuffix(strings.ToLower(e.Output), ext) {
+				encrypted = true
+			}
+		}
+	}
+	if encrypted {
+		return e.Input, e.Output
+	}
+
+	return e.Output, e.Input
+}
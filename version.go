@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// What this build can decrypt, for -version output
+var (
+	supportedCiphers  = []string{"AES-128", "AES-192", "AES-256", "CAST5", "3DES", "Twofish", "Camellia-128", "Camellia-192", "Camellia-256"}
+	legacyCiphers     = []string{"IDEA", "Blowfish"}
+	supportedS2KModes = []string{"simple", "salted", "iterated+salted"}
+	supportedFormats  = []string{"binary", "armored"}
+)
+
+// buildVersion returns the module version this was built as, e.g.
+// v1.2.0, or (devel).
+func buildVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+
+	return "(unknown)"
+}
+
+func printVersion(w io.Writer) {
+	version, revision := buildVersion(), "(unknown)"
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.time":
+				revision += " " + s.Value
+			case "vcs.modified":
+				if s.Value == "true" {
+					revision += " (modified)"
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "decrypt-symmetric %s\n", version)
+	fmt.Fprintf(w, "Revision:  %s\n", revision)
+	fmt.Fprintf(w, "Go:        %s %s/%s\n", runtime.Version(), runtime.GOOS,
+		runtime.GOARCH)
+	fmt.Fprintf(w, "Ciphers:   %s\n", strings.Join(supportedCiphers, ", "))
+	fmt.Fprintf(w, "Legacy:    %s, with -allow-legacy-ciphers\n", strings.Join(legacyCiphers, ", "))
+	fmt.Fprintf(w, "S2K modes: %s\n", strings.Join(supportedS2KModes, ", "))
+	fmt.Fprintf(w, "Formats:   %s\n", strings.Join(supportedFormats, ", "))
+	if symcrypt.FIPSMode() {
+		fmt.Fprintln(w, "FIPS mode: on")
+	}
+}
@@ -0,0 +1,22 @@
+// Thin wrapper around decsym.wasm. Load Go's wasm_exec.js first, from
+// "$(go env GOROOT)/lib/wasm/wasm_exec.js" of the Go that built it.
+//
+//	import { load } from "./decsym.js";
+//	const decsym = await load("decsym.wasm");
+//	const { plainText, integrity } = await decsym.decrypt(bytes, passphrase);
+
+export async function load(url) {
+	const go = new Go();
+	const { instance } = await WebAssembly.instantiateStreaming(fetch(url),
+		go.importObject);
+	go.run(instance);
+
+	return {
+		// Resolves to {plainText, fileName, integrity, cipher, s2k}
+		decrypt: (cipherText, passphrase) =>
+			globalThis.decsym.decrypt(cipherText, passphrase),
+		// Resolves to the encrypted message, a Uint8Array
+		encrypt: (plainText, passphrase) =>
+			globalThis.decsym.encrypt(plainText, passphrase),
+	};
+}
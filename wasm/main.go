@@ -0,0 +1,111 @@
+//go:build js && wasm
+
+// Command wasm exposes symcrypt to JavaScript, for decrypting
+// passphrase-encrypted blobs in the browser so that the passphrase
+// never leaves it. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o decsym.wasm ./wasm
+//
+// and load it with decsym.js, next to Go's wasm_exec.js.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"syscall/js"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+func main() {
+	js.Global().Set("decsym", map[string]any{
+		"decrypt": js.FuncOf(decrypt),
+		"encrypt": js.FuncOf(encrypt),
+	})
+
+	// The functions are only callable while main runs
+	select {}
+}
+
+// decrypt(cipherText Uint8Array, passphrase string) returns a promise
+// of {plainText, fileName, integrity, cipher, s2k}.
+func decrypt(this js.Value, args []js.Value) any {
+	return promise(func() (any, error) {
+		if len(args) != 2 {
+			return nil, errors.New("decsym.decrypt(cipherText, passphrase)")
+		}
+		in := bytesFromJS(args[0])
+
+		var out bytes.Buffer
+		d := symcrypt.NewDecryptor(symcrypt.WithPassphrase([]byte(args[1].String())))
+		res, err := d.Decrypt(&out, bytes.NewReader(in))
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]any{
+			"plainText": bytesToJS(out.Bytes()),
+			"fileName":  res.FileName,
+			"integrity": res.Integrity,
+			"cipher":    res.Cipher.String(),
+			"s2k":       res.S2K.String(),
+		}, nil
+	})
+}
+
+// encrypt(plainText Uint8Array, passphrase string) returns a promise
+// of the binary message as a Uint8Array.
+func encrypt(this js.Value, args []js.Value) any {
+	return promise(func() (any, error) {
+		if len(args) != 2 {
+			return nil, errors.New("decsym.encrypt(plainText, passphrase)")
+		}
+		in := bytesFromJS(args[0])
+
+		var out bytes.Buffer
+		w, err := symcrypt.NewEncryptWriter(&out, []byte(args[1].String()))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(in); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		return bytesToJS(out.Bytes()), nil
+	})
+}
+
+// promise runs f on its own goroutine, as blocking the event loop
+// would deadlock, and returns a promise of its result.
+func promise(f func() (any, error)) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, reject := args[0], args[1]
+		go func() {
+			v, err := f()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(v)
+		}()
+		return nil
+	})
+	defer executor.Release()
+
+	return js.Global().Get("Promise").New(executor)
+}
+
+func bytesFromJS(v js.Value) []byte {
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b
+}
+
+func bytesToJS(b []byte) js.Value {
+	v := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(v, b)
+	return v
+}
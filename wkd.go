@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// lookupWKD fetches the signing keys keyID of the address in userID
+// from its domain's Web Key Directory, draft-koch-openpgp-webkey-service
+// section 3.1: with the advanced method, from openpgpkey.DOMAIN, and
+// then with the direct method, from DOMAIN itself. Only keys with a
+// user ID of the address count. It returns where they were found.
+func lookupWKD(keyID uint64, userID string) ([]openpgp.Key, string, error) {
+	addr, err := mail.ParseAddress(userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("no email address in it: %v", err)
+	}
+	advanced, direct, err := wkdURLs(addr.Address)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var errs []string
+	for _, u := range []*url.URL{advanced, direct} {
+		kr, err := fetchKeys(u, false)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if keys := wkdKeys(kr, addr.Address, keyID); len(keys) != 0 {
+			return keys, u.Host, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: no signing key %016X for %s", u.Redacted(), keyID, addr.Address))
+	}
+
+	return nil, "", errors.New(strings.Join(errs, "; "))
+}
+
+// wkdURLs returns the URLs of the key of addr with the advanced and
+// the direct method.
+func wkdURLs(addr string) (advanced, direct *url.URL, err error) {
+	local, domain, ok := strings.Cut(addr, "@")
+	if !ok || local == "" || domain == "" || strings.ContainsAny(domain, "/?#@") {
+		return nil, nil, fmt.Errorf("bad email address %q", addr)
+	}
+	domain = strings.ToLower(domain)
+	sum := sha1.Sum([]byte(strings.ToLower(local)))
+	hu := zbase32(sum[:])
+	query := url.Values{"l": {local}}.Encode()
+
+	advanced = &url.URL{Scheme: "https", Host: "openpgpkey." + domain,
+		Path: "/.well-known/openpgpkey/" + domain + "/hu/" + hu, RawQuery: query}
+	direct = &url.URL{Scheme: "https", Host: domain,
+		Path: "/.well-known/openpgpkey/hu/" + hu, RawQuery: query}
+
+	return advanced, direct, nil
+}
+
+// wkdKeys returns the signing keys keyID in kr that belong to an
+// entity with a user ID of addr, as a WKD may serve other keys too.
+func wkdKeys(kr openpgp.EntityList, addr string, keyID uint64) []openpgp.Key {
+	var mine openpgp.EntityList
+	for _, e := range kr {
+		for _, id := range e.Identities {
+			if id.UserId != nil && strings.EqualFold(id.UserId.Email, addr) {
+				mine = append(mine, e)
+				break
+			}
+		}
+	}
+
+	return mine.KeysByIdUsage(keyID, packet.KeyFlagSign)
+}
+
+// z-base-32, the encoding of the hashed local part in WKD URLs
+const zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+func zbase32(b []byte) string {
+	var sb strings.Builder
+	var acc uint32
+	bits := 0
+	for _, c := range b {
+		acc = acc<<8 | uint32(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			sb.WriteByte(zbase32Alphabet[acc>>bits&31])
+		}
+	}
+	if bits > 0 {
+		sb.WriteByte(zbase32Alphabet[acc<<(5-bits)&31])
+	}
+
+	return sb.String()
+}
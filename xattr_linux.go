@@ -0,0 +1,177 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrsSupported is whether extract -preserve-xattrs is implemented.
+const xattrsSupported = true
+
+// restoreXattrs sets on f the extended attributes that records, the PAX
+// records of its member, hold: the SCHILY.xattr ones that tar --xattrs
+// and bsdtar write, file capabilities among them as security.capability,
+// and the POSIX ACLs that tar --acls writes in their text form. It goes
+// on past one that fails, and returns the errors of all that did.
+func restoreXattrs(f *os.File, records map[string]string) error {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, k := range keys {
+		var name string
+		var value []byte
+		switch {
+		case strings.HasPrefix(k, paxXattr):
+			name, value = k[len(paxXattr):], []byte(records[k])
+		case k == paxACLAccess || k == paxACLDefault:
+			name = "system.posix_acl_access"
+			if k == paxACLDefault {
+				name = "system.posix_acl_default"
+			}
+			var err error
+			if value, err = aclXattr(records[k]); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", k, err))
+				continue
+			}
+		default:
+			continue
+		}
+		if err := unix.Fsetxattr(int(f.Fd()), name, value, 0); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// The tags of POSIX ACL entries, as Linux has them in its xattrs
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+// aclXattr returns the system.posix_acl_* xattr of the ACL in text, in
+// the long or the short form of acl_to_text(3), with its entries on
+// lines or separated by commas, as GNU tar and bsdtar write it. A user
+// or group is taken by its ID where bsdtar appends one, and else looked
+// up by name.
+func aclXattr(text string) ([]byte, error) {
+	type entry struct {
+		tag  uint16
+		perm uint16
+		id   uint32
+	}
+	var entries []entry
+	for _, e := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' }) {
+		e, _, _ = strings.Cut(e, "#")
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		f := strings.Split(e, ":")
+		if len(f) < 3 || len(f) > 4 {
+			return nil, fmt.Errorf("bad ACL entry %q", e)
+		}
+		qualifier := f[1]
+		ent := entry{id: ^uint32(0)}
+		switch f[0] {
+		case "user", "u":
+			ent.tag = aclUserObj
+			if qualifier != "" {
+				ent.tag = aclUser
+			}
+		case "group", "g":
+			ent.tag = aclGroupObj
+			if qualifier != "" {
+				ent.tag = aclGroup
+			}
+		case "mask", "m":
+			ent.tag = aclMask
+		case "other", "o":
+			ent.tag = aclOther
+		default:
+			return nil, fmt.Errorf("bad ACL entry %q", e)
+		}
+		perm := f[2]
+		if len(perm) != 3 {
+			return nil, fmt.Errorf("bad ACL entry %q", e)
+		}
+		for i, c := range perm {
+			switch {
+			case c == rune("rwx"[i]):
+				ent.perm |= 4 >> i
+			case c != '-':
+				return nil, fmt.Errorf("bad ACL entry %q", e)
+			}
+		}
+		if ent.tag == aclUser || ent.tag == aclGroup {
+			if len(f) == 4 {
+				qualifier = f[3]
+			}
+			id, err := aclID(qualifier, ent.tag == aclGroup)
+			if err != nil {
+				return nil, err
+			}
+			ent.id = id
+		}
+		entries = append(entries, ent)
+	}
+	// The kernel only takes them in this order
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	buf := binary.LittleEndian.AppendUint32(nil, 2)
+	for _, e := range entries {
+		buf = binary.LittleEndian.AppendUint16(buf, e.tag)
+		buf = binary.LittleEndian.AppendUint16(buf, e.perm)
+		buf = binary.LittleEndian.AppendUint32(buf, e.id)
+	}
+
+	return buf, nil
+}
+
+// aclID returns the ID of the user, or the group, name, which may be
+// one already.
+func aclID(name string, group bool) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	var id string
+	if group {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return 0, err
+		}
+		id = g.Gid
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, err
+		}
+		id = u.Uid
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+
+	return uint32(n), err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func TestACLXattr(t *testing.T) {
+	// The entries of a system.posix_acl_* xattr, tag, perm and ID
+	const noID = ^uint32(0)
+	xattr := func(entries ...[3]uint32) []byte {
+		buf := binary.LittleEndian.AppendUint32(nil, 2)
+		for _, e := range entries {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(e[0]))
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(e[1]))
+			buf = binary.LittleEndian.AppendUint32(buf, e[2])
+		}
+		return buf
+	}
+	minimal := xattr([3]uint32{aclUserObj, 6, noID}, [3]uint32{aclGroupObj, 4, noID}, [3]uint32{aclOther, 4, noID})
+	named := xattr([3]uint32{aclUserObj, 7, noID}, [3]uint32{aclUser, 5, 1001}, [3]uint32{aclGroupObj, 5, noID},
+		[3]uint32{aclGroup, 1, 50}, [3]uint32{aclMask, 5, noID}, [3]uint32{aclOther, 0, noID})
+
+	for _, tt := range []struct {
+		name string
+		text string
+		want []byte
+	}{
+		{"GNU tar", "user::rw-\ngroup::r--\nother::r--\n", minimal},
+		{"short form", "u::rw-,g::r--,o::r--", minimal},
+		{"named, out of order", "other::---\nmask::r-x\ngroup:50:--x\ngroup::r-x\nuser:1001:r-x\nuser::rwx\n", named},
+		{"bsdtar, with IDs", "user::rwx,user:someone:r-x:1001,group::r-x,group:staff:--x:50,mask::r-x,other::---", named},
+		{"comments", "user::rw-\ngroup::r--\t#effective:r--\nother::r--\n", minimal},
+		{"bad tag", "owner::rw-", nil},
+		{"bad perms", "user::rw", nil},
+		{"perms out of order", "user::wr-", nil},
+		{"too many fields", "user:1:rw-:1:1", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := aclXattr(tt.text)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("got % x, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got  % x\nwant % x", got, tt.want)
+			}
+		})
+	}
+}
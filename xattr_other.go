@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// xattrsSupported is whether extract -preserve-xattrs is implemented,
+// which it is only for Linux.
+const xattrsSupported = false
+
+func restoreXattrs(*os.File, map[string]string) error {
+	return errors.New("not supported on this platform")
+}
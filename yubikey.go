@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/marete/decrypt-symmetric/symcrypt"
+)
+
+// With -passphrase-yubikey, the passphrase is not used as it is: its
+// SHA-256 is the challenge to the HMAC-SHA1 secret in a slot of a
+// YubiKey, and the response, in hex, is the passphrase the message is
+// encrypted with. Neither the passphrase nor the YubiKey alone opens
+// it, and guessing the passphrase takes the YubiKey too. ykchalresp,
+// from yubikey-personalization, does the challenge-response.
+
+// yubikeyResponse returns the passphrase derived from pass with the
+// YubiKey's slot.
+func yubikeyResponse(slot int, pass []byte) ([]byte, error) {
+	challenge := sha256.Sum256(pass)
+	cmd := exec.Command("ykchalresp", fmt.Sprintf("-%d", slot), "-x", hex.EncodeToString(challenge[:]))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("YubiKey slot %d: ykchalresp: %w", slot, err)
+	}
+
+	resp, err := hex.DecodeString(string(bytes.TrimSpace(out)))
+	if err != nil || len(resp) != 20 {
+		return nil, fmt.Errorf("YubiKey slot %d: ykchalresp gave no HMAC-SHA1 response", slot)
+	}
+
+	return []byte(hex.EncodeToString(resp)), nil
+}
+
+// yubikeyProvider supplies the passphrase derived from the one its
+// PassphraseProvider supplies.
+type yubikeyProvider struct {
+	symcrypt.PassphraseProvider
+	slot int
+}
+
+func (yp yubikeyProvider) Passphrase() ([]byte, error) {
+	pass, err := yp.PassphraseProvider.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(pass)
+
+	return yubikeyResponse(yp.slot, pass)
+}
+
+func (yp yubikeyProvider) Forget() {
+	if f, ok := yp.PassphraseProvider.(symcrypt.Forgetter); ok {
+		f.Forget()
+	}
+}